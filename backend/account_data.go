@@ -0,0 +1,325 @@
+package main
+
+import (
+    "archive/zip"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "golang.org/x/crypto/bcrypt"
+)
+
+// queryRecords runs query and returns each row as a column -> value map,
+// ready to encode as JSON.
+func queryRecords(db *sql.DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    columns, err := rows.Columns()
+    if err != nil {
+        return nil, err
+    }
+
+    records := []map[string]interface{}{}
+    for rows.Next() {
+        values := make([]interface{}, len(columns))
+        pointers := make([]interface{}, len(columns))
+        for i := range values {
+            pointers[i] = &values[i]
+        }
+        if err := rows.Scan(pointers...); err != nil {
+            return nil, err
+        }
+        record := map[string]interface{}{}
+        for i, column := range columns {
+            value := values[i]
+            // JSONB and NUMERIC come back as bytes
+            if b, ok := value.([]byte); ok {
+                if json.Valid(b) && (strings.HasPrefix(string(b), "{") || strings.HasPrefix(string(b), "[")) {
+                    value = json.RawMessage(b)
+                } else {
+                    value = string(b)
+                }
+            }
+            record[column] = value
+        }
+        records = append(records, record)
+    }
+    return records, rows.Err()
+}
+
+// uploadedFilePath maps a photo URL to its file under uploads/, or "" for
+// photos hosted elsewhere.
+func uploadedFilePath(photoURL string) string {
+    if !strings.Contains(photoURL, "localhost:8081/uploads/") {
+        return ""
+    }
+    parts := strings.Split(photoURL, "/")
+    return fmt.Sprintf("uploads/%s", parts[len(parts)-1])
+}
+
+func removeUploadedFile(photoURL string) {
+    path := uploadedFilePath(photoURL)
+    if path == "" {
+        return
+    }
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        log.Printf("Warning: Failed to delete physical file %s: %v", path, err)
+    }
+}
+
+// The files in a data export and the queries that fill them. Each takes
+// the user ID as $1.
+var accountExportSections = []struct {
+    File  string
+    Query string
+}{
+    {"profile.json", `
+        SELECT id, name, email, phone, wash_count, role, status, email_verified_at, last_login, created_at, updated_at
+        FROM users WHERE id = $1`},
+    {"notification_preferences.json", `
+        SELECT email_notifications, sms_notifications, push_notifications, appointment_reminders, status_updates, updated_at
+        FROM notification_preferences WHERE user_id = $1`},
+    {"pets.json", `
+        SELECT id, name, breed, size, notes, created_at FROM pets WHERE user_id = $1 ORDER BY id`},
+    {"appointments.json", `
+        SELECT a.id, p.name AS pet_name, s.name AS service_name, to_char(a.appointment_date, 'YYYY-MM-DD') AS appointment_date,
+               to_char(a.appointment_time, 'HH24:MI') AS appointment_time, a.status, a.notes, a.created_at
+        FROM appointments a
+        JOIN pets p ON a.pet_id = p.id
+        JOIN services s ON a.service_id = s.id
+        WHERE a.user_id = $1
+        ORDER BY a.appointment_date, a.appointment_time`},
+    {"payments.json", `
+        SELECT id, appointment_id, amount, currency, status, payment_type, created_at
+        FROM payments WHERE user_id = $1 ORDER BY created_at`},
+    {"photos.json", `
+        SELECT pp.id, pp.pet_id, p.name AS pet_name, pp.photo_url, pp.photo_type, pp.caption, pp.created_at
+        FROM pet_photos pp JOIN pets p ON pp.pet_id = p.id
+        WHERE p.user_id = $1 ORDER BY pp.created_at`},
+    {"comments.json", `
+        SELECT id, photo_id, comment_text, created_at FROM photo_comments WHERE user_id = $1 ORDER BY created_at`},
+    {"likes.json", `
+        SELECT photo_id, created_at FROM photo_likes WHERE user_id = $1 ORDER BY created_at`},
+}
+
+// writeAccountExport streams a ZIP of the user's data, including any photo
+// files stored in uploads/.
+func writeAccountExport(db *sql.DB, w io.Writer, userID int) error {
+    archive := zip.NewWriter(w)
+
+    var photoURLs []string
+    for _, section := range accountExportSections {
+        records, err := queryRecords(db, section.Query, userID)
+        if err != nil {
+            return fmt.Errorf("%s: %w", section.File, err)
+        }
+        if section.File == "photos.json" {
+            for _, r := range records {
+                if url, ok := r["photo_url"].(string); ok {
+                    photoURLs = append(photoURLs, url)
+                }
+            }
+        }
+
+        var data interface{} = records
+        if section.File == "profile.json" && len(records) == 1 {
+            data = records[0]
+        }
+        f, err := archive.Create(section.File)
+        if err != nil {
+            return err
+        }
+        encoder := json.NewEncoder(f)
+        encoder.SetIndent("", "  ")
+        if err := encoder.Encode(data); err != nil {
+            return err
+        }
+    }
+
+    for _, url := range photoURLs {
+        path := uploadedFilePath(url)
+        if path == "" {
+            continue
+        }
+        src, err := os.Open(path)
+        if err != nil {
+            log.Printf("Warning: Skipping missing photo %s in export: %v", path, err)
+            continue
+        }
+        dst, err := archive.Create("photos/" + filepath.Base(path))
+        if err == nil {
+            _, err = io.Copy(dst, src)
+        }
+        src.Close()
+        if err != nil {
+            return err
+        }
+    }
+
+    return archive.Close()
+}
+
+// deleteAccount anonymizes the user in one transaction. Payments and the
+// appointments they point at are kept for tax retention with the personal
+// details stripped; photos, comments and everything else tied to the person
+// goes. The audit trigger on users keeps a full copy of the row on every
+// change, so those snapshots are blanked too, as are the addresses the user
+// made requests from. Payment metadata only holds service and pet IDs. It
+// returns the photo URLs whose files should be removed.
+func deleteAccount(db *sql.DB, userID int, deletedBy *int) ([]string, error) {
+    today := businessToday(db)
+    var photoURLs []string
+    err := withChangeContext(db, deletedBy, "Account deleted", func(tx *sql.Tx) error {
+        rows, err := tx.Query(`
+            DELETE FROM pet_photos WHERE pet_id IN (SELECT id FROM pets WHERE user_id = $1)
+            RETURNING photo_url
+        `, userID)
+        if err != nil {
+            return err
+        }
+        for rows.Next() {
+            var url string
+            if err := rows.Scan(&url); err == nil {
+                photoURLs = append(photoURLs, url)
+            }
+        }
+        rows.Close()
+
+        statements := []string{
+            "DELETE FROM photo_comments WHERE user_id = $1",
+            "DELETE FROM photo_likes WHERE user_id = $1",
+            "DELETE FROM photo_albums WHERE user_id = $1",
+            "DELETE FROM notifications WHERE user_id = $1",
+            "DELETE FROM notification_outbox WHERE user_id = $1",
+            "DELETE FROM notification_preferences WHERE user_id = $1",
+            "DELETE FROM push_subscriptions WHERE user_id = $1",
+            "DELETE FROM sms_messages WHERE user_id = $1",
+            "DELETE FROM waitlist_entries WHERE user_id = $1",
+            "DELETE FROM account_tokens WHERE user_id = $1",
+            "DELETE FROM user_totp WHERE user_id = $1",
+            "DELETE FROM totp_recovery_codes WHERE user_id = $1",
+            "DELETE FROM password_history WHERE user_id = $1",
+            "DELETE FROM user_sessions WHERE user_id = $1",
+            "DELETE FROM customer_notes WHERE user_id = $1",
+            "DELETE FROM customer_tags WHERE user_id = $1",
+            "UPDATE appointment_series SET status = 'cancelled', notes = NULL, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1",
+            "UPDATE appointments SET notes = NULL, followup_reason = NULL WHERE user_id = $1",
+            "UPDATE pets SET name = 'Deleted pet', breed = NULL, notes = NULL WHERE user_id = $1",
+            "UPDATE diy_queue SET customer_name = 'Deleted customer' WHERE user_id = $1",
+            `UPDATE users
+             SET name = 'Deleted customer', email = 'deleted-' || id || '@deleted.invalid', phone = NULL,
+                 password = '', status = 'deleted', email_verified_at = NULL, deleted_at = CURRENT_TIMESTAMP,
+                 updated_at = CURRENT_TIMESTAMP
+             WHERE id = $1`,
+            // After the users UPDATE, so the snapshot it just logged goes too
+            "UPDATE audit_logs SET old_data = NULL, new_data = NULL WHERE table_name = 'users' AND record_id = $1",
+            "UPDATE audit_logs SET ip_address = NULL, user_agent = NULL WHERE user_id = $1",
+        }
+        for _, statement := range statements {
+            if _, err := tx.Exec(statement, userID); err != nil {
+                return err
+            }
+        }
+
+        // Upcoming bookings free their slots; past ones stay for the books
+        _, err = tx.Exec(`
+            UPDATE appointments SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+            WHERE user_id = $1 AND status IN ('pending', 'confirmed') AND appointment_date >= $2
+        `, userID, today)
+        return err
+    })
+    return photoURLs, err
+}
+
+func registerAccountDataRoutes(api *gin.RouterGroup, db *sql.DB) {
+    // "Send me my data": a ZIP of JSON files plus uploaded photos
+    api.GET("/users/:id/export", func(c *gin.Context) {
+        if !authorizeUserParam(c, "customer_management") {
+            return
+        }
+        userID, _ := strconv.Atoi(c.Param("id"))
+
+        var status string
+        if err := db.QueryRow("SELECT COALESCE(status, 'active') FROM users WHERE id = $1", userID).Scan(&status); err != nil || status == "deleted" {
+            c.JSON(404, gin.H{"error": "User not found"})
+            return
+        }
+
+        c.Header("Content-Type", "application/zip")
+        c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d-%s.zip"`, userID, time.Now().Format("20060102")))
+        if err := writeAccountExport(db, c.Writer, userID); err != nil {
+            // Headers are gone by now, so all we can do is log and cut the stream short
+            log.Printf("Failed to export account %d: %v", userID, err)
+            c.Abort()
+            return
+        }
+        writeAuditLog(db, c, actorID(c), "account_exported", "users", &userID, nil)
+    })
+
+    // "Delete my account". Customers confirm with their password; staff with
+    // customer_management can delete on a customer's behalf.
+    api.DELETE("/users/:id", func(c *gin.Context) {
+        if !authorizeUserParam(c, "customer_management") {
+            return
+        }
+        caller := callerFrom(c)
+        if caller.ImpersonatorID != nil {
+            c.JSON(403, gin.H{"error": "Not available while acting as a customer", "code": "impersonation_blocked"})
+            return
+        }
+        userID, _ := strconv.Atoi(c.Param("id"))
+
+        var req struct {
+            Password string `json:"password"`
+        }
+        c.ShouldBindJSON(&req)
+
+        var role, status, hashedPassword string
+        err := db.QueryRow(`
+            SELECT COALESCE(role, 'customer'), COALESCE(status, 'active'), COALESCE(password, '') FROM users WHERE id = $1
+        `, userID).Scan(&role, &status, &hashedPassword)
+        if err != nil || status == "deleted" {
+            c.JSON(404, gin.H{"error": "User not found"})
+            return
+        }
+        if role != "customer" {
+            c.JSON(400, gin.H{"error": "Staff accounts are removed from user management, not deleted here"})
+            return
+        }
+        if caller.UserID == userID {
+            if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
+                c.JSON(401, gin.H{"error": "Password is incorrect"})
+                return
+            }
+        }
+
+        photoURLs, err := deleteAccount(db, userID, actorID(c))
+        if err != nil {
+            log.Printf("Failed to delete account %d: %v", userID, err)
+            c.JSON(500, gin.H{"error": "Failed to delete account"})
+            return
+        }
+        for _, url := range photoURLs {
+            removeUploadedFile(url)
+        }
+
+        writeAuditLog(db, c, actorID(c), "account_deleted", "users", &userID, gin.H{
+            "self_service":   caller.UserID == userID,
+            "photos_removed": len(photoURLs),
+        })
+
+        c.JSON(200, gin.H{"message": "Account deleted"})
+    })
+}
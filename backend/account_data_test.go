@@ -0,0 +1,46 @@
+package main
+
+import (
+    "database/sql/driver"
+    "strings"
+    "testing"
+)
+
+func TestDeleteAccountScrubsAuditSnapshots(t *testing.T) {
+    db, f := newFakeDB(t)
+    scriptSettings(f, nil)
+    f.on("DELETE FROM pet_photos", []string{"photo_url"}, func(args []driver.Value) [][]driver.Value {
+        return row("/uploads/bella.jpg")
+    })
+    f.onExec("", func(args []driver.Value) (int64, error) { return 1, nil })
+
+    userID := 7
+    photos, err := deleteAccount(db, userID, &userID)
+    if err != nil {
+        t.Fatalf("deleteAccount: %v", err)
+    }
+    if len(photos) != 1 {
+        t.Errorf("got photos %v", photos)
+    }
+
+    var anonymized, snapshots, addresses int
+    for i, call := range f.ran("") {
+        switch {
+        case strings.HasPrefix(call.query, "UPDATE users SET name = 'Deleted customer'"):
+            anonymized = i
+        case strings.Contains(call.query, "UPDATE audit_logs SET old_data = NULL, new_data = NULL WHERE table_name = 'users' AND record_id = $1"):
+            snapshots = i
+        case strings.Contains(call.query, "UPDATE audit_logs SET ip_address = NULL, user_agent = NULL WHERE user_id = $1"):
+            addresses = i
+        }
+    }
+    if anonymized == 0 || snapshots == 0 || addresses == 0 {
+        t.Fatalf("missing statements: users %d, audit snapshots %d, audit addresses %d", anonymized, snapshots, addresses)
+    }
+    if snapshots < anonymized {
+        t.Errorf("audit snapshots scrubbed before the users update logged its own")
+    }
+    if f.commits != 1 {
+        t.Errorf("got %d commits, want everything in one transaction", f.commits)
+    }
+}
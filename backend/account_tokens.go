@@ -0,0 +1,421 @@
+package main
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "net/url"
+    "os"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "golang.org/x/crypto/bcrypt"
+)
+
+const (
+    tokenPasswordReset     = "password_reset"
+    tokenEmailVerification = "email_verification"
+    tokenAccountInvite     = "account_invite"
+)
+
+// Imported customers with no email on file get a placeholder address in
+// this domain until they claim the account and give us a real one.
+const placeholderEmailDomain = "@imported.invalid"
+
+type PasswordResetRequest struct {
+    Email string `json:"email" binding:"required,email"`
+}
+
+type PasswordResetConfirmRequest struct {
+    Token    string `json:"token" binding:"required"`
+    Password string `json:"password" binding:"required"`
+}
+
+type EmailVerificationConfirmRequest struct {
+    Token string `json:"token" binding:"required"`
+}
+
+type AccountInviteClaimRequest struct {
+    Token    string `json:"token" binding:"required"`
+    Password string `json:"password" binding:"required"`
+    Email    string `json:"email" binding:"omitempty,email"` // required when the account has a placeholder email
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// issueAccountToken creates a single-use token for purpose and returns the
+// plaintext, which only ever goes out in the email. Older unused tokens for
+// the same purpose stop working.
+func issueAccountToken(db *sql.DB, userID int, purpose string, ttlMinutes int) (string, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return "", err
+    }
+    token := base64.RawURLEncoding.EncodeToString(raw)
+
+    tx, err := db.Begin()
+    if err != nil {
+        return "", err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`
+        UPDATE account_tokens SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+    `, userID, purpose)
+    if err != nil {
+        return "", err
+    }
+    _, err = tx.Exec(`
+        INSERT INTO account_tokens (user_id, purpose, token_hash, expires_at, created_at)
+        VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(mins => $4), CURRENT_TIMESTAMP)
+    `, userID, purpose, hashToken(token), ttlMinutes)
+    if err != nil {
+        return "", err
+    }
+    return token, tx.Commit()
+}
+
+// redeemAccountToken marks a token used and returns its user. Used, expired
+// and unknown tokens all come back as sql.ErrNoRows.
+func redeemAccountToken(db *sql.DB, token string, purpose string) (int, error) {
+    var userID int
+    err := db.QueryRow(`
+        UPDATE account_tokens SET used_at = CURRENT_TIMESTAMP
+        WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+        RETURNING user_id
+    `, hashToken(token), purpose).Scan(&userID)
+    return userID, err
+}
+
+// appLink builds a link into the frontend, e.g. appLink("/reset-password", token).
+func appLink(path string, token string) string {
+    base := os.Getenv("APP_BASE_URL")
+    if base == "" {
+        base = "http://localhost:3000"
+    }
+    return strings.TrimRight(base, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func sendPasswordResetEmail(db *sql.DB, userID int, name string, email string) error {
+    ttl := getSettingInt(db, "security", "password_reset_minutes", 60)
+    token, err := issueAccountToken(db, userID, tokenPasswordReset, ttl)
+    if err != nil {
+        return err
+    }
+    business := getSetting(db, "business", "business_name", "Jake's Bath House")
+    body := fmt.Sprintf("Hi %s,\n\nWe got a request to reset your %s password. Use the link below within %d minutes:\n\n%s\n\n"+
+        "If you didn't ask for this, you can ignore this email and your password won't change.\n\n%s\n",
+        name, business, ttl, appLink("/reset-password", token), business)
+    return sendEmail(email, "Reset your password", body)
+}
+
+func sendVerificationEmail(db *sql.DB, userID int, name string, email string) error {
+    ttl := getSettingInt(db, "security", "email_verification_hours", 48) * 60
+    token, err := issueAccountToken(db, userID, tokenEmailVerification, ttl)
+    if err != nil {
+        return err
+    }
+    business := getSetting(db, "business", "business_name", "Jake's Bath House")
+    body := fmt.Sprintf("Hi %s,\n\nWelcome to %s! Please confirm your email address:\n\n%s\n\n%s\n",
+        name, business, appLink("/verify-email", token), business)
+    return sendEmail(email, "Confirm your email address", body)
+}
+
+// sendAccountInvite sends an imported customer a link to claim their
+// account and set a password, by email or, without a real email, by SMS.
+func sendAccountInvite(db *sql.DB, userID int, name string, email string, phone string) error {
+    ttl := getSettingInt(db, "security", "account_invite_days", 14) * 24 * 60
+    token, err := issueAccountToken(db, userID, tokenAccountInvite, ttl)
+    if err != nil {
+        return err
+    }
+    business := getSetting(db, "business", "business_name", "Jake's Bath House")
+    link := appLink("/claim-account", token)
+
+    if !strings.HasSuffix(email, placeholderEmailDomain) {
+        body := fmt.Sprintf("Hi %s,\n\n%s has moved online! Your pets and visit history are already set up. "+
+            "Choose a password to start booking:\n\n%s\n\n%s\n", name, business, link, business)
+        return sendEmail(email, "Your "+business+" account is ready", body)
+    }
+    if phone == "" {
+        return fmt.Errorf("user %d has no email or phone to invite", userID)
+    }
+    body := fmt.Sprintf("%s: your account is ready. Set a password to start booking online: %s", business, link)
+    if err := notifier.sms.SendSMS(phone, body); err != nil {
+        return err
+    }
+    logSMSMessage(db, "outbound", phone, &userID, nil, body, "account_invite")
+    return nil
+}
+
+// requireVerifiedEmail stops unverified customers from booking when
+// security.require_email_verification is on. It writes the response and
+// returns false when the request should stop.
+func requireVerifiedEmail(c *gin.Context, db *sql.DB, userID int) bool {
+    if !getSettingBool(db, "security", "require_email_verification", false) {
+        return true
+    }
+    var verified bool
+    db.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified)
+    if !verified {
+        c.JSON(403, gin.H{"error": "Please verify your email address before booking", "code": "email_not_verified"})
+        return false
+    }
+    return true
+}
+
+func registerAccountTokenRoutes(api *gin.RouterGroup, db *sql.DB) {
+    api.POST("/password-reset/request", func(c *gin.Context) {
+        var req PasswordResetRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        var userID int
+        var name, status string
+        err := db.QueryRow("SELECT id, name, status FROM users WHERE LOWER(email) = LOWER($1)", req.Email).Scan(&userID, &name, &status)
+        if err == nil && status == "active" {
+            if err := sendPasswordResetEmail(db, userID, name, req.Email); err != nil {
+                log.Printf("Failed to send password reset email: %v", err)
+            }
+        }
+
+        // Same answer either way so this can't be used to probe for accounts
+        c.JSON(200, gin.H{"message": "If an account exists for that email, a reset link is on its way"})
+    })
+
+    api.POST("/password-reset/confirm", func(c *gin.Context) {
+        var req PasswordResetConfirmRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        // Check the new password before spending the token so a rejected
+        // password doesn't force the customer to request another link
+        var userID int
+        var email, oldHash string
+        err := db.QueryRow(`
+            SELECT u.id, u.email, COALESCE(u.password, '') FROM account_tokens t
+            JOIN users u ON t.user_id = u.id
+            WHERE t.token_hash = $1 AND t.purpose = $2 AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP
+        `, hashToken(req.Token), tokenPasswordReset).Scan(&userID, &email, &oldHash)
+        if err == sql.ErrNoRows {
+            c.JSON(400, gin.H{"error": "This reset link is invalid or has expired"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to look up password reset token: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to reset password"})
+            return
+        }
+
+        if !validatePassword(c, db, &userID, req.Password, email) {
+            return
+        }
+
+        if _, err := redeemAccountToken(db, req.Token, tokenPasswordReset); err != nil {
+            if err == sql.ErrNoRows {
+                c.JSON(400, gin.H{"error": "This reset link is invalid or has expired"})
+                return
+            }
+            log.Printf("Failed to redeem password reset token: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to reset password"})
+            return
+        }
+
+        hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to hash password"})
+            return
+        }
+
+        // Following the emailed link also proves the address works
+        _, err = db.Exec(`
+            UPDATE users
+            SET password = $1, email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+            WHERE id = $2
+        `, string(hashedPassword), userID)
+        if err != nil {
+            log.Printf("Failed to update password: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to reset password"})
+            return
+        }
+        recordPasswordHistory(db, userID, oldHash)
+        revokeUserSessions(db, userID)
+
+        c.JSON(200, gin.H{"message": "Password updated successfully"})
+    })
+
+    api.POST("/email-verification/request", func(c *gin.Context) {
+        var req PasswordResetRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        var userID int
+        var name string
+        var verified bool
+        err := db.QueryRow(`
+            SELECT id, name, email_verified_at IS NOT NULL FROM users WHERE LOWER(email) = LOWER($1)
+        `, req.Email).Scan(&userID, &name, &verified)
+        if err == nil && !verified {
+            if err := sendVerificationEmail(db, userID, name, req.Email); err != nil {
+                log.Printf("Failed to send verification email: %v", err)
+            }
+        }
+
+        c.JSON(200, gin.H{"message": "If that address needs verifying, a link is on its way"})
+    })
+
+    api.POST("/email-verification/confirm", func(c *gin.Context) {
+        var req EmailVerificationConfirmRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        userID, err := redeemAccountToken(db, req.Token, tokenEmailVerification)
+        if err == sql.ErrNoRows {
+            c.JSON(400, gin.H{"error": "This verification link is invalid or has expired"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to redeem verification token: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to verify email"})
+            return
+        }
+
+        _, err = db.Exec(`
+            UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, userID)
+        if err != nil {
+            log.Printf("Failed to mark email verified: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to verify email"})
+            return
+        }
+
+        c.JSON(200, gin.H{"message": "Email verified successfully", "user_id": userID})
+    })
+
+    // Who an invite link is for, so the claim page can greet them
+    api.GET("/account-invite", func(c *gin.Context) {
+        var name, email string
+        err := db.QueryRow(`
+            SELECT u.name, u.email FROM account_tokens t
+            JOIN users u ON t.user_id = u.id
+            WHERE t.token_hash = $1 AND t.purpose = $2 AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP
+        `, hashToken(c.Query("token")), tokenAccountInvite).Scan(&name, &email)
+        if err != nil {
+            c.JSON(400, gin.H{"error": "This invite link is invalid or has expired"})
+            return
+        }
+        needsEmail := strings.HasSuffix(email, placeholderEmailDomain)
+        if needsEmail {
+            email = ""
+        }
+        c.JSON(200, gin.H{"name": name, "email": email, "email_required": needsEmail})
+    })
+
+    api.POST("/account-invite/claim", func(c *gin.Context) {
+        var req AccountInviteClaimRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        var userID int
+        var email, currentHash string
+        err := db.QueryRow(`
+            SELECT u.id, u.email, COALESCE(u.password, '') FROM account_tokens t
+            JOIN users u ON t.user_id = u.id
+            WHERE t.token_hash = $1 AND t.purpose = $2 AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP
+        `, hashToken(req.Token), tokenAccountInvite).Scan(&userID, &email, &currentHash)
+        if err == sql.ErrNoRows {
+            c.JSON(400, gin.H{"error": "This invite link is invalid or has expired"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to look up account invite: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to claim account"})
+            return
+        }
+        if currentHash != "" {
+            c.JSON(400, gin.H{"error": "This account has already been claimed. Sign in or reset your password"})
+            return
+        }
+
+        // Phone-only imports pick their email now
+        emailVerified := true
+        if strings.HasSuffix(email, placeholderEmailDomain) {
+            if req.Email == "" {
+                c.JSON(400, gin.H{"error": "Email is required"})
+                return
+            }
+            var taken bool
+            db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))", req.Email).Scan(&taken)
+            if taken {
+                c.JSON(400, gin.H{"error": "User already exists with this email"})
+                return
+            }
+            email = req.Email
+            emailVerified = false
+        }
+
+        if !validatePassword(c, db, &userID, req.Password, email) {
+            return
+        }
+        if _, err := redeemAccountToken(db, req.Token, tokenAccountInvite); err != nil {
+            if err == sql.ErrNoRows {
+                c.JSON(400, gin.H{"error": "This invite link is invalid or has expired"})
+                return
+            }
+            log.Printf("Failed to redeem account invite: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to claim account"})
+            return
+        }
+
+        hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to hash password"})
+            return
+        }
+
+        // An emailed invite proves the address; a texted one doesn't
+        _, err = db.Exec(`
+            UPDATE users
+            SET password = $1, email = $2,
+                email_verified_at = CASE WHEN $3 THEN COALESCE(email_verified_at, CURRENT_TIMESTAMP) ELSE email_verified_at END,
+                claimed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $4
+        `, string(hashedPassword), email, emailVerified, userID)
+        if err != nil {
+            log.Printf("Failed to claim account: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to claim account"})
+            return
+        }
+
+        if !emailVerified {
+            var name string
+            db.QueryRow("SELECT name FROM users WHERE id = $1", userID).Scan(&name)
+            go func() {
+                if err := sendVerificationEmail(db, userID, name, email); err != nil {
+                    log.Printf("Failed to send verification email: %v", err)
+                }
+            }()
+        }
+
+        c.JSON(200, gin.H{"message": "Account claimed. You can now sign in", "user_id": userID, "email": email})
+    })
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+    "crypto/rand"
+    "database/sql"
+    "encoding/base64"
+    "fmt"
+    "log"
+    "net"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/lib/pq"
+)
+
+// API keys look like jbh_<8 char prefix>_<secret>. The prefix is stored in
+// the clear so admins can tell keys apart; only a hash of the whole key is kept.
+const apiKeyPrefix = "jbh_"
+
+type APIKey struct {
+    ID          int        `json:"id" db:"id"`
+    Name        string     `json:"name" db:"name"`
+    KeyPrefix   string     `json:"key_prefix" db:"key_prefix"`
+    Permissions []string   `json:"permissions" db:"permissions"`
+    AllowedIPs  []string   `json:"allowed_ips" db:"allowed_ips"`
+    ExpiresAt   *time.Time `json:"expires_at" db:"expires_at"`
+    LastUsedAt  *time.Time `json:"last_used_at" db:"last_used_at"`
+    LastUsedIP  *string    `json:"last_used_ip" db:"last_used_ip"`
+    CreatedBy   *int       `json:"created_by" db:"created_by"`
+    RevokedAt   *time.Time `json:"revoked_at" db:"revoked_at"`
+    CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+    Name        string     `json:"name" binding:"required"`
+    Permissions []string   `json:"permissions" binding:"required"`
+    AllowedIPs  []string   `json:"allowed_ips"`
+    ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+func (req CreateAPIKeyRequest) validate() string {
+    if len(req.Permissions) == 0 {
+        return "At least one permission is required"
+    }
+    for _, p := range req.Permissions {
+        if !isCatalogPermission(p) {
+            return fmt.Sprintf("Unknown permission %q", p)
+        }
+    }
+    for _, entry := range req.AllowedIPs {
+        if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+            return fmt.Sprintf("Invalid IP address or CIDR range %q", entry)
+        }
+    }
+    if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+        return "expires_at must be in the future"
+    }
+    return ""
+}
+
+func isAPIKey(token string) bool {
+    return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func generateAPIKey() (string, string, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return "", "", err
+    }
+    secret := base64.RawURLEncoding.EncodeToString(raw)
+    prefix := strings.NewReplacer("-", "x", "_", "x").Replace(secret[:8])
+    return apiKeyPrefix + prefix + "_" + secret[8:], apiKeyPrefix + prefix, nil
+}
+
+// ipAllowed reports whether ip matches one of the allowlist entries, each a
+// bare address or a CIDR range. An empty allowlist allows everything.
+func ipAllowed(allowed []string, ip string) bool {
+    if len(allowed) == 0 {
+        return true
+    }
+    addr := net.ParseIP(ip)
+    if addr == nil {
+        return false
+    }
+    for _, entry := range allowed {
+        if _, network, err := net.ParseCIDR(entry); err == nil {
+            if network.Contains(addr) {
+                return true
+            }
+        } else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(addr) {
+            return true
+        }
+    }
+    return false
+}
+
+// apiKeyCaller checks key and returns a Caller carrying only the key's
+// permissions. The error is safe to show the client.
+func apiKeyCaller(db *sql.DB, key string, ip string) (*Caller, error) {
+    var id int
+    var permissions, allowedIPs pq.StringArray
+    var expiresAt *time.Time
+    err := db.QueryRow(`
+        SELECT id, permissions, allowed_ips, expires_at FROM api_keys
+        WHERE key_hash = $1 AND revoked_at IS NULL
+    `, hashToken(key)).Scan(&id, &permissions, &allowedIPs, &expiresAt)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("Invalid API key")
+    }
+    if err != nil {
+        log.Printf("Failed to look up API key: %v", err)
+        return nil, fmt.Errorf("Failed to authenticate")
+    }
+    if expiresAt != nil && expiresAt.Before(time.Now()) {
+        return nil, fmt.Errorf("API key has expired")
+    }
+    if !ipAllowed(allowedIPs, ip) {
+        return nil, fmt.Errorf("API key is not allowed from this IP address")
+    }
+
+    _, err = db.Exec(`
+        UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = NULLIF($2, '')::inet WHERE id = $1
+    `, id, ip)
+    if err != nil {
+        log.Printf("Failed to record API key use: %v", err)
+    }
+
+    return &Caller{Role: "api_key", Permissions: []string(permissions), APIKeyID: &id}, nil
+}
+
+const apiKeyColumns = `
+    SELECT id, name, key_prefix, permissions, COALESCE(allowed_ips, '{}'), expires_at, last_used_at,
+           host(last_used_ip), created_by, revoked_at, created_at
+    FROM api_keys`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+    var k APIKey
+    var permissions, allowedIPs pq.StringArray
+    err := row.Scan(&k.ID, &k.Name, &k.KeyPrefix, &permissions, &allowedIPs, &k.ExpiresAt, &k.LastUsedAt,
+        &k.LastUsedIP, &k.CreatedBy, &k.RevokedAt, &k.CreatedAt)
+    k.Permissions = []string(permissions)
+    k.AllowedIPs = []string(allowedIPs)
+    return k, err
+}
+
+func registerAPIKeyRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    admin.GET("/api-keys", func(c *gin.Context) {
+        if !requirePermission(c, "user_management") {
+            return
+        }
+        rows, err := db.Query(apiKeyColumns + " ORDER BY revoked_at IS NOT NULL, created_at DESC")
+        if err != nil {
+            log.Printf("Failed to fetch API keys: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch API keys"})
+            return
+        }
+        defer rows.Close()
+
+        keys := []APIKey{}
+        for rows.Next() {
+            k, err := scanAPIKey(rows)
+            if err != nil {
+                log.Printf("Error scanning API key: %v", err)
+                continue
+            }
+            keys = append(keys, k)
+        }
+
+        c.JSON(200, gin.H{"api_keys": keys})
+    })
+
+    admin.POST("/api-keys", func(c *gin.Context) {
+        if !requirePermission(c, "user_management") {
+            return
+        }
+        var req CreateAPIKeyRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if msg := req.validate(); msg != "" {
+            c.JSON(400, gin.H{"error": msg})
+            return
+        }
+        // A key can't carry more than the person creating it holds
+        caller := callerFrom(c)
+        for _, p := range req.Permissions {
+            if !caller.Can(p) {
+                c.JSON(403, gin.H{"error": fmt.Sprintf("You can't grant %q because you don't hold it", p)})
+                return
+            }
+        }
+
+        key, prefix, err := generateAPIKey()
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to generate API key"})
+            return
+        }
+
+        createdBy := actorID(c)
+
+        var id int
+        err = db.QueryRow(`
+            INSERT INTO api_keys (name, key_prefix, key_hash, permissions, allowed_ips, expires_at, created_by, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, req.Name, prefix, hashToken(key), pq.Array(req.Permissions), pq.Array(req.AllowedIPs), req.ExpiresAt, createdBy).Scan(&id)
+        if err != nil {
+            log.Printf("Failed to create API key: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create API key"})
+            return
+        }
+        writeAuditLog(db, c, createdBy, "api_key_created", "api_keys", &id, gin.H{"name": req.Name, "permissions": req.Permissions})
+
+        created, _ := scanAPIKey(db.QueryRow(apiKeyColumns+" WHERE id = $1", id))
+        // The plaintext key is only ever returned here
+        c.JSON(201, gin.H{"message": "API key created. Copy it now, it won't be shown again", "api_key": created, "key": key})
+    })
+
+    admin.DELETE("/api-keys/:id", func(c *gin.Context) {
+        if !requirePermission(c, "user_management") {
+            return
+        }
+        var id int
+        err := db.QueryRow(`
+            UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND revoked_at IS NULL
+            RETURNING id
+        `, c.Param("id")).Scan(&id)
+        if err == sql.ErrNoRows {
+            c.JSON(404, gin.H{"error": "API key not found"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to revoke API key: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to revoke API key"})
+            return
+        }
+
+        writeAuditLog(db, c, actorID(c), "api_key_revoked", "api_keys", &id, nil)
+
+        c.JSON(200, gin.H{"message": "API key revoked"})
+    })
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+)
+
+func TestIPAllowed(t *testing.T) {
+    tests := []struct {
+        name    string
+        allowed []string
+        ip      string
+        want    bool
+    }{
+        {"no allowlist", nil, "198.51.100.4", true},
+        {"exact match", []string{"198.51.100.4"}, "198.51.100.4", true},
+        {"inside CIDR", []string{"198.51.100.0/24"}, "198.51.100.200", true},
+        {"outside CIDR", []string{"198.51.100.0/24"}, "203.0.113.9", false},
+        {"IPv6 CIDR", []string{"2001:db8::/32"}, "2001:db8::1", true},
+        {"unparseable IP", []string{"198.51.100.4"}, "", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := ipAllowed(tt.allowed, tt.ip); got != tt.want {
+                t.Errorf("ipAllowed(%v, %q) = %v, want %v", tt.allowed, tt.ip, got, tt.want)
+            }
+        })
+    }
+}
+
+// An allowlisted key used from elsewhere mustn't get through by claiming
+// an allowlisted address in X-Forwarded-For.
+func TestClientIPIgnoresUntrustedForwardedFor(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    allowed := []string{"10.0.0.0/8"}
+
+    tests := []struct {
+        name    string
+        proxies string
+        remote  string
+        want    string
+    }{
+        {"no trusted proxies", "", "203.0.113.9:5000", "203.0.113.9"},
+        {"request not from a trusted proxy", "192.0.2.1", "203.0.113.9:5000", "203.0.113.9"},
+        {"request through a trusted proxy", "192.0.2.0/24", "192.0.2.1:5000", "10.1.2.3"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := gin.New()
+            if err := configureTrustedProxies(r, tt.proxies); err != nil {
+                t.Fatalf("configureTrustedProxies(%q): %v", tt.proxies, err)
+            }
+            var got string
+            r.GET("/", func(c *gin.Context) { got = c.ClientIP() })
+
+            req := httptest.NewRequest("GET", "/", nil)
+            req.RemoteAddr = tt.remote
+            req.Header.Set("X-Forwarded-For", "10.1.2.3")
+            req.Header.Set("X-Real-IP", "10.1.2.3")
+            r.ServeHTTP(httptest.NewRecorder(), req)
+
+            if got != tt.want {
+                t.Errorf("ClientIP() = %s, want %s", got, tt.want)
+            }
+            if wantAllowed := tt.want == "10.1.2.3"; ipAllowed(allowed, got) != wantAllowed {
+                t.Errorf("ipAllowed(%v, %s) = %v, want %v", allowed, got, !wantAllowed, wantAllowed)
+            }
+        })
+    }
+}
+
+func TestConfigureTrustedProxiesRejectsBadInput(t *testing.T) {
+    if err := configureTrustedProxies(gin.New(), "10.0.0.1, not-an-ip"); err == nil {
+        t.Error("expected an error for an invalid proxy address")
+    }
+}
+
+func TestAPIKeyCantExceedCreatorsPermissions(t *testing.T) {
+    r := newAuthTestRouter(t)
+
+    tests := []struct {
+        name        string
+        caller      testCaller
+        permissions string
+        want        int
+    }{
+        {"permissions the creator holds", userAdmin, `["customer_service"]`, allowed},
+        {"a permission the creator lacks", userAdmin, `["customer_service", "role_management"]`, forbidden},
+        {"system settings", userAdmin, `["system_settings"]`, forbidden},
+        {"everything, from an admin holding all", manager, `["role_management", "system_settings"]`, allowed},
+        {"without user_management", frontDesk, `["customer_service"]`, forbidden},
+        {"unknown permission", userAdmin, `["all"]`, badRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            w := httptest.NewRecorder()
+            req := httptest.NewRequest("POST", "/api/v1/admin/api-keys", strings.NewReader(`{"name": "Zapier", "permissions": `+tt.permissions+`}`))
+            req.Header.Set("Content-Type", "application/json")
+            req.Header.Set("Authorization", "Bearer "+tt.caller.token)
+            r.ServeHTTP(w, req)
+            if tt.want == allowed {
+                if w.Code == unauthorized || w.Code == forbidden || w.Code == badRequest {
+                    t.Errorf("got %d, want the key to be created (%s)", w.Code, w.Body.String())
+                }
+            } else if w.Code != tt.want {
+                t.Errorf("got %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+            }
+        })
+    }
+}
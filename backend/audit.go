@@ -0,0 +1,35 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+
+    "github.com/gin-gonic/gin"
+)
+
+// writeAuditLog records a security-relevant event in audit_logs alongside
+// the rows the table triggers write. c may be nil for background jobs.
+func writeAuditLog(db *sql.DB, c *gin.Context, userID *int, action string, tableName string, recordID *int, data interface{}) {
+    var payload []byte
+    if data != nil {
+        var err error
+        if payload, err = json.Marshal(data); err != nil {
+            log.Printf("Failed to encode audit data for %s: %v", action, err)
+        }
+    }
+
+    var ip, userAgent string
+    if c != nil {
+        ip = c.ClientIP()
+        userAgent = c.Request.UserAgent()
+    }
+
+    _, err := db.Exec(`
+        INSERT INTO audit_logs (user_id, action, table_name, record_id, new_data, ip_address, user_agent, created_at)
+        VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, '')::inet, NULLIF($7, ''), CURRENT_TIMESTAMP)
+    `, userID, action, tableName, recordID, payload, ip, userAgent)
+    if err != nil {
+        log.Printf("Failed to write audit log for %s: %v", action, err)
+    }
+}
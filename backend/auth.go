@@ -0,0 +1,318 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/lib/pq"
+)
+
+// Caller is whoever a request is authenticated as, with the permissions of
+// their role plus any granted to them individually in admin_users. API key
+// callers have no UserID and only the key's permissions.
+type Caller struct {
+    UserID      int      `json:"user_id,omitempty"`
+    Role        string   `json:"role"`
+    Permissions []string `json:"permissions"`
+    APIKeyID    *int     `json:"api_key_id,omitempty"`
+
+    // Set when staff are acting as this customer
+    ImpersonatorID *int `json:"impersonator_id,omitempty"`
+}
+
+// Can reports whether the caller holds permission, or the catch-all "all".
+func (caller *Caller) Can(permission string) bool {
+    for _, p := range caller.Permissions {
+        if p == permission || p == "all" {
+            return true
+        }
+    }
+    return false
+}
+
+// CanAny reports whether the caller holds at least one of permissions.
+func (caller *Caller) CanAny(permissions ...string) bool {
+    for _, p := range permissions {
+        if caller.Can(p) {
+            return true
+        }
+    }
+    return false
+}
+
+func loadCaller(db *sql.DB, userID int) (*Caller, error) {
+    caller := &Caller{UserID: userID}
+    var status string
+    var rolePermissions, userPermissions pq.StringArray
+    err := db.QueryRow(`
+        SELECT COALESCE(u.role, 'customer'), COALESCE(u.status, 'active'), r.permissions, au.permissions
+        FROM users u
+        LEFT JOIN roles r ON r.name = u.role
+        LEFT JOIN admin_users au ON au.user_id = u.id
+        WHERE u.id = $1
+    `, userID).Scan(&caller.Role, &status, &rolePermissions, &userPermissions)
+    if err != nil {
+        return nil, err
+    }
+    if status != "active" {
+        return nil, sql.ErrNoRows
+    }
+    caller.Permissions = append([]string(rolePermissions), userPermissions...)
+    return caller, nil
+}
+
+func bearerToken(c *gin.Context) string {
+    if key := c.GetHeader("X-API-Key"); key != "" {
+        return strings.TrimSpace(key)
+    }
+    header := c.GetHeader("Authorization")
+    if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+        return strings.TrimSpace(header[7:])
+    }
+    return ""
+}
+
+// authenticate resolves the bearer token or API key, if any, to a Caller.
+// Requests without one carry on anonymously so public routes keep working;
+// routes that need a caller check with requireCaller.
+func authenticate(db *sql.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        token := bearerToken(c)
+        if token == "" {
+            c.Next()
+            return
+        }
+
+        if isAPIKey(token) {
+            // ClientIP only believes X-Forwarded-For from TRUSTED_PROXIES
+            // (see configureTrustedProxies), so the allowlist can't be
+            // satisfied by a header the client wrote itself
+            caller, err := apiKeyCaller(db, token, c.ClientIP())
+            if err != nil {
+                c.AbortWithStatusJSON(401, gin.H{"error": err.Error(), "code": "invalid_api_key"})
+                return
+            }
+            c.Set("caller", caller)
+            c.Next()
+            return
+        }
+
+        sessionID, userID, impersonatorID, err := touchSession(db, token)
+        if err == sql.ErrNoRows {
+            c.AbortWithStatusJSON(401, gin.H{"error": "Session is invalid or has expired", "code": "session_expired"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to look up session: %v", err)
+            c.AbortWithStatusJSON(500, gin.H{"error": "Failed to authenticate"})
+            return
+        }
+
+        caller, err := loadCaller(db, userID)
+        if err != nil {
+            c.AbortWithStatusJSON(401, gin.H{"error": "Account is inactive"})
+            return
+        }
+        c.Set("session_id", sessionID)
+
+        if impersonatorID != nil {
+            caller.ImpersonatorID = impersonatorID
+            c.Set("caller", caller)
+            c.Header("X-Impersonating", "true")
+            serveImpersonated(c, db, caller)
+            return
+        }
+
+        c.Set("caller", caller)
+        c.Next()
+    }
+}
+
+func callerFrom(c *gin.Context) *Caller {
+    if value, ok := c.Get("caller"); ok {
+        if caller, ok := value.(*Caller); ok {
+            return caller
+        }
+    }
+    return nil
+}
+
+// currentUserID is the authenticated caller's user ID. Returns false for
+// anonymous requests and API keys.
+func currentUserID(c *gin.Context) (int, bool) {
+    if caller := callerFrom(c); caller != nil && caller.UserID != 0 {
+        return caller.UserID, true
+    }
+    return 0, false
+}
+
+// actorID is the signed-in user behind a change, for history and audit
+// rows: the admin when they're acting as a customer, and nil for anonymous
+// requests and API keys.
+func actorID(c *gin.Context) *int {
+    if caller := callerFrom(c); caller != nil && caller.ImpersonatorID != nil {
+        return caller.ImpersonatorID
+    }
+    if id, ok := currentUserID(c); ok {
+        return &id
+    }
+    return nil
+}
+
+// requireCaller writes a 401 and returns false when the request isn't
+// authenticated.
+func requireCaller(c *gin.Context) (*Caller, bool) {
+    caller := callerFrom(c)
+    if caller == nil {
+        c.JSON(401, gin.H{"error": "Authentication required"})
+        return nil, false
+    }
+    return caller, true
+}
+
+// requireAuthenticated is requireCaller as middleware, for groups where
+// every route needs a caller.
+func requireAuthenticated(c *gin.Context) {
+    if _, ok := requireCaller(c); !ok {
+        c.Abort()
+    }
+}
+
+// requirePermission lets the request through only for callers holding one
+// of permissions.
+func requirePermission(c *gin.Context, permissions ...string) bool {
+    caller, ok := requireCaller(c)
+    if !ok {
+        return false
+    }
+    if !caller.CanAny(permissions...) {
+        c.JSON(403, gin.H{"error": "You don't have permission to do that"})
+        return false
+    }
+    return true
+}
+
+// authorizeOwner lets the request through when the caller is ownerID, or is
+// staff holding one of permissions. Otherwise it writes 401/403.
+func authorizeOwner(c *gin.Context, ownerID int, permissions ...string) bool {
+    caller, ok := requireCaller(c)
+    if !ok {
+        return false
+    }
+    if (caller.UserID != 0 && caller.UserID == ownerID) || caller.CanAny(permissions...) {
+        return true
+    }
+    c.JSON(403, gin.H{"error": "You don't have access to this resource"})
+    return false
+}
+
+// authorizeRecord looks up the owning user of a record with query (which
+// takes the record ID as $1) and applies authorizeOwner. A missing record
+// is a 404 with notFound as the message.
+func authorizeRecord(c *gin.Context, db *sql.DB, query string, id string, notFound string, permissions ...string) bool {
+    if _, ok := requireCaller(c); !ok {
+        return false
+    }
+    var ownerID int
+    if err := db.QueryRow(query, id).Scan(&ownerID); err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Failed to look up record owner: %v", err)
+        }
+        c.JSON(404, gin.H{"error": notFound})
+        return false
+    }
+    return authorizeOwner(c, ownerID, permissions...)
+}
+
+func authorizePet(c *gin.Context, db *sql.DB, petID string, permissions ...string) bool {
+    return authorizeRecord(c, db, "SELECT user_id FROM pets WHERE id::text = $1", petID, "Pet not found", permissions...)
+}
+
+func authorizeAppointment(c *gin.Context, db *sql.DB, appointmentID string, permissions ...string) bool {
+    return authorizeRecord(c, db, "SELECT user_id FROM appointments WHERE id::text = $1", appointmentID, "Appointment not found", permissions...)
+}
+
+func authorizeSeries(c *gin.Context, db *sql.DB, seriesID string, permissions ...string) bool {
+    return authorizeRecord(c, db, "SELECT user_id FROM appointment_series WHERE id::text = $1", seriesID, "Series not found", permissions...)
+}
+
+func authorizeWaitlistEntry(c *gin.Context, db *sql.DB, entryID string, permissions ...string) bool {
+    return authorizeRecord(c, db, "SELECT user_id FROM waitlist_entries WHERE id::text = $1", entryID, "Waitlist entry not found", permissions...)
+}
+
+func authorizeWaitlistOffer(c *gin.Context, db *sql.DB, offerID string, permissions ...string) bool {
+    return authorizeRecord(c, db, `
+        SELECT e.user_id FROM waitlist_offers o JOIN waitlist_entries e ON o.entry_id = e.id WHERE o.id::text = $1
+    `, offerID, "Offer not found", permissions...)
+}
+
+func authorizePhoto(c *gin.Context, db *sql.DB, photoID string, permissions ...string) bool {
+    return authorizeRecord(c, db, `
+        SELECT p.user_id FROM pet_photos pp JOIN pets p ON pp.pet_id = p.id WHERE pp.id::text = $1
+    `, photoID, "Photo not found", permissions...)
+}
+
+// authorizePayment checks the payment recorded for a Stripe payment intent.
+func authorizePayment(c *gin.Context, db *sql.DB, paymentIntentID string, permissions ...string) bool {
+    return authorizeRecord(c, db, "SELECT COALESCE(user_id, 0) FROM payments WHERE stripe_payment_id = $1", paymentIntentID, "Payment not found", permissions...)
+}
+
+// requirePetOwner stops a booking for userID from naming someone else's pet.
+func requirePetOwner(c *gin.Context, db *sql.DB, petID int, userID int) bool {
+    var ownerID int
+    if err := db.QueryRow("SELECT user_id FROM pets WHERE id = $1", petID).Scan(&ownerID); err != nil || ownerID != userID {
+        c.JSON(400, gin.H{"error": "Pet not found for this customer"})
+        return false
+    }
+    return true
+}
+
+// permissionCatalog is every permission a role or API key can be granted.
+var permissionCatalog = []map[string]interface{}{
+    {"name": "system_settings", "display": "System Settings", "category": "admin"},
+    {"name": "user_management", "display": "User Management", "category": "admin"},
+    {"name": "role_management", "display": "Role Management", "category": "admin"},
+    {"name": "financial_reports", "display": "Financial Reports", "category": "business"},
+    {"name": "appointment_management", "display": "Appointment Management", "category": "operations"},
+    {"name": "customer_management", "display": "Customer Management", "category": "operations"},
+    {"name": "service_management", "display": "Service Management", "category": "business"},
+    {"name": "analytics", "display": "Analytics", "category": "business"},
+    {"name": "staff_management", "display": "Staff Management", "category": "admin"},
+    {"name": "business_settings", "display": "Business Settings", "category": "business"},
+    {"name": "customer_service", "display": "Customer Service", "category": "operations"},
+    {"name": "pet_management", "display": "Pet Management", "category": "operations"},
+    {"name": "basic_reports", "display": "Basic Reports", "category": "operations"},
+    {"name": "schedule_view", "display": "Schedule View", "category": "operations"},
+    {"name": "customer_lookup", "display": "Customer Lookup", "category": "operations"},
+}
+
+func isCatalogPermission(name string) bool {
+    for _, p := range permissionCatalog {
+        if p["name"] == name {
+            return true
+        }
+    }
+    return false
+}
+
+// Permission sets staff need to reach into customer records
+var (
+    customerReadPermissions     = []string{"customer_management", "customer_service", "customer_lookup"}
+    petReadPermissions          = []string{"pet_management", "customer_management", "customer_service", "customer_lookup"}
+    petWritePermissions         = []string{"pet_management", "customer_management"}
+    appointmentReadPermissions  = []string{"appointment_management", "schedule_view", "customer_service"}
+    appointmentWritePermissions = []string{"appointment_management"}
+)
+
+// authorizeUserParam applies authorizeOwner to the :id route parameter.
+func authorizeUserParam(c *gin.Context, permissions ...string) bool {
+    ownerID, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(400, gin.H{"error": "Invalid user ID"})
+        return false
+    }
+    return authorizeOwner(c, ownerID, permissions...)
+}
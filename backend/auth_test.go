@@ -0,0 +1,238 @@
+package main
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/stripe/stripe-go/v76"
+)
+
+const (
+    ownerID = 7
+    otherID = 8
+
+    // Records owned by ownerID
+    petID         = "100"
+    appointmentID = "200"
+    photoID       = "300"
+    paymentIntent = "pi_owner"
+)
+
+type testCaller struct {
+    token       string
+    userID      int
+    role        string
+    permissions string // Postgres array literal
+}
+
+var (
+    ownerCustomer = testCaller{"owner-token", ownerID, "customer", "{}"}
+    otherCustomer = testCaller{"other-token", otherID, "customer", "{}"}
+    frontDesk     = testCaller{"desk-token", 2, "receptionist", "{customer_service,schedule_view,customer_lookup}"}
+    manager       = testCaller{"manager-token", 1, "admin", "{all}"}
+    userAdmin     = testCaller{"user-admin-token", 3, "office_manager", "{user_management,customer_service}"}
+    anonymous     = testCaller{}
+)
+
+// newAuthTestRouter is the real router over a database that knows the test
+// callers' sessions and who owns each record. Anything past authorization
+// hits unscripted queries and fails, which is fine: these tests only care
+// whether the request got that far.
+func newAuthTestRouter(t *testing.T) *gin.Engine {
+    t.Helper()
+    db, _ := newAuthTestDB(t)
+    return newRouter(db)
+}
+
+// newAuthTestDB is the database behind newAuthTestRouter, for tests that
+// script what happens past authorization.
+func newAuthTestDB(t *testing.T) (*sql.DB, *fakeDB) {
+    t.Helper()
+    gin.SetMode(gin.TestMode)
+    db, f := newFakeDB(t)
+
+    callers := map[string]testCaller{}
+    for _, caller := range []testCaller{ownerCustomer, otherCustomer, frontDesk, manager, userAdmin} {
+        callers[hashToken(caller.token)] = caller
+    }
+    f.on("UPDATE user_sessions SET last_seen_at", []string{"id", "user_id", "impersonator_id"}, func(args []driver.Value) [][]driver.Value {
+        if caller, ok := callers[fmt.Sprint(args[0])]; ok {
+            return row(int64(caller.userID), int64(caller.userID), nil)
+        }
+        return nil
+    })
+    f.on("FROM users u LEFT JOIN roles r ON r.name = u.role", []string{"role", "status", "role_permissions", "user_permissions"}, func(args []driver.Value) [][]driver.Value {
+        for _, caller := range callers {
+            if fmt.Sprint(args[0]) == fmt.Sprint(caller.userID) {
+                return row(caller.role, "active", []byte(caller.permissions), nil)
+            }
+        }
+        return nil
+    })
+
+    owned := func(id string) func(args []driver.Value) [][]driver.Value {
+        return func(args []driver.Value) [][]driver.Value {
+            if fmt.Sprint(args[0]) == id {
+                return row(int64(ownerID))
+            }
+            return nil
+        }
+    }
+    f.on("SELECT user_id FROM pets WHERE id::text = $1", []string{"user_id"}, owned(petID))
+    f.on("SELECT user_id FROM pets WHERE id = $1", []string{"user_id"}, owned(petID))
+    f.on("SELECT user_id FROM appointments WHERE id::text = $1", []string{"user_id"}, owned(appointmentID))
+    f.on("FROM pet_photos pp JOIN pets p ON pp.pet_id = p.id WHERE pp.id::text = $1", []string{"user_id"}, owned(photoID))
+    f.on("FROM payments WHERE stripe_payment_id = $1", []string{"user_id"}, owned(paymentIntent))
+
+    // Stripe calls past the guards go to a stub that refuses them
+    stripeStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(400)
+        w.Write([]byte(`{"error": {"type": "invalid_request_error", "message": "stubbed"}}`))
+    }))
+    t.Cleanup(stripeStub.Close)
+    stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+        URL:               stripe.String(stripeStub.URL),
+        MaxNetworkRetries: stripe.Int64(0),
+    }))
+
+    return db, f
+}
+
+// Outcomes: allowed means authorization let the request through, whatever
+// the handler did next (including a 404 from its own lookups, which the
+// fake database can't answer).
+const (
+    allowed      = 0
+    unauthorized = 401
+    forbidden    = 403
+    notFound     = 404
+    badRequest   = 400
+)
+
+func TestCustomerRecordAccess(t *testing.T) {
+    r := newAuthTestRouter(t)
+
+    booking := fmt.Sprintf(`{"user_id": %d, "pet_id": %s, "service_id": 1, "appointment_date": "2030-01-07", "appointment_time": "10:00"}`, ownerID, petID)
+    otherPetBooking := fmt.Sprintf(`{"user_id": %d, "pet_id": %s, "service_id": 1, "appointment_date": "2030-01-07", "appointment_time": "10:00"}`, otherID, petID)
+    intent := fmt.Sprintf(`{"user_id": %d, "pet_id": %s, "service_id": 1}`, ownerID, petID)
+    confirm := fmt.Sprintf(`{"payment_intent_id": %q, "appointment_details": {"user_id": %d, "pet_id": %s, "service_id": 1, "date": "2030-01-07", "time": "10:00"}}`, paymentIntent, ownerID, petID)
+    confirmOtherPayment := fmt.Sprintf(`{"payment_intent_id": "pi_someone_else", "appointment_details": {"user_id": %d, "pet_id": %s, "service_id": 1, "date": "2030-01-07", "time": "10:00"}}`, ownerID, petID)
+    pet := `{"name": "Bella", "breed": "Golden Retriever", "size": "large"}`
+    status := `{"status": "cancelled"}`
+
+    routes := []struct {
+        method string
+        path   string
+        body   string
+        // Expected outcome for the owner, another customer, the front desk
+        // (customer_service, schedule_view, customer_lookup), a manager and
+        // an anonymous request
+        owner, other, desk, mgr, anon int
+    }{
+        {"GET", "/users/7", "", allowed, forbidden, allowed, allowed, unauthorized},
+        {"GET", "/users/7/pets", "", allowed, forbidden, allowed, allowed, unauthorized},
+        {"GET", "/users/7/appointments", "", allowed, forbidden, allowed, allowed, unauthorized},
+        {"GET", "/users/7/photos", "", allowed, forbidden, allowed, allowed, unauthorized},
+        {"GET", "/users/abc", "", badRequest, badRequest, badRequest, badRequest, badRequest},
+
+        {"PUT", "/pets/" + petID, pet, allowed, forbidden, forbidden, allowed, unauthorized},
+        {"DELETE", "/pets/" + petID, "", allowed, forbidden, forbidden, allowed, unauthorized},
+        {"DELETE", "/pets/999", "", notFound, notFound, notFound, notFound, unauthorized},
+        {"POST", "/pets/" + petID + "/photos", "", allowed, forbidden, forbidden, allowed, unauthorized},
+
+        {"POST", "/appointments", booking, allowed, forbidden, forbidden, allowed, unauthorized},
+        {"POST", "/appointments", otherPetBooking, forbidden, badRequest, forbidden, badRequest, unauthorized},
+        {"PUT", "/appointments/" + appointmentID + "/status", status, allowed, forbidden, forbidden, allowed, unauthorized},
+
+        {"DELETE", "/photos/" + photoID, "", allowed, forbidden, forbidden, allowed, unauthorized},
+        {"DELETE", "/photos/999", "", notFound, notFound, notFound, notFound, unauthorized},
+        {"GET", "/photos/" + photoID + "/comments", "", allowed, forbidden, allowed, allowed, unauthorized},
+
+        {"POST", "/payments/intent", intent, allowed, forbidden, forbidden, allowed, unauthorized},
+        {"POST", "/payments/confirm", confirm, allowed, forbidden, forbidden, allowed, unauthorized},
+        {"POST", "/payments/confirm", confirmOtherPayment, notFound, notFound, notFound, notFound, unauthorized},
+        {"GET", "/payments/status/" + paymentIntent, "", allowed, forbidden, allowed, allowed, unauthorized},
+    }
+
+    for _, route := range routes {
+        for _, tc := range []struct {
+            name   string
+            caller testCaller
+            want   int
+        }{
+            {"owner", ownerCustomer, route.owner},
+            {"another customer", otherCustomer, route.other},
+            {"front desk", frontDesk, route.desk},
+            {"manager", manager, route.mgr},
+            {"anonymous", anonymous, route.anon},
+        } {
+            t.Run(route.method+" "+route.path+" as "+tc.name, func(t *testing.T) {
+                w := httptest.NewRecorder()
+                req := httptest.NewRequest(route.method, "/api/v1"+route.path, strings.NewReader(route.body))
+                req.Header.Set("Content-Type", "application/json")
+                if tc.caller.token != "" {
+                    req.Header.Set("Authorization", "Bearer "+tc.caller.token)
+                }
+                r.ServeHTTP(w, req)
+
+                got := w.Code
+                if tc.want == allowed {
+                    if got == unauthorized || got == forbidden {
+                        t.Errorf("got %d, want the request let through (%s)", got, w.Body.String())
+                    }
+                } else if got != tc.want {
+                    t.Errorf("got %d, want %d (%s)", got, tc.want, w.Body.String())
+                }
+            })
+        }
+    }
+}
+
+func TestAdminRoutesRequireCaller(t *testing.T) {
+    r := newAuthTestRouter(t)
+
+    for _, path := range []string{"/admin/stats", "/admin/users", "/admin/settings", "/admin/api-keys", "/admin/security/lockouts", "/admin/impersonations"} {
+        for _, tc := range []struct {
+            name   string
+            caller testCaller
+            want   int
+        }{
+            {"anonymous", anonymous, unauthorized},
+            {"customer", ownerCustomer, forbidden},
+            {"manager", manager, allowed},
+        } {
+            t.Run(path+" as "+tc.name, func(t *testing.T) {
+                w := httptest.NewRecorder()
+                req := httptest.NewRequest(http.MethodGet, "/api/v1"+path, nil)
+                if tc.caller.token != "" {
+                    req.Header.Set("Authorization", "Bearer "+tc.caller.token)
+                }
+                r.ServeHTTP(w, req)
+                if tc.want == allowed {
+                    if w.Code == unauthorized || w.Code == forbidden {
+                        t.Errorf("got %d, want the request let through", w.Code)
+                    }
+                } else if w.Code != tc.want {
+                    t.Errorf("got %d, want %d", w.Code, tc.want)
+                }
+            })
+        }
+    }
+}
+
+func TestExpiredSessionIsRejected(t *testing.T) {
+    r := newAuthTestRouter(t)
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodGet, "/api/v1/users/7", nil)
+    req.Header.Set("Authorization", "Bearer not-a-live-session")
+    r.ServeHTTP(w, req)
+    if w.Code != unauthorized {
+        t.Errorf("got %d, want 401", w.Code)
+    }
+}
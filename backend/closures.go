@@ -0,0 +1,459 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/lib/pq"
+)
+
+type BusinessClosure struct {
+    ID          int       `json:"id" db:"id"`
+    Date        string    `json:"date" db:"closure_date"`
+    ClosureType string    `json:"closure_type" db:"closure_type"`
+    OpenTime    *string   `json:"open_time" db:"open_time"`
+    CloseTime   *string   `json:"close_time" db:"close_time"`
+    Reason      string    `json:"reason" db:"reason"`
+    CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type ClosureRequest struct {
+    Date        string  `json:"date" binding:"required"`
+    ClosureType string  `json:"closure_type" binding:"required,oneof=closed special_hours"`
+    OpenTime    *string `json:"open_time"`
+    CloseTime   *string `json:"close_time"`
+    Reason      string  `json:"reason"`
+}
+
+type FlaggedAppointment struct {
+    ID            int    `json:"id"`
+    Date          string `json:"date"`
+    Time          string `json:"time"`
+    CustomerName  string `json:"customer_name"`
+    CustomerEmail string `json:"customer_email"`
+    PetName       string `json:"pet_name"`
+    Reason        string `json:"reason"`
+}
+
+// FlaggedPayment is a payment that went through for a booking that couldn't
+// be made, waiting for staff to rebook or refund it.
+type FlaggedPayment struct {
+    ID              int       `json:"id"`
+    StripePaymentID string    `json:"stripe_payment_id"`
+    Amount          float64   `json:"amount"`
+    Status          string    `json:"status"`
+    Reason          string    `json:"reason"`
+    CreatedAt       time.Time `json:"created_at"`
+    CustomerName    string    `json:"customer_name"`
+    CustomerEmail   string    `json:"customer_email"`
+}
+
+func (r ClosureRequest) validate() string {
+    if _, err := time.Parse(dateLayout, r.Date); err != nil {
+        return "Invalid date, expected YYYY-MM-DD"
+    }
+    if r.ClosureType == "closed" {
+        return ""
+    }
+    if r.OpenTime == nil || r.CloseTime == nil {
+        return "open_time and close_time are required for special hours"
+    }
+    open, err1 := parseClock(*r.OpenTime)
+    close, err2 := parseClock(*r.CloseTime)
+    if err1 != nil || err2 != nil || !close.After(open) {
+        return "open_time must be an HH:MM time before close_time"
+    }
+    return ""
+}
+
+// refreshClosureFollowups brings the closure flags on a date in line with
+// the shop's current hours: live bookings that no longer fit are flagged so
+// staff can call the customer, and flags an earlier closure left behind are
+// cleared once the booking fits again. Customers are only notified the first
+// time a booking is flagged. Flags set for other reasons are left alone.
+func refreshClosureFollowups(db *sql.DB, date string) ([]FlaggedAppointment, error) {
+    day, err := time.Parse(dateLayout, date)
+    if err != nil {
+        return nil, err
+    }
+    hours, err := openHoursFor(db, day)
+    if err != nil {
+        return nil, err
+    }
+
+    reason := fmt.Sprintf("Shop closed on %s", date)
+    if hours.Reason != "" {
+        reason += " (" + hours.Reason + ")"
+    }
+    if !hours.Closed {
+        reason = fmt.Sprintf("Outside special hours %s-%s on %s", hours.Open, hours.Close, date)
+    }
+    open, close := nullIfEmpty(hours.Open, "00:00"), nullIfEmpty(hours.Close, "23:59")
+
+    rows, err := db.Query(`
+        UPDATE appointments a
+        SET needs_followup = FALSE, followup_reason = NULL, followup_source = NULL, updated_at = CURRENT_TIMESTAMP
+        FROM services s
+        WHERE a.service_id = s.id AND a.appointment_date = $1
+          AND a.needs_followup = TRUE AND a.followup_source = 'closure'
+          AND NOT $2 AND a.appointment_time >= $3::time
+          AND a.appointment_time + make_interval(mins => COALESCE(s.duration_minutes, 60)) <= $4::time
+        RETURNING a.id
+    `, date, hours.Closed, open, close)
+    if err != nil {
+        return nil, err
+    }
+    var cleared []int
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err == nil {
+            cleared = append(cleared, id)
+        }
+    }
+    rows.Close()
+    for _, id := range cleared {
+        if apt, err := loadAppointment(db, id); err == nil {
+            broadcastAppointmentUpdate(apt, "followup_cleared")
+        }
+    }
+
+    rows, err = db.Query(`
+        UPDATE appointments a
+        SET needs_followup = TRUE, followup_reason = $2, followup_source = 'closure', updated_at = CURRENT_TIMESTAMP
+        FROM services s, appointments old
+        WHERE a.service_id = s.id AND old.id = a.id AND a.appointment_date = $1 AND a.status IN ('pending', 'confirmed')
+          AND (old.needs_followup IS NOT TRUE OR old.followup_source = 'closure')
+          AND (
+              $3 OR
+              a.appointment_time < $4::time OR
+              a.appointment_time + make_interval(mins => COALESCE(s.duration_minutes, 60)) > $5::time
+          )
+        RETURNING a.id, COALESCE(old.needs_followup, FALSE)
+    `, date, reason, hours.Closed, open, close)
+    if err != nil {
+        return nil, err
+    }
+    type flaggedRow struct {
+        id         int
+        wasFlagged bool
+    }
+    var updated []flaggedRow
+    for rows.Next() {
+        var r flaggedRow
+        if err := rows.Scan(&r.id, &r.wasFlagged); err == nil {
+            updated = append(updated, r)
+        }
+    }
+    rows.Close()
+
+    flagged := []FlaggedAppointment{}
+    for _, r := range updated {
+        var f FlaggedAppointment
+        err := db.QueryRow(`
+            SELECT a.id, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI'),
+                   u.name, u.email, p.name, COALESCE(a.followup_reason, '')
+            FROM appointments a
+            JOIN users u ON a.user_id = u.id
+            JOIN pets p ON a.pet_id = p.id
+            WHERE a.id = $1
+        `, r.id).Scan(&f.ID, &f.Date, &f.Time, &f.CustomerName, &f.CustomerEmail, &f.PetName, &f.Reason)
+        if err == nil {
+            flagged = append(flagged, f)
+        }
+        if r.wasFlagged {
+            continue
+        }
+        if apt, err := loadAppointment(db, r.id); err == nil {
+            broadcastAppointmentUpdate(apt, "needs_followup")
+        }
+        go notifier.NotifyAppointment(r.id, "appointment_needs_followup", nil)
+    }
+    return flagged, nil
+}
+
+// closedReason explains why the shop can't take a booking at date/clock
+// because of its hours or a closure; empty means it's open.
+func closedReason(db *sql.DB, date string, clock string) (string, error) {
+    day, err := time.Parse(dateLayout, date)
+    if err != nil {
+        return "Invalid date, expected YYYY-MM-DD", nil
+    }
+    hours, err := openHoursFor(db, day)
+    if err != nil {
+        return "", err
+    }
+    if hours.Closed {
+        if hours.Reason != "" {
+            return fmt.Sprintf("We're closed on %s (%s)", date, hours.Reason), nil
+        }
+        return fmt.Sprintf("We're closed on %s", date), nil
+    }
+    if start, err := parseClock(clock); err == nil && hours.Open != "" && hours.Close != "" {
+        if startStr := start.Format("15:04"); startStr < hours.Open || startStr >= hours.Close {
+            return fmt.Sprintf("Outside business hours on %s (%s-%s)", date, hours.Open, hours.Close), nil
+        }
+    }
+    return "", nil
+}
+
+func nullIfEmpty(value string, fallback string) string {
+    if value == "" {
+        return fallback
+    }
+    return value
+}
+
+func registerClosureRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    admin.GET("/closures", func(c *gin.Context) {
+        if !requirePermission(c, appointmentReadPermissions...) {
+            return
+        }
+        from := c.DefaultQuery("from", businessToday(db))
+        to := c.DefaultQuery("to", "9999-12-31")
+
+        rows, err := db.Query(`
+            SELECT id, to_char(closure_date, 'YYYY-MM-DD'), closure_type,
+                   to_char(open_time, 'HH24:MI'), to_char(close_time, 'HH24:MI'), COALESCE(reason, ''), created_at
+            FROM business_closures
+            WHERE closure_date BETWEEN $1 AND $2
+            ORDER BY closure_date
+        `, from, to)
+        if err != nil {
+            log.Printf("Failed to fetch closures: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch closures"})
+            return
+        }
+        defer rows.Close()
+
+        var closures []BusinessClosure
+        for rows.Next() {
+            var cl BusinessClosure
+            err := rows.Scan(&cl.ID, &cl.Date, &cl.ClosureType, &cl.OpenTime, &cl.CloseTime, &cl.Reason, &cl.CreatedAt)
+            if err != nil {
+                continue
+            }
+            closures = append(closures, cl)
+        }
+
+        c.JSON(200, gin.H{"closures": closures})
+    })
+
+    admin.POST("/closures", func(c *gin.Context) {
+        if !requirePermission(c, "business_settings") {
+            return
+        }
+        var req ClosureRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if msg := req.validate(); msg != "" {
+            c.JSON(400, gin.H{"error": msg})
+            return
+        }
+        if req.ClosureType == "closed" {
+            req.OpenTime, req.CloseTime = nil, nil
+        }
+
+        var closureID int
+        err := db.QueryRow(`
+            INSERT INTO business_closures (closure_date, closure_type, open_time, close_time, reason, created_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+            ON CONFLICT (closure_date) DO NOTHING
+            RETURNING id
+        `, req.Date, req.ClosureType, req.OpenTime, req.CloseTime, req.Reason).Scan(&closureID)
+        if err == sql.ErrNoRows {
+            c.JSON(400, gin.H{"error": "An entry already exists for this date"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to create closure: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create closure"})
+            return
+        }
+
+        flagged, err := refreshClosureFollowups(db, req.Date)
+        if err != nil {
+            log.Printf("Failed to flag appointments for closure: %v", err)
+        }
+
+        c.JSON(201, gin.H{
+            "message":              "Closure created successfully",
+            "closure_id":           closureID,
+            "appointments_flagged": flagged,
+        })
+    })
+
+    admin.PUT("/closures/:id", func(c *gin.Context) {
+        if !requirePermission(c, "business_settings") {
+            return
+        }
+        var req ClosureRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if msg := req.validate(); msg != "" {
+            c.JSON(400, gin.H{"error": msg})
+            return
+        }
+        if req.ClosureType == "closed" {
+            req.OpenTime, req.CloseTime = nil, nil
+        }
+
+        var oldDate string
+        err := db.QueryRow(`
+            UPDATE business_closures bc
+            SET closure_date = $1, closure_type = $2, open_time = $3, close_time = $4, reason = $5, updated_at = CURRENT_TIMESTAMP
+            FROM business_closures old
+            WHERE bc.id = $6 AND old.id = bc.id
+            RETURNING to_char(old.closure_date, 'YYYY-MM-DD')
+        `, req.Date, req.ClosureType, req.OpenTime, req.CloseTime, req.Reason, c.Param("id")).Scan(&oldDate)
+        if err == sql.ErrNoRows {
+            c.JSON(404, gin.H{"error": "Closure not found"})
+            return
+        }
+        // Moving it onto a date that already has an entry
+        var pqErr *pq.Error
+        if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+            c.JSON(400, gin.H{"error": "An entry already exists for this date"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to update closure: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to update closure"})
+            return
+        }
+
+        flagged, err := refreshClosureFollowups(db, req.Date)
+        if err != nil {
+            log.Printf("Failed to flag appointments for closure: %v", err)
+        }
+        // Moving the closure reopens the old date
+        if oldDate != req.Date {
+            if _, err := refreshClosureFollowups(db, oldDate); err != nil {
+                log.Printf("Failed to clear follow-ups on %s: %v", oldDate, err)
+            }
+        }
+
+        c.JSON(200, gin.H{"message": "Closure updated successfully", "appointments_flagged": flagged})
+    })
+
+    admin.DELETE("/closures/:id", func(c *gin.Context) {
+        if !requirePermission(c, "business_settings") {
+            return
+        }
+        var date string
+        err := db.QueryRow(`
+            DELETE FROM business_closures WHERE id = $1
+            RETURNING to_char(closure_date, 'YYYY-MM-DD')
+        `, c.Param("id")).Scan(&date)
+        if err == sql.ErrNoRows {
+            c.JSON(404, gin.H{"error": "Closure not found"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to delete closure: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to delete closure"})
+            return
+        }
+
+        // Bookings flagged only because of this closure are fine again
+        if _, err := refreshClosureFollowups(db, date); err != nil {
+            log.Printf("Failed to clear follow-ups on %s: %v", date, err)
+        }
+        c.JSON(200, gin.H{"message": "Closure deleted successfully"})
+    })
+
+    // Bookings staff still need to call about
+    admin.GET("/appointments/followup", func(c *gin.Context) {
+        if !requirePermission(c, appointmentReadPermissions...) {
+            return
+        }
+        rows, err := db.Query(`
+            SELECT a.id, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI'),
+                   u.name, u.email, p.name, COALESCE(a.followup_reason, '')
+            FROM appointments a
+            JOIN users u ON a.user_id = u.id
+            JOIN pets p ON a.pet_id = p.id
+            WHERE a.needs_followup = TRUE AND a.status IN ('pending', 'confirmed')
+            ORDER BY a.appointment_date, a.appointment_time
+        `)
+        if err != nil {
+            log.Printf("Failed to fetch follow-ups: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch follow-ups"})
+            return
+        }
+        defer rows.Close()
+
+        flagged := []FlaggedAppointment{}
+        for rows.Next() {
+            var f FlaggedAppointment
+            if err := rows.Scan(&f.ID, &f.Date, &f.Time, &f.CustomerName, &f.CustomerEmail, &f.PetName, &f.Reason); err == nil {
+                flagged = append(flagged, f)
+            }
+        }
+
+        // Payments taken for bookings that couldn't be made
+        rows, err = db.Query(`
+            SELECT pay.id, pay.stripe_payment_id, pay.amount, pay.status, COALESCE(pay.followup_reason, ''), pay.created_at,
+                   COALESCE(u.name, ''), COALESCE(u.email, '')
+            FROM payments pay
+            LEFT JOIN users u ON pay.user_id = u.id
+            WHERE pay.needs_followup = TRUE
+            ORDER BY pay.created_at
+        `)
+        if err != nil {
+            log.Printf("Failed to fetch payment follow-ups: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch follow-ups"})
+            return
+        }
+        defer rows.Close()
+
+        payments := []FlaggedPayment{}
+        for rows.Next() {
+            var f FlaggedPayment
+            if err := rows.Scan(&f.ID, &f.StripePaymentID, &f.Amount, &f.Status, &f.Reason, &f.CreatedAt, &f.CustomerName, &f.CustomerEmail); err == nil {
+                payments = append(payments, f)
+            }
+        }
+
+        c.JSON(200, gin.H{"appointments": flagged, "payments": payments})
+    })
+
+    admin.POST("/payments/:id/followup/resolve", func(c *gin.Context) {
+        if !requirePermission(c, appointmentWritePermissions...) {
+            return
+        }
+        _, err := db.Exec(`
+            UPDATE payments SET needs_followup = FALSE, followup_reason = NULL, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to resolve payment follow-up: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to resolve follow-up"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Follow-up resolved"})
+    })
+
+    admin.POST("/appointments/:id/followup/resolve", func(c *gin.Context) {
+        if !requirePermission(c, appointmentWritePermissions...) {
+            return
+        }
+        _, err := db.Exec(`
+            UPDATE appointments SET needs_followup = FALSE, followup_reason = NULL, followup_source = NULL, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to resolve follow-up: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to resolve follow-up"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Follow-up resolved"})
+    })
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/lib/pq"
+)
+
+func TestMovingClosureOntoTakenDate(t *testing.T) {
+    db, f := newAuthTestDB(t)
+    scriptSettings(f, nil)
+    f.fail("UPDATE business_closures bc", &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "business_closures_closure_date_key"`})
+
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/closures/3", strings.NewReader(`{"date": "2030-12-25", "closure_type": "closed", "reason": "Christmas"}`))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+manager.token)
+    newRouter(db).ServeHTTP(w, req)
+
+    if w.Code != 400 || !strings.Contains(w.Body.String(), "An entry already exists for this date") {
+        t.Errorf("got %d %s, want 400 for the taken date", w.Code, w.Body.String())
+    }
+}
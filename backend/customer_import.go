@@ -0,0 +1,480 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/mail"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    maxImportBytes = 5 << 20
+    maxImportRows  = 5000
+)
+
+// Fields a customer CSV can map onto, with the headers we recognize for
+// each when the caller doesn't send a mapping.
+var customerImportFields = []struct {
+    Field   string   `json:"field"`
+    Aliases []string `json:"aliases"`
+}{
+    {"name", []string{"name", "customer", "customer name", "client", "client name", "full name", "owner", "owner name"}},
+    {"email", []string{"email", "e-mail", "email address"}},
+    {"phone", []string{"phone", "phone number", "mobile", "cell", "telephone", "tel"}},
+    {"wash_count", []string{"wash_count", "washes", "wash count", "visits", "punches"}},
+    {"customer_notes", []string{"customer notes", "client notes", "owner notes"}},
+    {"pet_name", []string{"pet_name", "pet", "pet name", "dog", "dog name"}},
+    {"pet_breed", []string{"pet_breed", "breed"}},
+    {"pet_size", []string{"pet_size", "size", "dog size"}},
+    {"pet_notes", []string{"pet_notes", "pet notes", "dog notes", "notes"}},
+}
+
+var petSizeAliases = map[string]string{
+    "s": "small", "sm": "small", "small": "small",
+    "m": "medium", "med": "medium", "medium": "medium",
+    "l": "large", "lg": "large", "large": "large", "xl": "large",
+}
+
+type ImportRowError struct {
+    Row     int    `json:"row"`
+    Field   string `json:"field,omitempty"`
+    Message string `json:"message"`
+}
+
+type ImportRowResult struct {
+    Row        int    `json:"row"`
+    Action     string `json:"action"` // created, updated, unchanged, error
+    UserID     int    `json:"user_id,omitempty"`
+    Email      string `json:"email,omitempty"`
+    PetCreated bool   `json:"pet_created,omitempty"`
+}
+
+type customerImportRow struct {
+    Line          int
+    Name          string
+    Email         string
+    Phone         string
+    WashCount     int
+    CustomerNotes string
+    PetName       string
+    PetBreed      string
+    PetSize       string
+    PetNotes      string
+}
+
+// resolveImportMapping turns the CSV header plus an optional field -> header
+// mapping into field -> column index. Unmapped fields fall back to the
+// aliases above.
+func resolveImportMapping(header []string, mapping map[string]string) (map[string]int, error) {
+    columns := map[string]int{}
+    for i, h := range header {
+        columns[strings.ToLower(strings.TrimSpace(h))] = i
+    }
+
+    resolved := map[string]int{}
+    for _, f := range customerImportFields {
+        if h, ok := mapping[f.Field]; ok {
+            if h == "" {
+                continue
+            }
+            i, ok := columns[strings.ToLower(strings.TrimSpace(h))]
+            if !ok {
+                return nil, fmt.Errorf("Column %q mapped to %s is not in the file", h, f.Field)
+            }
+            resolved[f.Field] = i
+            continue
+        }
+        for _, alias := range f.Aliases {
+            if i, ok := columns[alias]; ok {
+                resolved[f.Field] = i
+                break
+            }
+        }
+    }
+    for field := range mapping {
+        known := false
+        for _, f := range customerImportFields {
+            known = known || f.Field == field
+        }
+        if !known {
+            return nil, fmt.Errorf("Unknown import field %q", field)
+        }
+    }
+    if _, ok := resolved["name"]; !ok {
+        return nil, fmt.Errorf("No column mapped to name")
+    }
+    _, hasEmail := resolved["email"]
+    _, hasPhone := resolved["phone"]
+    if !hasEmail && !hasPhone {
+        return nil, fmt.Errorf("Map a column to email or phone so rows can be matched on re-import")
+    }
+    return resolved, nil
+}
+
+// parseImportRow pulls one record through the mapping and checks it.
+func parseImportRow(line int, record []string, columns map[string]int) (customerImportRow, []ImportRowError) {
+    get := func(field string) string {
+        if i, ok := columns[field]; ok && i < len(record) {
+            return strings.TrimSpace(record[i])
+        }
+        return ""
+    }
+    row := customerImportRow{
+        Line:          line,
+        Name:          strings.Join(strings.Fields(get("name")), " "),
+        Email:         strings.ToLower(get("email")),
+        Phone:         get("phone"),
+        CustomerNotes: get("customer_notes"),
+        PetName:       get("pet_name"),
+        PetBreed:      get("pet_breed"),
+        PetNotes:      get("pet_notes"),
+    }
+    var errs []ImportRowError
+    fail := func(field, message string) {
+        errs = append(errs, ImportRowError{Row: line, Field: field, Message: message})
+    }
+
+    if row.Name == "" {
+        fail("name", "Name is required")
+    }
+    if row.Email == "" && row.Phone == "" {
+        fail("email", "Email or phone is required")
+    }
+    if row.Email != "" {
+        if addr, err := mail.ParseAddress(row.Email); err != nil || addr.Address != row.Email {
+            fail("email", "Invalid email address")
+        } else if strings.HasSuffix(row.Email, placeholderEmailDomain) {
+            fail("email", "Invalid email address")
+        }
+    }
+    if row.Phone != "" && len(phoneKey(row.Phone)) != 10 {
+        fail("phone", "Phone must have 10 digits")
+    }
+    if wc := get("wash_count"); wc != "" {
+        n, err := strconv.Atoi(wc)
+        if err != nil || n < 0 {
+            fail("wash_count", "Wash count must be a whole number")
+        }
+        row.WashCount = n
+    }
+    if size := get("pet_size"); size != "" {
+        normalized, ok := petSizeAliases[strings.ToLower(size)]
+        if !ok {
+            fail("pet_size", "Size must be small, medium or large")
+        }
+        row.PetSize = normalized
+    }
+    if row.PetName == "" && (row.PetBreed != "" || row.PetSize != "" || row.PetNotes != "") {
+        fail("pet_name", "Pet name is required when other pet details are given")
+    }
+    return row, errs
+}
+
+// importCustomerRow creates or updates the customer for row inside tx.
+// Existing customers are matched on email, then phone, and only have blank
+// details filled in, so running the same file twice changes nothing.
+func importCustomerRow(tx *sql.Tx, row customerImportRow, importedBy *int) (ImportRowResult, error) {
+    result := ImportRowResult{Row: row.Line}
+
+    var userID int
+    var email string
+    err := sql.ErrNoRows
+    if row.Email != "" {
+        err = tx.QueryRow(`
+            SELECT id, email FROM users
+            WHERE LOWER(email) = $1 AND COALESCE(status, 'active') NOT IN ('deleted', 'merged')
+        `, row.Email).Scan(&userID, &email)
+    }
+    if err == sql.ErrNoRows && row.Phone != "" {
+        err = tx.QueryRow(`
+            SELECT id, email FROM users
+            WHERE RIGHT(regexp_replace(COALESCE(phone, ''), '\D', '', 'g'), 10) = $1
+              AND COALESCE(role, 'customer') = 'customer' AND COALESCE(status, 'active') NOT IN ('deleted', 'merged')
+            ORDER BY id LIMIT 1
+        `, phoneKey(row.Phone)).Scan(&userID, &email)
+    }
+
+    switch {
+    case err == sql.ErrNoRows:
+        email = row.Email
+        if email == "" {
+            email = "phone-" + phoneKey(row.Phone) + placeholderEmailDomain
+        }
+        err = tx.QueryRow(`
+            INSERT INTO users (name, email, phone, password, wash_count, imported_at, created_at, updated_at)
+            VALUES ($1, $2, NULLIF($3, ''), '', $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, row.Name, email, row.Phone, row.WashCount).Scan(&userID)
+        if err != nil {
+            return result, err
+        }
+        result.Action = "created"
+    case err != nil:
+        return result, err
+    default:
+        // A phone-only import that now has an email swaps out the placeholder
+        res, err := tx.Exec(`
+            UPDATE users SET
+                phone = COALESCE(NULLIF(phone, ''), NULLIF($2, '')),
+                email = CASE WHEN email LIKE '%' || $5 AND $3 != '' THEN $3 ELSE email END,
+                wash_count = GREATEST(wash_count, $4),
+                updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND (
+                (COALESCE(phone, '') = '' AND $2 != '') OR
+                (email LIKE '%' || $5 AND $3 != '') OR
+                wash_count < $4)
+        `, userID, row.Phone, row.Email, row.WashCount, placeholderEmailDomain)
+        if err != nil {
+            return result, err
+        }
+        result.Action = "unchanged"
+        if n, _ := res.RowsAffected(); n > 0 {
+            result.Action = "updated"
+        }
+        if strings.HasSuffix(email, placeholderEmailDomain) && row.Email != "" {
+            email = row.Email
+        }
+    }
+    result.UserID = userID
+    if !strings.HasSuffix(email, placeholderEmailDomain) {
+        result.Email = email
+    }
+
+    if row.CustomerNotes != "" {
+        res, err := tx.Exec(`
+            INSERT INTO customer_notes (user_id, author_id, body, created_at, updated_at)
+            SELECT $1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+            WHERE NOT EXISTS (SELECT 1 FROM customer_notes WHERE user_id = $1 AND body = $3)
+        `, userID, importedBy, row.CustomerNotes)
+        if err != nil {
+            return result, err
+        }
+        if n, _ := res.RowsAffected(); n > 0 && result.Action == "unchanged" {
+            result.Action = "updated"
+        }
+    }
+
+    if row.PetName != "" {
+        res, err := tx.Exec(`
+            INSERT INTO pets (user_id, name, breed, size, notes, created_at)
+            SELECT $1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), CURRENT_TIMESTAMP
+            WHERE NOT EXISTS (SELECT 1 FROM pets WHERE user_id = $1 AND LOWER(name) = LOWER($2))
+        `, userID, row.PetName, row.PetBreed, row.PetSize, row.PetNotes)
+        if err != nil {
+            return result, err
+        }
+        if n, _ := res.RowsAffected(); n > 0 {
+            result.PetCreated = true
+            if result.Action == "unchanged" {
+                result.Action = "updated"
+            }
+        }
+    }
+    return result, nil
+}
+
+func registerCustomerImportRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    // The fields an import can map, for the column-mapping screen
+    admin.GET("/import/customers/fields", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management") {
+            return
+        }
+        c.JSON(200, gin.H{"fields": customerImportFields})
+    })
+
+    // Multipart upload: file (CSV), optional mapping (JSON of field -> header),
+    // dry_run (default true) and send_invites. A dry run does the whole
+    // import in a transaction and rolls it back, so it catches the same
+    // errors a real run would.
+    admin.POST("/import/customers", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management") {
+            return
+        }
+        dryRun := c.DefaultPostForm("dry_run", "true") != "false"
+        sendInvites := c.PostForm("send_invites") == "true"
+
+        fileHeader, err := c.FormFile("file")
+        if err != nil {
+            c.JSON(400, gin.H{"error": "A CSV file is required"})
+            return
+        }
+        if fileHeader.Size > maxImportBytes {
+            c.JSON(400, gin.H{"error": "File is too large. Split it into files under 5MB"})
+            return
+        }
+        var mapping map[string]string
+        if raw := c.PostForm("mapping"); raw != "" {
+            if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+                c.JSON(400, gin.H{"error": "mapping must be a JSON object of field to column header"})
+                return
+            }
+        }
+
+        file, err := fileHeader.Open()
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to read file"})
+            return
+        }
+        defer file.Close()
+
+        reader := csv.NewReader(file)
+        reader.FieldsPerRecord = -1
+        reader.TrimLeadingSpace = true
+        header, err := reader.Read()
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Could not read a header row from the file"})
+            return
+        }
+        if len(header) > 0 {
+            header[0] = strings.TrimPrefix(header[0], "\ufeff") // Excel's byte order mark
+        }
+        columns, err := resolveImportMapping(header, mapping)
+        if err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        rows := []customerImportRow{}
+        rowErrors := []ImportRowError{}
+        line := 1
+        for {
+            record, err := reader.Read()
+            if err == io.EOF {
+                break
+            }
+            line++
+            if err != nil {
+                rowErrors = append(rowErrors, ImportRowError{Row: line, Message: err.Error()})
+                continue
+            }
+            if strings.TrimSpace(strings.Join(record, "")) == "" {
+                continue
+            }
+            if len(rows) >= maxImportRows {
+                c.JSON(400, gin.H{"error": fmt.Sprintf("Files are limited to %d rows", maxImportRows)})
+                return
+            }
+            row, errs := parseImportRow(line, record, columns)
+            if len(errs) > 0 {
+                rowErrors = append(rowErrors, errs...)
+                continue
+            }
+            rows = append(rows, row)
+        }
+
+        importedBy := actorID(c)
+        tx, err := db.Begin()
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to start import"})
+            return
+        }
+        defer tx.Rollback()
+
+        // Each row gets a savepoint so one bad row doesn't sink the file
+        results := []ImportRowResult{}
+        counts := map[string]int{"created": 0, "updated": 0, "unchanged": 0, "pets_created": 0}
+        for _, row := range rows {
+            if _, err := tx.Exec("SAVEPOINT import_row"); err != nil {
+                c.JSON(500, gin.H{"error": "Failed to import"})
+                return
+            }
+            result, err := importCustomerRow(tx, row, importedBy)
+            if err != nil {
+                tx.Exec("ROLLBACK TO SAVEPOINT import_row")
+                log.Printf("Customer import row %d failed: %v", row.Line, err)
+                rowErrors = append(rowErrors, ImportRowError{Row: row.Line, Message: "Could not save this row"})
+                results = append(results, ImportRowResult{Row: row.Line, Action: "error"})
+                continue
+            }
+            tx.Exec("RELEASE SAVEPOINT import_row")
+            counts[result.Action]++
+            if result.PetCreated {
+                counts["pets_created"]++
+            }
+            results = append(results, result)
+        }
+
+        response := gin.H{
+            "dry_run":    dryRun,
+            "total_rows": line - 1,
+            "counts":     counts,
+            "errors":     rowErrors,
+            "rows":       results,
+        }
+        if dryRun {
+            c.JSON(200, response)
+            return
+        }
+        if err := tx.Commit(); err != nil {
+            log.Printf("Failed to commit customer import: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to import"})
+            return
+        }
+
+        writeAuditLog(db, c, importedBy, "customers_imported", "users", nil, gin.H{
+            "file":   fileHeader.Filename,
+            "counts": counts,
+            "errors": len(rowErrors),
+        })
+
+        if sendInvites {
+            invited := 0
+            for _, result := range results {
+                if result.Action == "created" && inviteImportedCustomer(db, result.UserID) {
+                    invited++
+                }
+            }
+            response["invites_sent"] = invited
+        }
+
+        c.JSON(200, response)
+    })
+
+    // (Re)send the claim-your-account invite to an imported customer
+    admin.POST("/customers/:id/invite", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        userID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid customer ID"})
+            return
+        }
+        var claimed bool
+        err = db.QueryRow("SELECT COALESCE(password, '') != '' FROM users WHERE id = $1 AND imported_at IS NOT NULL", userID).Scan(&claimed)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Imported customer not found"})
+            return
+        }
+        if claimed {
+            c.JSON(400, gin.H{"error": "This customer has already claimed their account"})
+            return
+        }
+        if !inviteImportedCustomer(db, userID) {
+            c.JSON(500, gin.H{"error": "Failed to send invite"})
+            return
+        }
+        writeAuditLog(db, c, actorID(c), "account_invite_sent", "users", &userID, nil)
+        c.JSON(200, gin.H{"message": "Invite sent"})
+    })
+}
+
+func inviteImportedCustomer(db *sql.DB, userID int) bool {
+    var name, email, phone string
+    err := db.QueryRow("SELECT name, email, COALESCE(phone, '') FROM users WHERE id = $1", userID).Scan(&name, &email, &phone)
+    if err == nil {
+        err = sendAccountInvite(db, userID, name, email, phone)
+    }
+    if err != nil {
+        log.Printf("Failed to send account invite to user %d: %v", userID, err)
+        return false
+    }
+    return true
+}
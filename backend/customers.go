@@ -0,0 +1,398 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/lib/pq"
+)
+
+type CustomerNote struct {
+    ID         int       `json:"id" db:"id"`
+    UserID     int       `json:"user_id" db:"user_id"`
+    AuthorID   *int      `json:"author_id" db:"author_id"`
+    AuthorName string    `json:"author_name"`
+    Body       string    `json:"body" db:"body"`
+    Pinned     bool      `json:"pinned" db:"pinned"`
+    CreatedAt  time.Time `json:"created_at" db:"created_at"`
+    UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type CustomerNoteRequest struct {
+    Body   string `json:"body" binding:"required"`
+    Pinned bool   `json:"pinned"`
+}
+
+type CustomerLoyalty struct {
+    WashCount          int `json:"wash_count"`
+    WashesPerReward    int `json:"washes_per_reward"`
+    WashesToNextReward int `json:"washes_to_next_reward"`
+    RewardsEarned      int `json:"rewards_earned"`
+}
+
+func customerLoyalty(db *sql.DB, washCount int) CustomerLoyalty {
+    per := getSettingInt(db, "loyalty", "washes_per_reward", 5)
+    if per <= 0 {
+        per = 5
+    }
+    return CustomerLoyalty{
+        WashCount:          washCount,
+        WashesPerReward:    per,
+        WashesToNextReward: per - washCount%per,
+        RewardsEarned:      washCount / per,
+    }
+}
+
+// normalizeTag trims a tag and collapses inner whitespace so "VIP " and
+// "vip" land on the same label.
+func normalizeTag(tag string) string {
+    return strings.Join(strings.Fields(tag), " ")
+}
+
+func customerTags(db *sql.DB, userID int) []string {
+    tags := []string{}
+    rows, err := db.Query("SELECT tag FROM customer_tags WHERE user_id = $1 ORDER BY LOWER(tag)", userID)
+    if err != nil {
+        log.Printf("Failed to fetch customer tags: %v", err)
+        return tags
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var tag string
+        if rows.Scan(&tag) == nil {
+            tags = append(tags, tag)
+        }
+    }
+    return tags
+}
+
+const customerNoteColumns = `
+    SELECT n.id, n.user_id, n.author_id, COALESCE(a.name, ''), n.body, n.pinned, n.created_at, n.updated_at
+    FROM customer_notes n
+    LEFT JOIN users a ON n.author_id = a.id`
+
+func scanCustomerNotes(rows *sql.Rows) []CustomerNote {
+    notes := []CustomerNote{}
+    for rows.Next() {
+        var n CustomerNote
+        if err := rows.Scan(&n.ID, &n.UserID, &n.AuthorID, &n.AuthorName, &n.Body, &n.Pinned, &n.CreatedAt, &n.UpdatedAt); err != nil {
+            log.Printf("Error scanning customer note: %v", err)
+            continue
+        }
+        notes = append(notes, n)
+    }
+    return notes
+}
+
+func registerCustomerRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    // Full CRM profile for one customer
+    admin.GET("/customers/:id", func(c *gin.Context) {
+        if !requirePermission(c, customerReadPermissions...) {
+            return
+        }
+        userID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid customer ID"})
+            return
+        }
+
+        var user User
+        err = db.QueryRow(`
+            SELECT id, name, email, phone, wash_count, COALESCE(role, 'customer'), COALESCE(status, 'active'), last_login, email_verified_at, created_at
+            FROM users WHERE id = $1
+        `, userID).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.WashCount, &user.Role, &user.Status, &user.LastLogin, &user.EmailVerifiedAt, &user.CreatedAt)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Customer not found"})
+            return
+        }
+
+        pets := []Pet{}
+        rows, err := db.Query("SELECT id, user_id, name, breed, size, notes FROM pets WHERE user_id = $1 ORDER BY name", userID)
+        if err != nil {
+            log.Printf("Failed to fetch customer pets: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch customer"})
+            return
+        }
+        for rows.Next() {
+            var pet Pet
+            if rows.Scan(&pet.ID, &pet.UserID, &pet.Name, &pet.Breed, &pet.Size, &pet.Notes) == nil {
+                pets = append(pets, pet)
+            }
+        }
+        rows.Close()
+
+        appointments := []Appointment{}
+        rows, err = db.Query(`
+            SELECT a.id, a.user_id, a.pet_id, a.service_id, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI'),
+                   a.status, COALESCE(a.notes, ''), a.created_at, p.name, s.name, s.type
+            FROM appointments a
+            JOIN pets p ON a.pet_id = p.id
+            JOIN services s ON a.service_id = s.id
+            WHERE a.user_id = $1
+            ORDER BY a.appointment_date DESC, a.appointment_time DESC
+        `, userID)
+        if err != nil {
+            log.Printf("Failed to fetch customer appointments: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch customer"})
+            return
+        }
+        loc := businessLocation(db)
+        statusCounts := map[string]int{}
+        for rows.Next() {
+            var apt Appointment
+            err := rows.Scan(&apt.ID, &apt.UserID, &apt.PetID, &apt.ServiceID, &apt.AppointmentDate, &apt.AppointmentTime,
+                &apt.Status, &apt.Notes, &apt.CreatedAt, &apt.PetName, &apt.ServiceName, &apt.ServiceType)
+            if err != nil {
+                continue
+            }
+            apt.setStartsAt(loc)
+            statusCounts[apt.Status]++
+            appointments = append(appointments, apt)
+        }
+        rows.Close()
+
+        // Refunds come back out of lifetime spend
+        var lifetimeSpend, refunded float64
+        db.QueryRow(`
+            SELECT COALESCE(SUM(amount) FILTER (WHERE status = 'succeeded'), 0),
+                   COALESCE(SUM(amount) FILTER (WHERE status = 'refunded'), 0)
+            FROM payments WHERE user_id = $1
+        `, userID).Scan(&lifetimeSpend, &refunded)
+
+        rows, err = db.Query(customerNoteColumns+" WHERE n.user_id = $1 ORDER BY n.pinned DESC, n.created_at DESC", userID)
+        notes := []CustomerNote{}
+        if err == nil {
+            notes = scanCustomerNotes(rows)
+            rows.Close()
+        } else {
+            log.Printf("Failed to fetch customer notes: %v", err)
+        }
+
+        c.JSON(200, gin.H{
+            "customer":       user,
+            "pets":           pets,
+            "appointments":   appointments,
+            "lifetime_spend": lifetimeSpend,
+            "refunded":       refunded,
+            "stats": gin.H{
+                "appointment_count": len(appointments),
+                "completed_count":   statusCounts["completed"],
+                "cancelled_count":   statusCounts["cancelled"],
+                "no_show_count":     statusCounts["no_show"],
+            },
+            "loyalty": customerLoyalty(db, user.WashCount),
+            "tags":    customerTags(db, userID),
+            "notes":   notes,
+        })
+    })
+
+    admin.POST("/customers/:id/notes", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        var req CustomerNoteRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if strings.TrimSpace(req.Body) == "" {
+            c.JSON(400, gin.H{"error": "Note can't be empty"})
+            return
+        }
+        userID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid customer ID"})
+            return
+        }
+
+        var noteID int
+        err = db.QueryRow(`
+            INSERT INTO customer_notes (user_id, author_id, body, pinned, created_at, updated_at)
+            SELECT id, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP FROM users WHERE id = $1
+            RETURNING id
+        `, userID, actorID(c), strings.TrimSpace(req.Body), req.Pinned).Scan(&noteID)
+        if err == sql.ErrNoRows {
+            c.JSON(404, gin.H{"error": "Customer not found"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to add customer note: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to add note"})
+            return
+        }
+
+        rows, err := db.Query(customerNoteColumns+" WHERE n.id = $1", noteID)
+        if err != nil {
+            c.JSON(201, gin.H{"message": "Note added", "note_id": noteID})
+            return
+        }
+        defer rows.Close()
+        notes := scanCustomerNotes(rows)
+        c.JSON(201, gin.H{"message": "Note added", "note": notes[0]})
+    })
+
+    admin.PUT("/customers/:id/notes/:note_id", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        var req CustomerNoteRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        result, err := db.Exec(`
+            UPDATE customer_notes SET body = $1, pinned = $2, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $3 AND user_id = $4
+        `, strings.TrimSpace(req.Body), req.Pinned, c.Param("note_id"), c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to update customer note: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to update note"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(404, gin.H{"error": "Note not found"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Note updated"})
+    })
+
+    admin.DELETE("/customers/:id/notes/:note_id", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        result, err := db.Exec("DELETE FROM customer_notes WHERE id = $1 AND user_id = $2", c.Param("note_id"), c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to delete customer note: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to delete note"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(404, gin.H{"error": "Note not found"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Note deleted"})
+    })
+
+    // Search notes across all customers, optionally narrowed to a tag
+    admin.GET("/customer-notes", func(c *gin.Context) {
+        if !requirePermission(c, customerReadPermissions...) {
+            return
+        }
+        q := strings.TrimSpace(c.Query("q"))
+        tag := normalizeTag(c.Query("tag"))
+        if q == "" && tag == "" {
+            c.JSON(400, gin.H{"error": "q or tag is required"})
+            return
+        }
+
+        query := customerNoteColumns + " JOIN users u ON n.user_id = u.id WHERE TRUE"
+        args := []interface{}{}
+        if q != "" {
+            args = append(args, q, escapeLike(q))
+            query += " AND (to_tsvector('english', n.body) @@ plainto_tsquery('english', $1) OR n.body ILIKE '%' || $2 || '%')"
+        }
+        if tag != "" {
+            args = append(args, tag)
+            query += " AND EXISTS (SELECT 1 FROM customer_tags t WHERE t.user_id = n.user_id AND LOWER(t.tag) = LOWER($" + strconv.Itoa(len(args)) + "))"
+        }
+        query += " ORDER BY n.created_at DESC LIMIT 100"
+
+        rows, err := db.Query(query, args...)
+        if err != nil {
+            log.Printf("Failed to search customer notes: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to search notes"})
+            return
+        }
+        defer rows.Close()
+        c.JSON(200, gin.H{"notes": scanCustomerNotes(rows)})
+    })
+
+    // Every tag in use, for autocomplete and filters
+    admin.GET("/customer-tags", func(c *gin.Context) {
+        if !requirePermission(c, customerReadPermissions...) {
+            return
+        }
+        rows, err := db.Query(`
+            SELECT MIN(tag), COUNT(*) FROM customer_tags
+            GROUP BY LOWER(tag) ORDER BY COUNT(*) DESC, MIN(tag)
+        `)
+        if err != nil {
+            log.Printf("Failed to fetch customer tags: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch tags"})
+            return
+        }
+        defer rows.Close()
+
+        tags := []gin.H{}
+        for rows.Next() {
+            var tag string
+            var count int
+            if rows.Scan(&tag, &count) == nil {
+                tags = append(tags, gin.H{"tag": tag, "customer_count": count})
+            }
+        }
+        c.JSON(200, gin.H{"tags": tags})
+    })
+
+    admin.POST("/customers/:id/tags", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        var req struct {
+            Tags []string `json:"tags" binding:"required"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        var tags []string
+        for _, tag := range req.Tags {
+            tag = normalizeTag(tag)
+            if tag == "" || len(tag) > 50 {
+                c.JSON(400, gin.H{"error": "Tags must be 1 to 50 characters"})
+                return
+            }
+            tags = append(tags, tag)
+        }
+
+        userID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid customer ID"})
+            return
+        }
+        _, err = db.Exec(`
+            INSERT INTO customer_tags (user_id, tag, created_by, created_at)
+            SELECT $1, t, $3, CURRENT_TIMESTAMP FROM unnest($2::text[]) AS t
+            WHERE EXISTS (SELECT 1 FROM users WHERE id = $1)
+            ON CONFLICT (user_id, LOWER(tag)) DO NOTHING
+        `, userID, pq.Array(tags), actorID(c))
+        if err != nil {
+            log.Printf("Failed to tag customer: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to add tags"})
+            return
+        }
+        c.JSON(200, gin.H{"tags": customerTags(db, userID)})
+    })
+
+    admin.DELETE("/customers/:id/tags/:tag", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        userID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid customer ID"})
+            return
+        }
+        _, err = db.Exec("DELETE FROM customer_tags WHERE user_id = $1 AND LOWER(tag) = LOWER($2)", userID, normalizeTag(c.Param("tag")))
+        if err != nil {
+            log.Printf("Failed to remove customer tag: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to remove tag"})
+            return
+        }
+        c.JSON(200, gin.H{"tags": customerTags(db, userID)})
+    })
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestCustomerSearchesMatchWildcardsLiterally(t *testing.T) {
+    for _, tc := range []struct {
+        path  string
+        match string // a fragment of the search query
+    }{
+        {"/customer-notes?q=50%25_off", "n.body ILIKE"},
+        {"/customers?q=50%25_off", "u.name ILIKE"},
+    } {
+        t.Run(tc.path, func(t *testing.T) {
+            db, f := newAuthTestDB(t)
+            scriptSettings(f, nil)
+            adminRequest(t, db, http.MethodGet, tc.path)
+
+            calls := f.ran(tc.match)
+            if len(calls) == 0 {
+                t.Fatalf("search query didn't run")
+            }
+            var raw, escaped bool
+            for _, arg := range calls[0].args {
+                raw = raw || arg == "50%_off"
+                escaped = escaped || arg == `50\%\_off`
+            }
+            if !raw || !escaped {
+                t.Errorf("want the raw term for full-text search and the escaped one for ILIKE, got args %v", calls[0].args)
+            }
+            if strings.Contains(fmt.Sprint(calls[0].query), "ILIKE '%' || $1 || '%'") {
+                t.Errorf("ILIKE still uses the unescaped term: %s", calls[0].query)
+            }
+        })
+    }
+}
+
+func TestAddNoteRejectsNonNumericCustomer(t *testing.T) {
+    db, f := newAuthTestDB(t)
+    scriptSettings(f, nil)
+
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/customers/abc/notes", strings.NewReader(`{"body": "Prefers mornings"}`))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+manager.token)
+    newRouter(db).ServeHTTP(w, req)
+
+    if w.Code != 400 {
+        t.Errorf("got %d, want 400 (%s)", w.Code, w.Body.String())
+    }
+    if len(f.ran("INSERT INTO customer_notes")) != 0 {
+        t.Errorf("tried to insert a note for an invalid id")
+    }
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/lib/pq"
+)
+
+// Pairs scoring at least this much are flagged for review
+const duplicateThreshold = 0.5
+
+var errCustomerNotMergeable = errors.New("only active customer accounts can be merged")
+
+type duplicateCustomer struct {
+    ID    int
+    Name  string
+    Email string
+    Phone string
+}
+
+// normalizeEmail folds the variations people type for the same mailbox:
+// case, +tags, and the dots Gmail ignores.
+func normalizeEmail(email string) string {
+    email = strings.ToLower(strings.TrimSpace(email))
+    at := strings.LastIndex(email, "@")
+    if at < 0 {
+        return email
+    }
+    local, domain := email[:at], email[at+1:]
+    if plus := strings.Index(local, "+"); plus >= 0 {
+        local = local[:plus]
+    }
+    if domain == "gmail.com" || domain == "googlemail.com" {
+        local = strings.ReplaceAll(local, ".", "")
+        domain = "gmail.com"
+    }
+    return local + "@" + domain
+}
+
+func emailLocalPart(email string) string {
+    if at := strings.LastIndex(email, "@"); at >= 0 {
+        return email[:at]
+    }
+    return email
+}
+
+func normalizeName(name string) string {
+    return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+    ra, rb := []rune(a), []rune(b)
+    prev := make([]int, len(rb)+1)
+    curr := make([]int, len(rb)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        curr[0] = i
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+        }
+        prev, curr = curr, prev
+    }
+    return prev[len(rb)]
+}
+
+// duplicateScore rates how likely a and b are the same person, from 0 to 1,
+// with the signals that matched.
+func duplicateScore(a, b duplicateCustomer) (float64, []string) {
+    score := 0.0
+    reasons := []string{}
+
+    if pa, pb := phoneKey(a.Phone), phoneKey(b.Phone); len(pa) == 10 && pa == pb {
+        score += 0.6
+        reasons = append(reasons, "same_phone")
+    }
+
+    ea, eb := normalizeEmail(a.Email), normalizeEmail(b.Email)
+    switch {
+    case ea == eb:
+        score += 0.6
+        reasons = append(reasons, "same_email")
+    case len(ea) >= 6 && editDistance(ea, eb) <= 2:
+        score += 0.4
+        reasons = append(reasons, "similar_email")
+    case len(emailLocalPart(ea)) >= 4 && emailLocalPart(ea) == emailLocalPart(eb):
+        score += 0.3
+        reasons = append(reasons, "same_email_name")
+    }
+
+    na, nb := normalizeName(a.Name), normalizeName(b.Name)
+    switch {
+    case na != "" && na == nb:
+        score += 0.35
+        reasons = append(reasons, "same_name")
+    case len(na) >= 5 && editDistance(na, nb) <= 2:
+        score += 0.2
+        reasons = append(reasons, "similar_name")
+    }
+
+    if score > 1 {
+        score = 1
+    }
+    return score, reasons
+}
+
+// duplicateBlockKeys are the values two records must share at least one of
+// to be compared, so the scan doesn't score every pair of customers.
+func duplicateBlockKeys(cust duplicateCustomer) []string {
+    keys := []string{}
+    if phone := phoneKey(cust.Phone); len(phone) == 10 {
+        keys = append(keys, "phone:"+phone)
+    }
+    email := normalizeEmail(cust.Email)
+    if local := emailLocalPart(email); len(local) >= 4 {
+        keys = append(keys, "email:"+local[:4])
+    }
+    for _, part := range strings.Fields(normalizeName(cust.Name)) {
+        if len(part) >= 3 {
+            keys = append(keys, "name:"+part)
+        }
+    }
+    return keys
+}
+
+// scanForDuplicates scores likely duplicate customer pairs and records them
+// for review. Pairs staff already dismissed or merged are left alone, and
+// pending pairs that no longer match are dropped.
+func scanForDuplicates(db *sql.DB) (int, error) {
+    rows, err := db.Query(`
+        SELECT id, name, email, COALESCE(phone, '') FROM users
+        WHERE COALESCE(role, 'customer') = 'customer' AND COALESCE(status, 'active') NOT IN ('deleted', 'merged')
+    `)
+    if err != nil {
+        return 0, err
+    }
+    customers := []duplicateCustomer{}
+    for rows.Next() {
+        var cust duplicateCustomer
+        if err := rows.Scan(&cust.ID, &cust.Name, &cust.Email, &cust.Phone); err == nil {
+            customers = append(customers, cust)
+        }
+    }
+    rows.Close()
+
+    blocks := map[string][]int{}
+    for i, cust := range customers {
+        for _, key := range duplicateBlockKeys(cust) {
+            blocks[key] = append(blocks[key], i)
+        }
+    }
+
+    type pair struct{ a, b int }
+    seen := map[pair]bool{}
+    scanStart := time.Now()
+    found := 0
+    for _, members := range blocks {
+        for x := 0; x < len(members); x++ {
+            for y := x + 1; y < len(members); y++ {
+                a, b := customers[members[x]], customers[members[y]]
+                if a.ID > b.ID {
+                    a, b = b, a
+                }
+                if seen[pair{a.ID, b.ID}] {
+                    continue
+                }
+                seen[pair{a.ID, b.ID}] = true
+
+                score, reasons := duplicateScore(a, b)
+                if score < duplicateThreshold {
+                    continue
+                }
+                _, err := db.Exec(`
+                    INSERT INTO duplicate_candidates (user_id, other_user_id, score, reasons, status, detected_at)
+                    VALUES ($1, $2, $3, $4, 'pending', $5)
+                    ON CONFLICT (user_id, other_user_id) DO UPDATE
+                    SET score = EXCLUDED.score, reasons = EXCLUDED.reasons, detected_at = EXCLUDED.detected_at
+                    WHERE duplicate_candidates.status = 'pending'
+                `, a.ID, b.ID, score, pq.Array(reasons), scanStart)
+                if err != nil {
+                    return found, err
+                }
+                found++
+            }
+        }
+    }
+
+    _, err = db.Exec("DELETE FROM duplicate_candidates WHERE status = 'pending' AND detected_at < $1", scanStart)
+    return found, err
+}
+
+func runDuplicateScanner(db *sql.DB) {
+    ticker := time.NewTicker(time.Hour)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        if found, err := scanForDuplicates(db); err != nil {
+            log.Printf("Duplicate customer scan failed: %v", err)
+        } else if found > 0 {
+            log.Printf("Duplicate customer scan flagged %d pairs", found)
+        }
+    }
+}
+
+// Tables whose rows follow the customer to the surviving account. Each
+// statement takes the survivor as $1 and the duplicate as $2.
+var customerMergeMoves = []struct {
+    Name      string
+    Statement string
+}{
+    {"pets", "UPDATE pets SET user_id = $1 WHERE user_id = $2"},
+    {"appointments", "UPDATE appointments SET user_id = $1 WHERE user_id = $2"},
+    {"appointment_series", "UPDATE appointment_series SET user_id = $1 WHERE user_id = $2"},
+    {"payments", "UPDATE payments SET user_id = $1 WHERE user_id = $2"},
+    {"photos", "UPDATE pet_photos SET uploaded_by = $1 WHERE uploaded_by = $2"},
+    {"photo_albums", "UPDATE photo_albums SET user_id = $1 WHERE user_id = $2"},
+    // A photo both accounts liked keeps the survivor's like
+    {"duplicate_likes", "DELETE FROM photo_likes WHERE user_id = $2 AND photo_id IN (SELECT photo_id FROM photo_likes WHERE user_id = $1)"},
+    {"likes", "UPDATE photo_likes SET user_id = $1 WHERE user_id = $2"},
+    {"comments", "UPDATE photo_comments SET user_id = $1 WHERE user_id = $2"},
+    {"rewards", "UPDATE rewards SET user_id = $1 WHERE user_id = $2"},
+    {"waitlist_entries", "UPDATE waitlist_entries SET user_id = $1 WHERE user_id = $2"},
+    {"notifications", "UPDATE notifications SET user_id = $1 WHERE user_id = $2"},
+    {"notification_outbox", "UPDATE notification_outbox SET user_id = $1 WHERE user_id = $2"},
+    {"sms_messages", "UPDATE sms_messages SET user_id = $1 WHERE user_id = $2"},
+    {"diy_queue", "UPDATE diy_queue SET user_id = $1 WHERE user_id = $2"},
+    {"customer_notes", "UPDATE customer_notes SET user_id = $1 WHERE user_id = $2"},
+    {"customer_tags", `
+        INSERT INTO customer_tags (user_id, tag, created_by, created_at)
+        SELECT $1, tag, created_by, created_at FROM customer_tags WHERE user_id = $2
+        ON CONFLICT (user_id, LOWER(tag)) DO NOTHING`},
+}
+
+// mergeCustomers folds duplicateID into survivorID in one transaction and
+// returns how many rows moved per table. The duplicate is left behind as a
+// 'merged' stub pointing at the survivor, with its email freed up.
+func mergeCustomers(db *sql.DB, survivorID, duplicateID int, mergedBy *int) (map[string]int64, string, error) {
+    moved := map[string]int64{}
+    var duplicateEmail string
+    err := withChangeContext(db, mergedBy, "Duplicate customer merged", func(tx *sql.Tx) error {
+        rows, err := tx.Query(`
+            SELECT id, COALESCE(role, 'customer'), COALESCE(status, 'active'), email FROM users
+            WHERE id IN ($1, $2) ORDER BY id FOR UPDATE
+        `, survivorID, duplicateID)
+        if err != nil {
+            return err
+        }
+        count := 0
+        for rows.Next() {
+            var id int
+            var role, status, email string
+            if err := rows.Scan(&id, &role, &status, &email); err != nil {
+                rows.Close()
+                return err
+            }
+            if role != "customer" || status == "deleted" || status == "merged" {
+                rows.Close()
+                return errCustomerNotMergeable
+            }
+            if id == duplicateID {
+                duplicateEmail = email
+            }
+            count++
+        }
+        rows.Close()
+        if count != 2 {
+            return sql.ErrNoRows
+        }
+
+        for _, move := range customerMergeMoves {
+            result, err := tx.Exec(move.Statement, survivorID, duplicateID)
+            if err != nil {
+                return fmt.Errorf("%s: %w", move.Name, err)
+            }
+            moved[move.Name], _ = result.RowsAffected()
+        }
+
+        // Loyalty carries over; the survivor keeps their own phone if they have one
+        _, err = tx.Exec(`
+            UPDATE users SET
+                wash_count = wash_count + (SELECT wash_count FROM users WHERE id = $2),
+                phone = COALESCE(NULLIF(phone, ''), (SELECT phone FROM users WHERE id = $2)),
+                updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, survivorID, duplicateID)
+        if err != nil {
+            return err
+        }
+
+        statements := []string{
+            "DELETE FROM customer_tags WHERE user_id = $1",
+            "DELETE FROM notification_preferences WHERE user_id = $1",
+            "DELETE FROM push_subscriptions WHERE user_id = $1",
+            "DELETE FROM account_tokens WHERE user_id = $1",
+            "DELETE FROM user_totp WHERE user_id = $1",
+            "DELETE FROM totp_recovery_codes WHERE user_id = $1",
+            "DELETE FROM password_history WHERE user_id = $1",
+            "DELETE FROM user_sessions WHERE user_id = $1",
+        }
+        for _, statement := range statements {
+            if _, err := tx.Exec(statement, duplicateID); err != nil {
+                return err
+            }
+        }
+        _, err = tx.Exec(`
+            UPDATE users
+            SET email = 'merged-' || id || '@merged.invalid', phone = NULL, password = '', wash_count = 0,
+                status = 'merged', merged_into_id = $2, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, duplicateID, survivorID)
+        if err != nil {
+            return err
+        }
+
+        // Close out this pair; other pairs with the duplicate no longer apply
+        _, err = tx.Exec(`
+            UPDATE duplicate_candidates SET status = 'merged', resolved_by = $3, resolved_at = CURRENT_TIMESTAMP
+            WHERE user_id = LEAST($1, $2) AND other_user_id = GREATEST($1, $2)
+        `, survivorID, duplicateID, mergedBy)
+        if err != nil {
+            return err
+        }
+        _, err = tx.Exec(`
+            DELETE FROM duplicate_candidates
+            WHERE status = 'pending' AND (user_id = $1 OR other_user_id = $1)
+        `, duplicateID)
+        return err
+    })
+    return moved, duplicateEmail, err
+}
+
+type DuplicateCandidate struct {
+    ID         int        `json:"id"`
+    Score      float64    `json:"score"`
+    Reasons    []string   `json:"reasons"`
+    Status     string     `json:"status"`
+    DetectedAt time.Time  `json:"detected_at"`
+    ResolvedAt *time.Time `json:"resolved_at"`
+    Customers  []gin.H    `json:"customers"`
+}
+
+func registerDuplicateRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    // Likely duplicate pairs, best matches first
+    admin.GET("/customer-duplicates", func(c *gin.Context) {
+        if !requirePermission(c, customerReadPermissions...) {
+            return
+        }
+        status := c.DefaultQuery("status", "pending")
+
+        rows, err := db.Query(`
+            SELECT d.id, d.score, d.reasons, d.status, d.detected_at, d.resolved_at,
+                   a.id, a.name, a.email, COALESCE(a.phone, ''), a.created_at,
+                   (SELECT COUNT(*) FROM pets WHERE user_id = a.id), (SELECT COUNT(*) FROM appointments WHERE user_id = a.id),
+                   b.id, b.name, b.email, COALESCE(b.phone, ''), b.created_at,
+                   (SELECT COUNT(*) FROM pets WHERE user_id = b.id), (SELECT COUNT(*) FROM appointments WHERE user_id = b.id)
+            FROM duplicate_candidates d
+            JOIN users a ON d.user_id = a.id
+            JOIN users b ON d.other_user_id = b.id
+            WHERE d.status = $1
+            ORDER BY d.score DESC, d.detected_at DESC
+            LIMIT 200
+        `, status)
+        if err != nil {
+            log.Printf("Failed to fetch duplicate customers: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch duplicates"})
+            return
+        }
+        defer rows.Close()
+
+        candidates := []DuplicateCandidate{}
+        for rows.Next() {
+            var d DuplicateCandidate
+            var reasons pq.StringArray
+            var aID, bID, aPets, bPets, aApts, bApts int
+            var aName, aEmail, aPhone, bName, bEmail, bPhone string
+            var aCreated, bCreated time.Time
+            err := rows.Scan(&d.ID, &d.Score, &reasons, &d.Status, &d.DetectedAt, &d.ResolvedAt,
+                &aID, &aName, &aEmail, &aPhone, &aCreated, &aPets, &aApts,
+                &bID, &bName, &bEmail, &bPhone, &bCreated, &bPets, &bApts)
+            if err != nil {
+                log.Printf("Error scanning duplicate candidate: %v", err)
+                continue
+            }
+            d.Reasons = []string(reasons)
+            d.Customers = []gin.H{
+                {"id": aID, "name": aName, "email": aEmail, "phone": aPhone, "created_at": aCreated, "pet_count": aPets, "appointment_count": aApts},
+                {"id": bID, "name": bName, "email": bEmail, "phone": bPhone, "created_at": bCreated, "pet_count": bPets, "appointment_count": bApts},
+            }
+            candidates = append(candidates, d)
+        }
+
+        c.JSON(200, gin.H{"duplicates": candidates})
+    })
+
+    // Run the hourly scan now, e.g. after a batch of walk-in signups
+    admin.POST("/customer-duplicates/scan", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management") {
+            return
+        }
+        found, err := scanForDuplicates(db)
+        if err != nil {
+            log.Printf("Duplicate customer scan failed: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to scan for duplicates"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Scan complete", "flagged": found})
+    })
+
+    // Not the same person; later scans won't flag the pair again
+    admin.POST("/customer-duplicates/:id/dismiss", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        result, err := db.Exec(`
+            UPDATE duplicate_candidates SET status = 'dismissed', resolved_by = $2, resolved_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND status = 'pending'
+        `, c.Param("id"), actorID(c))
+        if err != nil {
+            log.Printf("Failed to dismiss duplicate: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to dismiss duplicate"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(404, gin.H{"error": "Duplicate not found"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Duplicate dismissed"})
+    })
+
+    // Merge duplicate_id into the customer in the URL, which survives
+    admin.POST("/customers/:id/merge", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management") {
+            return
+        }
+        survivorID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid customer ID"})
+            return
+        }
+        var req struct {
+            DuplicateID int `json:"duplicate_id" binding:"required"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if req.DuplicateID == survivorID {
+            c.JSON(400, gin.H{"error": "Can't merge a customer into themselves"})
+            return
+        }
+
+        mergedBy := actorID(c)
+        moved, duplicateEmail, err := mergeCustomers(db, survivorID, req.DuplicateID, mergedBy)
+        if err == sql.ErrNoRows {
+            c.JSON(404, gin.H{"error": "Customer not found"})
+            return
+        }
+        if err == errCustomerNotMergeable {
+            c.JSON(400, gin.H{"error": "Only active customer accounts can be merged"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to merge customer %d into %d: %v", req.DuplicateID, survivorID, err)
+            c.JSON(500, gin.H{"error": "Failed to merge customers"})
+            return
+        }
+
+        for name, n := range moved {
+            if n == 0 {
+                delete(moved, name)
+            }
+        }
+        writeAuditLog(db, c, mergedBy, "customers_merged", "users", &survivorID, gin.H{
+            "duplicate_id":    req.DuplicateID,
+            "duplicate_email": duplicateEmail,
+            "moved":           moved,
+        })
+
+        c.JSON(200, gin.H{"message": "Customers merged", "survivor_id": survivorID, "moved": moved})
+    })
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "fmt"
+    "net/smtp"
+    "os"
+    "strings"
+    "time"
+)
+
+type smtpConfig struct {
+    Host     string
+    Port     string
+    Username string
+    Password string
+    From     string
+}
+
+// loadSMTPConfig reads the mail server from the environment. The defaults
+// point at a local MailHog (docker-compose) so development mail is caught
+// instead of delivered.
+func loadSMTPConfig() smtpConfig {
+    cfg := smtpConfig{
+        Host:     os.Getenv("SMTP_HOST"),
+        Port:     os.Getenv("SMTP_PORT"),
+        Username: os.Getenv("SMTP_USERNAME"),
+        Password: os.Getenv("SMTP_PASSWORD"),
+        From:     os.Getenv("SMTP_FROM"),
+    }
+    if cfg.Host == "" {
+        cfg.Host = "localhost"
+    }
+    if cfg.Port == "" {
+        cfg.Port = "1025"
+    }
+    if cfg.From == "" {
+        cfg.From = "Jake's Bath House <no-reply@jakesbathhouse.local>"
+    }
+    return cfg
+}
+
+// sendEmail delivers a plain-text message over SMTP.
+func sendEmail(to string, subject string, body string) error {
+    cfg := loadSMTPConfig()
+
+    var auth smtp.Auth
+    if cfg.Username != "" {
+        auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+    }
+
+    // Strip CR/LF so user-supplied values can't inject headers
+    clean := strings.NewReplacer("\r", "", "\n", "")
+    headers := []string{
+        "From: " + cfg.From,
+        "To: " + clean.Replace(to),
+        "Subject: " + clean.Replace(subject),
+        "Date: " + time.Now().Format(time.RFC1123Z),
+        "MIME-Version: 1.0",
+        "Content-Type: text/plain; charset=UTF-8",
+    }
+    message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+
+    if err := smtp.SendMail(cfg.Host+":"+cfg.Port, auth, envelopeAddress(cfg.From), []string{clean.Replace(to)}, []byte(message)); err != nil {
+        return fmt.Errorf("send mail to %s: %w", to, err)
+    }
+    return nil
+}
+
+// envelopeAddress pulls the bare address out of "Name <addr>".
+func envelopeAddress(from string) string {
+    if start := strings.LastIndex(from, "<"); start >= 0 {
+        if end := strings.LastIndex(from, ">"); end > start {
+            return from[start+1 : end]
+        }
+    }
+    return from
+}
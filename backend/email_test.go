@@ -0,0 +1,147 @@
+package main
+
+import (
+    "bufio"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// smtpCatcher is a minimal SMTP server standing in for MailHog: it accepts
+// every message and keeps it.
+type smtpCatcher struct {
+    listener net.Listener
+    mu       sync.Mutex
+    messages []caughtMail
+}
+
+type caughtMail struct {
+    From string
+    To   []string
+    Data string
+}
+
+func startSMTPCatcher(t *testing.T) *smtpCatcher {
+    t.Helper()
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    catcher := &smtpCatcher{listener: l}
+    t.Cleanup(func() { l.Close() })
+    go func() {
+        for {
+            conn, err := l.Accept()
+            if err != nil {
+                return
+            }
+            go catcher.serve(conn)
+        }
+    }()
+
+    host, port, _ := net.SplitHostPort(l.Addr().String())
+    t.Setenv("SMTP_HOST", host)
+    t.Setenv("SMTP_PORT", port)
+    t.Setenv("SMTP_USERNAME", "")
+    return catcher
+}
+
+func (s *smtpCatcher) serve(conn net.Conn) {
+    defer conn.Close()
+    r := bufio.NewReader(conn)
+    reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+    reply("220 catcher ready")
+    var mail caughtMail
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return
+        }
+        command := strings.ToUpper(strings.TrimSpace(line))
+        switch {
+        case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+            reply("250 catcher")
+        case strings.HasPrefix(command, "MAIL FROM:"):
+            mail = caughtMail{From: strings.Trim(strings.TrimSpace(line)[10:], "<>")}
+            reply("250 OK")
+        case strings.HasPrefix(command, "RCPT TO:"):
+            mail.To = append(mail.To, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+            reply("250 OK")
+        case command == "DATA":
+            reply("354 go ahead")
+            var data strings.Builder
+            for {
+                line, err := r.ReadString('\n')
+                if err != nil {
+                    return
+                }
+                if line == ".\r\n" {
+                    break
+                }
+                data.WriteString(line)
+            }
+            mail.Data = data.String()
+            s.mu.Lock()
+            s.messages = append(s.messages, mail)
+            s.mu.Unlock()
+            reply("250 queued")
+        case command == "QUIT":
+            reply("221 bye")
+            return
+        default:
+            reply("250 OK")
+        }
+    }
+}
+
+func (s *smtpCatcher) caught() []caughtMail {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]caughtMail(nil), s.messages...)
+}
+
+func TestSendEmailToLocalCatcher(t *testing.T) {
+    catcher := startSMTPCatcher(t)
+    t.Setenv("SMTP_FROM", "Jake's Bath House <hello@jakesbathhouse.test>")
+
+    err := sendEmail("sam@example.com", "Reminder: Bella's Full Groom\r\nBcc: everyone@example.com", "Hi Sam,\nSee you soon!\n")
+    if err != nil {
+        t.Fatalf("sendEmail: %v", err)
+    }
+
+    messages := catcher.caught()
+    if len(messages) != 1 {
+        t.Fatalf("caught %d messages, want 1", len(messages))
+    }
+    mail := messages[0]
+    if mail.From != "hello@jakesbathhouse.test" || len(mail.To) != 1 || mail.To[0] != "sam@example.com" {
+        t.Errorf("envelope from %q to %v", mail.From, mail.To)
+    }
+    headers, body, _ := strings.Cut(mail.Data, "\r\n\r\n")
+    if !strings.Contains(headers, "Subject: Reminder: Bella's Full GroomBcc: everyone@example.com\r\n") {
+        t.Errorf("subject header not sanitized:\n%s", headers)
+    }
+    if strings.Contains(headers, "\r\nBcc:") {
+        t.Errorf("header injected:\n%s", headers)
+    }
+    if body != "Hi Sam,\r\nSee you soon!\r\n" {
+        t.Errorf("body %q", body)
+    }
+}
+
+func TestSendEmailReportsServerDown(t *testing.T) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    host, port, _ := net.SplitHostPort(l.Addr().String())
+    l.Close()
+    t.Setenv("SMTP_HOST", host)
+    t.Setenv("SMTP_PORT", port)
+
+    if err := sendEmail("sam@example.com", "Hello", "Hi"); err == nil {
+        t.Error("sendEmail succeeded with no mail server")
+    }
+}
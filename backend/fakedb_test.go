@@ -0,0 +1,195 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// fakeDB is a scripted stand-in for Postgres, for driving handlers and
+// helpers without a server. Each statement goes to the most recently added
+// rule whose match appears in its SQL (whitespace-insensitive); anything
+// unscripted fails the way a missing table would.
+type fakeDB struct {
+    mu        sync.Mutex
+    rules     []fakeRule
+    calls     []fakeCall
+    commits   int
+    rollbacks int
+}
+
+type fakeRule struct {
+    match   string
+    columns []string
+    rows    func(args []driver.Value) [][]driver.Value
+    exec    func(args []driver.Value) (int64, error)
+    err     error
+}
+
+type fakeCall struct {
+    query string
+    args  []driver.Value
+}
+
+func newFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+    t.Helper()
+    f := &fakeDB{}
+    db := sql.OpenDB(f)
+    t.Cleanup(func() { db.Close() })
+    return db, f
+}
+
+func squash(query string) string {
+    return strings.Join(strings.Fields(query), " ")
+}
+
+// on scripts a query: rows gets the bound arguments and returns the result
+// rows, or none.
+func (f *fakeDB) on(match string, columns []string, rows func(args []driver.Value) [][]driver.Value) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.rules = append(f.rules, fakeRule{match: squash(match), columns: columns, rows: rows})
+}
+
+// onExec scripts a statement run with Exec, returning rows affected.
+func (f *fakeDB) onExec(match string, exec func(args []driver.Value) (int64, error)) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.rules = append(f.rules, fakeRule{match: squash(match), exec: exec})
+}
+
+// fail makes a statement, queried or executed, return err.
+func (f *fakeDB) fail(match string, err error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.rules = append(f.rules, fakeRule{match: squash(match), err: err})
+}
+
+// ran returns the calls whose SQL contains match.
+func (f *fakeDB) ran(match string) []fakeCall {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    var calls []fakeCall
+    for _, call := range f.calls {
+        if strings.Contains(call.query, squash(match)) {
+            calls = append(calls, call)
+        }
+    }
+    return calls
+}
+
+func (f *fakeDB) find(query string, args []driver.Value, exec bool) (fakeRule, bool) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    query = squash(query)
+    f.calls = append(f.calls, fakeCall{query: query, args: args})
+    for i := len(f.rules) - 1; i >= 0; i-- {
+        rule := f.rules[i]
+        if (rule.err != nil || (rule.exec != nil) == exec) && strings.Contains(query, rule.match) {
+            return rule, true
+        }
+    }
+    return fakeRule{}, false
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.f, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+
+// Begin shows up in the call log as BEGIN, so tests can tell what ran inside
+// a transaction.
+func (c fakeConn) Begin() (driver.Tx, error) {
+    c.f.mu.Lock()
+    c.f.calls = append(c.f.calls, fakeCall{query: "BEGIN"})
+    c.f.mu.Unlock()
+    return fakeTx{c.f}, nil
+}
+
+type fakeTx struct{ f *fakeDB }
+
+func (tx fakeTx) Commit() error {
+    tx.f.mu.Lock()
+    tx.f.commits++
+    tx.f.mu.Unlock()
+    return nil
+}
+
+func (tx fakeTx) Rollback() error {
+    tx.f.mu.Lock()
+    tx.f.rollbacks++
+    tx.f.mu.Unlock()
+    return nil
+}
+
+type fakeStmt struct {
+    f     *fakeDB
+    query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+    rule, ok := s.f.find(s.query, args, true)
+    if !ok {
+        return nil, fmt.Errorf("unscripted statement: %s", squash(s.query))
+    }
+    if rule.err != nil {
+        return nil, rule.err
+    }
+    n, err := rule.exec(args)
+    if err != nil {
+        return nil, err
+    }
+    return driver.RowsAffected(n), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+    rule, ok := s.f.find(s.query, args, false)
+    if !ok {
+        return nil, fmt.Errorf("unscripted query: %s", squash(s.query))
+    }
+    if rule.err != nil {
+        return nil, rule.err
+    }
+    var rows [][]driver.Value
+    if rule.rows != nil {
+        rows = rule.rows(args)
+    }
+    return &fakeRows{columns: rule.columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+    columns []string
+    rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+    if len(r.rows) == 0 {
+        return io.EOF
+    }
+    if len(r.rows[0]) != len(dest) {
+        return errors.New("scripted row has the wrong number of columns")
+    }
+    copy(dest, r.rows[0])
+    r.rows = r.rows[1:]
+    return nil
+}
+
+// row is shorthand for a single-row result.
+func row(values ...driver.Value) [][]driver.Value {
+    return [][]driver.Value{values}
+}
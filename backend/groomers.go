@@ -0,0 +1,541 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+type GroomerHours struct {
+    DayOfWeek int    `json:"day_of_week" binding:"min=0,max=6"`
+    StartTime string `json:"start_time" binding:"required"`
+    EndTime   string `json:"end_time" binding:"required"`
+}
+
+type GroomerTimeOff struct {
+    ID        int     `json:"id" db:"id"`
+    StartDate string  `json:"start_date" db:"start_date" binding:"required"`
+    EndDate   string  `json:"end_date" db:"end_date" binding:"required"`
+    StartTime *string `json:"start_time" db:"start_time"`
+    EndTime   *string `json:"end_time" db:"end_time"`
+    Reason    string  `json:"reason" db:"reason"`
+}
+
+type Groomer struct {
+    ID     int            `json:"id"`
+    UserID int            `json:"user_id"`
+    Name   string         `json:"name"`
+    Hours  []GroomerHours `json:"hours,omitempty"`
+}
+
+type CalendarAppointment struct {
+    ID              int    `json:"id"`
+    Date            string `json:"date"`
+    StartTime       string `json:"start_time"`
+    EndTime         string `json:"end_time"`
+    Status          string `json:"status"`
+    PetName         string `json:"pet_name"`
+    CustomerName    string `json:"customer_name"`
+    ServiceName     string `json:"service_name"`
+    DurationMinutes int    `json:"duration_minutes"`
+}
+
+type CalendarDay struct {
+    Date          string                `json:"date"`
+    Hours         []GroomerHours        `json:"hours"`
+    TimeOff       []GroomerTimeOff      `json:"time_off"`
+    Appointments  []CalendarAppointment `json:"appointments"`
+    BookedMinutes int                   `json:"booked_minutes"`
+}
+
+type GroomerCalendar struct {
+    Groomer Groomer       `json:"groomer"`
+    Days    []CalendarDay `json:"days"`
+}
+
+func loadGroomers(db *sql.DB, groomerID string) ([]Groomer, error) {
+    query := `
+        SELECT au.id, au.user_id, u.name
+        FROM admin_users au
+        JOIN users u ON au.user_id = u.id
+        WHERE au.is_groomer = TRUE AND u.status = 'active'`
+    args := []interface{}{}
+    if groomerID != "" {
+        query += " AND au.id = $1"
+        args = append(args, groomerID)
+    }
+    query += " ORDER BY u.name"
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var groomers []Groomer
+    for rows.Next() {
+        var g Groomer
+        if err := rows.Scan(&g.ID, &g.UserID, &g.Name); err != nil {
+            return nil, err
+        }
+        groomers = append(groomers, g)
+    }
+    return groomers, rows.Err()
+}
+
+func loadGroomerHours(db *sql.DB, groomerID int) ([]GroomerHours, error) {
+    rows, err := db.Query(`
+        SELECT day_of_week, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+        FROM groomer_hours
+        WHERE admin_user_id = $1 AND active = TRUE
+        ORDER BY day_of_week, start_time
+    `, groomerID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var hours []GroomerHours
+    for rows.Next() {
+        var h GroomerHours
+        if err := rows.Scan(&h.DayOfWeek, &h.StartTime, &h.EndTime); err != nil {
+            return nil, err
+        }
+        hours = append(hours, h)
+    }
+    return hours, rows.Err()
+}
+
+func loadGroomerTimeOff(db *sql.DB, groomerID int, from string, to string) ([]GroomerTimeOff, error) {
+    rows, err := db.Query(`
+        SELECT id, to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD'),
+               to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'), COALESCE(reason, '')
+        FROM groomer_time_off
+        WHERE admin_user_id = $1 AND end_date >= $2 AND start_date <= $3
+        ORDER BY start_date
+    `, groomerID, from, to)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var timeOff []GroomerTimeOff
+    for rows.Next() {
+        var t GroomerTimeOff
+        if err := rows.Scan(&t.ID, &t.StartDate, &t.EndDate, &t.StartTime, &t.EndTime, &t.Reason); err != nil {
+            return nil, err
+        }
+        timeOff = append(timeOff, t)
+    }
+    return timeOff, rows.Err()
+}
+
+// buildGroomerCalendar lays out one groomer's week starting on weekStart.
+func buildGroomerCalendar(db *sql.DB, groomer Groomer, weekStart time.Time) (GroomerCalendar, error) {
+    from := weekStart.Format(dateLayout)
+    to := weekStart.AddDate(0, 0, 6).Format(dateLayout)
+
+    hours, err := loadGroomerHours(db, groomer.ID)
+    if err != nil {
+        return GroomerCalendar{}, err
+    }
+    timeOff, err := loadGroomerTimeOff(db, groomer.ID, from, to)
+    if err != nil {
+        return GroomerCalendar{}, err
+    }
+
+    rows, err := db.Query(`
+        SELECT a.id, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI'),
+               to_char(a.appointment_time + make_interval(mins => COALESCE(s.duration_minutes, 60)), 'HH24:MI'),
+               a.status, p.name, u.name, s.name, COALESCE(s.duration_minutes, 60)
+        FROM appointments a
+        JOIN pets p ON a.pet_id = p.id
+        JOIN users u ON a.user_id = u.id
+        JOIN services s ON a.service_id = s.id
+        WHERE a.groomer_id = $1 AND a.appointment_date BETWEEN $2 AND $3 AND a.status != 'cancelled'
+        ORDER BY a.appointment_date, a.appointment_time
+    `, groomer.ID, from, to)
+    if err != nil {
+        return GroomerCalendar{}, err
+    }
+    defer rows.Close()
+
+    byDate := make(map[string][]CalendarAppointment)
+    for rows.Next() {
+        var a CalendarAppointment
+        if err := rows.Scan(&a.ID, &a.Date, &a.StartTime, &a.EndTime, &a.Status, &a.PetName,
+            &a.CustomerName, &a.ServiceName, &a.DurationMinutes); err != nil {
+            return GroomerCalendar{}, err
+        }
+        byDate[a.Date] = append(byDate[a.Date], a)
+    }
+
+    calendar := GroomerCalendar{Groomer: groomer}
+    for i := 0; i < 7; i++ {
+        day := weekStart.AddDate(0, 0, i)
+        date := day.Format(dateLayout)
+
+        entry := CalendarDay{
+            Date:         date,
+            Hours:        []GroomerHours{},
+            TimeOff:      []GroomerTimeOff{},
+            Appointments: byDate[date],
+        }
+        if entry.Appointments == nil {
+            entry.Appointments = []CalendarAppointment{}
+        }
+        for _, h := range hours {
+            if h.DayOfWeek == int(day.Weekday()) {
+                entry.Hours = append(entry.Hours, h)
+            }
+        }
+        for _, t := range timeOff {
+            if t.StartDate <= date && t.EndDate >= date {
+                entry.TimeOff = append(entry.TimeOff, t)
+            }
+        }
+        for _, a := range entry.Appointments {
+            entry.BookedMinutes += a.DurationMinutes
+        }
+        calendar.Days = append(calendar.Days, entry)
+    }
+    return calendar, nil
+}
+
+// weekStarting returns the Monday of the week containing day.
+func weekStarting(day time.Time) time.Time {
+    offset := (int(day.Weekday()) + 6) % 7
+    return day.AddDate(0, 0, -offset)
+}
+
+func registerGroomerRoutes(api *gin.RouterGroup, admin *gin.RouterGroup, db *sql.DB) {
+    // Customers pick from this list when requesting a groomer
+    api.GET("/groomers", func(c *gin.Context) {
+        groomers, err := loadGroomers(db, "")
+        if err != nil {
+            log.Printf("Failed to fetch groomers: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch groomers"})
+            return
+        }
+        c.JSON(200, gin.H{"groomers": groomers})
+    })
+
+    admin.GET("/groomers", func(c *gin.Context) {
+        if !requirePermission(c, appointmentReadPermissions...) {
+            return
+        }
+        groomers, err := loadGroomers(db, "")
+        if err != nil {
+            log.Printf("Failed to fetch groomers: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch groomers"})
+            return
+        }
+        for i := range groomers {
+            groomers[i].Hours, _ = loadGroomerHours(db, groomers[i].ID)
+        }
+        c.JSON(200, gin.H{"groomers": groomers})
+    })
+
+    // Replace a groomer's weekly hours (also marks the staff member as a groomer)
+    admin.PUT("/groomers/:id/hours", func(c *gin.Context) {
+        if !requirePermission(c, "staff_management") {
+            return
+        }
+        groomerID := c.Param("id")
+
+        var req struct {
+            Hours []GroomerHours `json:"hours" binding:"dive"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        for _, h := range req.Hours {
+            start, err1 := parseClock(h.StartTime)
+            end, err2 := parseClock(h.EndTime)
+            if err1 != nil || err2 != nil || !end.After(start) {
+                c.JSON(400, gin.H{"error": "Each entry needs HH:MM start_time before end_time"})
+                return
+            }
+        }
+
+        tx, err := db.Begin()
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to update hours"})
+            return
+        }
+        defer tx.Rollback()
+
+        result, err := tx.Exec("UPDATE admin_users SET is_groomer = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1", groomerID)
+        if err != nil {
+            log.Printf("Failed to update groomer: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to update hours"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(404, gin.H{"error": "Staff member not found"})
+            return
+        }
+
+        if _, err := tx.Exec("DELETE FROM groomer_hours WHERE admin_user_id = $1", groomerID); err != nil {
+            log.Printf("Failed to clear groomer hours: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to update hours"})
+            return
+        }
+        for _, h := range req.Hours {
+            _, err := tx.Exec(`
+                INSERT INTO groomer_hours (admin_user_id, day_of_week, start_time, end_time, active)
+                VALUES ($1, $2, $3, $4, TRUE)
+            `, groomerID, h.DayOfWeek, h.StartTime, h.EndTime)
+            if err != nil {
+                log.Printf("Failed to insert groomer hours: %v", err)
+                c.JSON(500, gin.H{"error": "Failed to update hours"})
+                return
+            }
+        }
+
+        if err := tx.Commit(); err != nil {
+            c.JSON(500, gin.H{"error": "Failed to update hours"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Groomer hours updated successfully"})
+    })
+
+    // Stop offering a staff member for grooming
+    admin.DELETE("/groomers/:id", func(c *gin.Context) {
+        if !requirePermission(c, "staff_management") {
+            return
+        }
+        groomerID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid groomer ID"})
+            return
+        }
+
+        // Bookings assigned to the groomer would be left with nobody to do
+        // them, so staff reassign those first. The NOT EXISTS repeats the
+        // check in the UPDATE so a booking made meanwhile still blocks it.
+        today := businessToday(db)
+        result, err := db.Exec(`
+            UPDATE admin_users SET is_groomer = FALSE, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND is_groomer = TRUE
+              AND NOT EXISTS (
+                  SELECT 1 FROM appointments
+                  WHERE groomer_id = $1 AND appointment_date >= $2 AND status IN ('pending', 'confirmed')
+              )
+        `, groomerID, today)
+        if err != nil {
+            log.Printf("Failed to remove groomer: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to remove groomer"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            var upcoming int
+            err := db.QueryRow(`
+                SELECT COUNT(*) FROM appointments
+                WHERE groomer_id = $1 AND appointment_date >= $2 AND status IN ('pending', 'confirmed')
+            `, groomerID, today).Scan(&upcoming)
+            if err == nil && upcoming > 0 {
+                c.JSON(409, gin.H{
+                    "error":                 fmt.Sprintf("This groomer has %d upcoming appointments. Reassign them before removing the groomer", upcoming),
+                    "upcoming_appointments": upcoming,
+                })
+                return
+            }
+            c.JSON(404, gin.H{"error": "Groomer not found"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Groomer removed successfully"})
+    })
+
+    admin.GET("/groomers/:id/time-off", func(c *gin.Context) {
+        if !requirePermission(c, appointmentReadPermissions...) {
+            return
+        }
+        var groomerID int
+        if err := db.QueryRow("SELECT id FROM admin_users WHERE id::text = $1", c.Param("id")).Scan(&groomerID); err != nil {
+            c.JSON(404, gin.H{"error": "Groomer not found"})
+            return
+        }
+
+        from := c.DefaultQuery("from", businessToday(db))
+        to := c.DefaultQuery("to", "9999-12-31")
+        timeOff, err := loadGroomerTimeOff(db, groomerID, from, to)
+        if err != nil {
+            log.Printf("Failed to fetch time off: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch time off"})
+            return
+        }
+        c.JSON(200, gin.H{"time_off": timeOff})
+    })
+
+    admin.POST("/groomers/:id/time-off", func(c *gin.Context) {
+        if !requirePermission(c, "staff_management") {
+            return
+        }
+        var groomerID int
+        if err := db.QueryRow("SELECT id FROM admin_users WHERE id::text = $1", c.Param("id")).Scan(&groomerID); err != nil {
+            c.JSON(404, gin.H{"error": "Groomer not found"})
+            return
+        }
+
+        var req GroomerTimeOff
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if req.EndDate < req.StartDate {
+            c.JSON(400, gin.H{"error": "end_date must be on or after start_date"})
+            return
+        }
+        if (req.StartTime == nil) != (req.EndTime == nil) {
+            c.JSON(400, gin.H{"error": "Provide both start_time and end_time for a partial day, or neither"})
+            return
+        }
+
+        var timeOffID int
+        err := db.QueryRow(`
+            INSERT INTO groomer_time_off (admin_user_id, start_date, end_date, start_time, end_time, reason, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, groomerID, req.StartDate, req.EndDate, req.StartTime, req.EndTime, req.Reason).Scan(&timeOffID)
+        if err != nil {
+            log.Printf("Failed to create time off: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create time off"})
+            return
+        }
+
+        // Let staff know which bookings now need a different groomer
+        var affected int
+        db.QueryRow(`
+            SELECT COUNT(*) FROM appointments
+            WHERE groomer_id = $1 AND appointment_date BETWEEN $2 AND $3 AND status IN ('pending', 'confirmed')
+        `, groomerID, req.StartDate, req.EndDate).Scan(&affected)
+
+        c.JSON(201, gin.H{
+            "message":               "Time off added successfully",
+            "time_off_id":           timeOffID,
+            "affected_appointments": affected,
+        })
+    })
+
+    admin.DELETE("/groomers/:id/time-off/:time_off_id", func(c *gin.Context) {
+        if !requirePermission(c, "staff_management") {
+            return
+        }
+        _, err := db.Exec("DELETE FROM groomer_time_off WHERE id = $1 AND admin_user_id = $2", c.Param("time_off_id"), c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to delete time off: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to delete time off"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Time off deleted successfully"})
+    })
+
+    // Assign a groomer by hand, or auto-assign when groomer_id is omitted
+    admin.PUT("/appointments/:id/groomer", func(c *gin.Context) {
+        if !requirePermission(c, appointmentWritePermissions...) {
+            return
+        }
+        var req struct {
+            GroomerID *int `json:"groomer_id"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        var id, serviceID int
+        var date, clock string
+        err := db.QueryRow(`
+            SELECT id, service_id, to_char(appointment_date, 'YYYY-MM-DD'), to_char(appointment_time, 'HH24:MI')
+            FROM appointments WHERE id = $1
+        `, c.Param("id")).Scan(&id, &serviceID, &date, &clock)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Appointment not found"})
+            return
+        }
+
+        groomerID, reason, err := resolveSlot(db, serviceID, date, clock, id, 0, req.GroomerID)
+        if err != nil {
+            log.Printf("Failed to assign groomer: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to assign groomer"})
+            return
+        }
+        if reason != "" {
+            c.JSON(409, gin.H{"error": reason})
+            return
+        }
+        if groomerID == nil {
+            c.JSON(400, gin.H{"error": "This appointment doesn't take a groomer"})
+            return
+        }
+
+        _, err = db.Exec("UPDATE appointments SET groomer_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", *groomerID, id)
+        if err != nil {
+            log.Printf("Failed to assign groomer: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to assign groomer"})
+            return
+        }
+
+        if apt, err := loadAppointment(db, id); err == nil {
+            broadcastAppointmentUpdate(apt, "groomer_assigned")
+        }
+        c.JSON(200, gin.H{"message": "Groomer assigned successfully", "groomer_id": *groomerID})
+    })
+
+    // Weekly calendar for the staff dashboard
+    admin.GET("/calendar", func(c *gin.Context) {
+        if !requirePermission(c, appointmentReadPermissions...) {
+            return
+        }
+        day := businessNow(db)
+        if week := c.Query("week"); week != "" {
+            parsed, err := time.Parse(dateLayout, week)
+            if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid week, expected any YYYY-MM-DD date in that week"})
+                return
+            }
+            day = parsed
+        }
+        weekStart := weekStarting(day)
+
+        if groomerID := c.Query("groomer_id"); groomerID != "" {
+            if _, err := strconv.Atoi(groomerID); err != nil {
+                c.JSON(400, gin.H{"error": "Invalid groomer_id"})
+                return
+            }
+        }
+        groomers, err := loadGroomers(db, c.Query("groomer_id"))
+        if err != nil {
+            log.Printf("Failed to fetch groomers: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch calendar"})
+            return
+        }
+        if c.Query("groomer_id") != "" && len(groomers) == 0 {
+            c.JSON(404, gin.H{"error": "Groomer not found"})
+            return
+        }
+
+        calendars := []GroomerCalendar{}
+        for _, g := range groomers {
+            calendar, err := buildGroomerCalendar(db, g, weekStart)
+            if err != nil {
+                log.Printf("Failed to build calendar for groomer %d: %v", g.ID, err)
+                c.JSON(500, gin.H{"error": "Failed to fetch calendar"})
+                return
+            }
+            calendars = append(calendars, calendar)
+        }
+
+        c.JSON(200, gin.H{
+            "week_start": weekStart.Format(dateLayout),
+            "week_end":   weekStart.AddDate(0, 0, 6).Format(dateLayout),
+            "calendars":  calendars,
+        })
+    })
+}
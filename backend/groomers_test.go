@@ -0,0 +1,64 @@
+package main
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func adminRequest(t *testing.T, db *sql.DB, method string, path string) *httptest.ResponseRecorder {
+    t.Helper()
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(method, "/api/v1/admin"+path, nil)
+    req.Header.Set("Authorization", "Bearer "+manager.token)
+    newRouter(db).ServeHTTP(w, req)
+    return w
+}
+
+func TestCalendarRejectsNonNumericGroomer(t *testing.T) {
+    db, f := newAuthTestDB(t)
+    scriptSettings(f, nil)
+
+    w := adminRequest(t, db, http.MethodGet, "/calendar?groomer_id=abc")
+    if w.Code != 400 {
+        t.Errorf("got %d, want 400 (%s)", w.Code, w.Body.String())
+    }
+}
+
+func TestRemoveGroomer(t *testing.T) {
+    for _, tc := range []struct {
+        name     string
+        path     string
+        upcoming int64
+        removed  int64
+        want     int
+    }{
+        {"no upcoming bookings", "/groomers/5", 0, 1, 200},
+        {"upcoming bookings", "/groomers/5", 3, 0, 409},
+        {"not a groomer", "/groomers/6", 0, 0, 404},
+        {"non-numeric id", "/groomers/abc", 0, 0, 400},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            db, f := newAuthTestDB(t)
+            scriptSettings(f, nil)
+            f.onExec("UPDATE admin_users SET is_groomer = FALSE", func(args []driver.Value) (int64, error) { return tc.removed, nil })
+            f.on("SELECT COUNT(*) FROM appointments WHERE groomer_id = $1", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+                return row(tc.upcoming)
+            })
+
+            w := adminRequest(t, db, http.MethodDelete, tc.path)
+            if w.Code != tc.want {
+                t.Fatalf("got %d, want %d (%s)", w.Code, tc.want, w.Body.String())
+            }
+            if tc.want == 409 && !strings.Contains(w.Body.String(), "3 upcoming appointments") {
+                t.Errorf("unexpected body %s", w.Body.String())
+            }
+            if tc.want == 400 && len(f.ran("UPDATE admin_users")) != 0 {
+                t.Errorf("ran the update for an invalid id")
+            }
+        })
+    }
+}
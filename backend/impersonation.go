@@ -0,0 +1,218 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Routes staff can't use while acting as a customer: anything that moves
+// money or changes how the customer signs in, plus the admin API itself.
+var impersonationBlockedPrefixes = []string{
+    "/api/v1/admin",
+    "/api/v1/payments",
+    "/api/v1/password-reset",
+    "/api/v1/2fa",
+    "/api/v1/push/subscriptions",
+}
+
+func impersonationBlocked(path string) bool {
+    for _, prefix := range impersonationBlockedPrefixes {
+        if strings.HasPrefix(path, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// serveImpersonated runs a request made with an impersonation token,
+// refusing blocked routes and writing every request to audit_logs under
+// the impersonating admin.
+func serveImpersonated(c *gin.Context, db *sql.DB, caller *Caller) {
+    path := c.FullPath()
+    if path == "" {
+        path = c.Request.URL.Path
+    }
+
+    blocked := impersonationBlocked(path)
+    if blocked {
+        c.AbortWithStatusJSON(403, gin.H{"error": "Not available while acting as a customer", "code": "impersonation_blocked"})
+    } else {
+        c.Next()
+    }
+
+    writeAuditLog(db, c, caller.ImpersonatorID, "impersonated_request", "users", &caller.UserID, gin.H{
+        "session_id": c.GetInt("session_id"),
+        "method":     c.Request.Method,
+        "path":       c.Request.URL.Path,
+        "status":     c.Writer.Status(),
+        "blocked":    blocked,
+    })
+}
+
+func registerImpersonationRoutes(api *gin.RouterGroup, admin *gin.RouterGroup, db *sql.DB) {
+    // Start acting as a customer. The token is a normal session for the
+    // customer that expires after security.impersonation_minutes.
+    admin.POST("/users/:id/impersonate", func(c *gin.Context) {
+        if !requirePermission(c, "customer_service", "customer_management") {
+            return
+        }
+        adminID, ok := currentUserID(c)
+        if !ok || callerFrom(c).ImpersonatorID != nil {
+            c.JSON(403, gin.H{"error": "Sign in as yourself to act as a customer"})
+            return
+        }
+
+        targetID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid user ID"})
+            return
+        }
+        var role, status string
+        err = db.QueryRow("SELECT COALESCE(role, 'customer'), COALESCE(status, 'active') FROM users WHERE id = $1", targetID).Scan(&role, &status)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "User not found"})
+            return
+        }
+        // Acting as staff would hand out their permissions
+        if role != "customer" {
+            c.JSON(400, gin.H{"error": "Only customer accounts can be impersonated"})
+            return
+        }
+        if status != "active" {
+            c.JSON(400, gin.H{"error": "Account is inactive"})
+            return
+        }
+
+        var req struct {
+            Reason string `json:"reason"`
+        }
+        c.ShouldBindJSON(&req)
+
+        token, expiresAt, err := issueSession(db, c, targetID)
+        if err != nil {
+            log.Printf("Failed to start impersonation session: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to start impersonation"})
+            return
+        }
+        minutes := getSettingInt(db, "security", "impersonation_minutes", 30)
+        var sessionID int
+        err = db.QueryRow(`
+            UPDATE user_sessions
+            SET impersonator_id = $2, impersonation_reason = NULLIF($3, ''),
+                expires_at = CURRENT_TIMESTAMP + make_interval(mins => $4)
+            WHERE token_hash = $1
+            RETURNING id, expires_at
+        `, hashToken(token), adminID, req.Reason, minutes).Scan(&sessionID, &expiresAt)
+        if err != nil {
+            log.Printf("Failed to flag impersonation session: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to start impersonation"})
+            return
+        }
+
+        writeAuditLog(db, c, &adminID, "impersonation_started", "users", &targetID, gin.H{
+            "session_id": sessionID,
+            "reason":     req.Reason,
+            "expires_at": expiresAt,
+        })
+
+        c.JSON(201, gin.H{
+            "message":    "Acting as customer",
+            "token":      token,
+            "session_id": sessionID,
+            "user_id":    targetID,
+            "expires_at": expiresAt,
+        })
+    })
+
+    // Sessions currently acting as a customer
+    admin.GET("/impersonations", func(c *gin.Context) {
+        if !requirePermission(c, "customer_service", "customer_management") {
+            return
+        }
+        rows, err := db.Query(`
+            SELECT s.id, s.user_id, u.name, s.impersonator_id, a.name, COALESCE(s.impersonation_reason, ''), s.created_at, s.expires_at
+            FROM user_sessions s
+            JOIN users u ON s.user_id = u.id
+            JOIN users a ON s.impersonator_id = a.id
+            WHERE s.impersonator_id IS NOT NULL AND s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP
+            ORDER BY s.created_at DESC
+        `)
+        if err != nil {
+            log.Printf("Failed to fetch impersonation sessions: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch impersonation sessions"})
+            return
+        }
+        defer rows.Close()
+
+        sessions := []map[string]interface{}{}
+        for rows.Next() {
+            var id, userID, adminID int
+            var userName, adminName, reason string
+            var createdAt, expiresAt time.Time
+            if err := rows.Scan(&id, &userID, &userName, &adminID, &adminName, &reason, &createdAt, &expiresAt); err != nil {
+                continue
+            }
+            sessions = append(sessions, map[string]interface{}{
+                "session_id":      id,
+                "user_id":         userID,
+                "user_name":       userName,
+                "impersonator_id": adminID,
+                "impersonator":    adminName,
+                "reason":          reason,
+                "created_at":      createdAt,
+                "expires_at":      expiresAt,
+            })
+        }
+
+        c.JSON(200, gin.H{"impersonations": sessions})
+    })
+
+    admin.DELETE("/impersonations/:id", func(c *gin.Context) {
+        if !requirePermission(c, "customer_service", "customer_management") {
+            return
+        }
+        var userID int
+        var impersonatorID int
+        err := db.QueryRow(`
+            UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP
+            WHERE id::text = $1 AND impersonator_id IS NOT NULL AND revoked_at IS NULL
+            RETURNING user_id, impersonator_id
+        `, c.Param("id")).Scan(&userID, &impersonatorID)
+        if err == sql.ErrNoRows {
+            c.JSON(404, gin.H{"error": "Impersonation session not found"})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to end impersonation session: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to end impersonation"})
+            return
+        }
+        writeAuditLog(db, c, actorID(c), "impersonation_ended", "users", &userID, gin.H{"session_id": c.Param("id"), "impersonator_id": impersonatorID})
+        c.JSON(200, gin.H{"message": "Impersonation ended"})
+    })
+
+    // Called with the impersonation token itself, e.g. from the banner's "Stop" button
+    api.POST("/impersonation/end", func(c *gin.Context) {
+        caller, ok := requireCaller(c)
+        if !ok {
+            return
+        }
+        if caller.ImpersonatorID == nil {
+            c.JSON(400, gin.H{"error": "Not acting as a customer"})
+            return
+        }
+        sessionID := c.GetInt("session_id")
+        if _, err := db.Exec("UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1", sessionID); err != nil {
+            log.Printf("Failed to end impersonation session: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to end impersonation"})
+            return
+        }
+        writeAuditLog(db, c, caller.ImpersonatorID, "impersonation_ended", "users", &caller.UserID, gin.H{"session_id": sessionID})
+        c.JSON(200, gin.H{"message": "Impersonation ended"})
+    })
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+type InboxNotification struct {
+    ID        int             `json:"id" db:"id"`
+    UserID    int             `json:"user_id" db:"user_id"`
+    EventType string          `json:"event_type" db:"event_type"`
+    Title     string          `json:"title" db:"title"`
+    Body      string          `json:"body" db:"body"`
+    Data      json.RawMessage `json:"data" db:"data"`
+    Read      bool            `json:"read"`
+    ReadAt    *time.Time      `json:"read_at" db:"read_at"`
+    CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+func unreadNotificationCount(db *sql.DB, userID int) int {
+    var count int
+    db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID).Scan(&count)
+    return count
+}
+
+// pushInboxUpdate tells the user's open tabs to refresh the bell icon.
+func pushInboxUpdate(db *sql.DB, userID int, notification *InboxNotification) {
+    data := map[string]interface{}{
+        "unread_count": unreadNotificationCount(db, userID),
+        "timestamp":    time.Now(),
+    }
+    if notification != nil {
+        data["notification"] = notification
+    }
+    sendToUser(userID, "notification", data)
+}
+
+func registerInboxRoutes(api *gin.RouterGroup, db *sql.DB) {
+    api.GET("/notifications", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "Authentication required"})
+            return
+        }
+
+        limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+        if err != nil || limit <= 0 || limit > 200 {
+            limit = 50
+        }
+
+        query := `
+            SELECT id, user_id, event_type, title, body, COALESCE(data, '{}'), read_at, created_at
+            FROM notifications
+            WHERE user_id = $1`
+        args := []interface{}{userID}
+        if c.Query("unread") == "true" {
+            query += " AND read_at IS NULL"
+        }
+        // Older pages: pass the smallest ID already shown
+        if beforeID, err := strconv.Atoi(c.Query("before_id")); err == nil {
+            args = append(args, beforeID)
+            query += " AND id < $" + strconv.Itoa(len(args))
+        }
+        args = append(args, limit)
+        query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args))
+
+        rows, err := db.Query(query, args...)
+        if err != nil {
+            log.Printf("Failed to fetch notifications: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch notifications"})
+            return
+        }
+        defer rows.Close()
+
+        notifications := []InboxNotification{}
+        for rows.Next() {
+            var n InboxNotification
+            var data []byte
+            if err := rows.Scan(&n.ID, &n.UserID, &n.EventType, &n.Title, &n.Body, &data, &n.ReadAt, &n.CreatedAt); err != nil {
+                continue
+            }
+            n.Data = data
+            n.Read = n.ReadAt != nil
+            notifications = append(notifications, n)
+        }
+
+        c.JSON(200, gin.H{"notifications": notifications, "unread_count": unreadNotificationCount(db, userID)})
+    })
+
+    api.POST("/notifications/:id/read", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "Authentication required"})
+            return
+        }
+
+        result, err := db.Exec(`
+            UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+            WHERE id = $1 AND user_id = $2
+        `, c.Param("id"), userID)
+        if err != nil {
+            log.Printf("Failed to mark notification read: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to mark notification read"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(404, gin.H{"error": "Notification not found"})
+            return
+        }
+
+        // Keep the badge in sync across the user's other tabs
+        pushInboxUpdate(db, userID, nil)
+        c.JSON(200, gin.H{"message": "Notification marked as read", "unread_count": unreadNotificationCount(db, userID)})
+    })
+
+    api.POST("/notifications/read-all", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "Authentication required"})
+            return
+        }
+
+        result, err := db.Exec(`
+            UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+            WHERE user_id = $1 AND read_at IS NULL
+        `, userID)
+        if err != nil {
+            log.Printf("Failed to mark notifications read: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to mark notifications read"})
+            return
+        }
+        updated, _ := result.RowsAffected()
+
+        pushInboxUpdate(db, userID, nil)
+        c.JSON(200, gin.H{"message": "All notifications marked as read", "updated": updated, "unread_count": 0})
+    })
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    defaultPageSize = 50
+    maxPageSize     = 200
+)
+
+// sortField is one column a list can be ordered by. Expr must never be NULL
+// (COALESCE it if needed) and Cast is the SQL type its text form casts back to.
+type sortField struct {
+    Expr string
+    Cast string
+}
+
+// listSpec describes what a list endpoint lets callers sort and filter on.
+// Every sort and filter is allowlisted here; nothing from the query string
+// reaches SQL except as a bind parameter.
+type listSpec struct {
+    Sorts       map[string]sortField
+    DefaultSort string
+    DefaultDesc bool
+    ID          string            // unique column that breaks ties, e.g. "a.id"
+    Filters     map[string]string // query param -> column, matched exactly
+    DateColumn  string            // column ?from= and ?to= (YYYY-MM-DD, inclusive) apply to
+}
+
+type listCursor struct {
+    Sort  string `json:"s"`
+    Order string `json:"o"`
+    Key   string `json:"k"`
+    ID    int    `json:"i"`
+}
+
+// ListQuery builds one page of a filtered, sorted list. Pages are keyed on
+// the last row's sort value and ID rather than an offset, so rows added
+// while someone pages through don't shift or repeat results.
+//
+//    page, ok := newListQuery(c, spec)
+//    page.Where("a.user_id = " + page.Arg(userID))
+//    query, args := page.Build(selectColumns, fromAndJoins)
+//    ... for rows.Next() { if more, err := page.Scan(rows, &a, &b); !more { break } ... }
+//    c.JSON(200, gin.H{"items": items, "page": page.Info()})
+type ListQuery struct {
+    spec    listSpec
+    sort    string
+    desc    bool
+    limit   int
+    where   []string
+    args    []interface{}
+    count   int
+    hasMore bool
+    lastKey string
+    lastID  int
+}
+
+// newListQuery reads sort, order, limit, cursor, the spec's filters and the
+// date range from the request. On bad input it writes a 400 and returns false.
+func newListQuery(c *gin.Context, spec listSpec) (*ListQuery, bool) {
+    q := &ListQuery{spec: spec, sort: spec.DefaultSort, desc: spec.DefaultDesc, limit: defaultPageSize}
+
+    if name := c.Query("sort"); name != "" {
+        if _, ok := spec.Sorts[name]; !ok {
+            c.JSON(400, gin.H{"error": fmt.Sprintf("Can't sort by %q", name), "sortable": spec.sortNames()})
+            return nil, false
+        }
+        q.sort = name
+    }
+    switch c.Query("order") {
+    case "":
+    case "asc":
+        q.desc = false
+    case "desc":
+        q.desc = true
+    default:
+        c.JSON(400, gin.H{"error": "order must be asc or desc"})
+        return nil, false
+    }
+    if limit := c.Query("limit"); limit != "" {
+        n, err := strconv.Atoi(limit)
+        if err != nil || n < 1 || n > maxPageSize {
+            c.JSON(400, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+            return nil, false
+        }
+        q.limit = n
+    }
+
+    for param, column := range spec.Filters {
+        if value := c.Query(param); value != "" {
+            q.Where(column + " = " + q.Arg(value))
+        }
+    }
+    if spec.DateColumn != "" {
+        for _, param := range []string{"from", "to"} {
+            value := c.Query(param)
+            if value == "" {
+                continue
+            }
+            if _, err := time.Parse(dateLayout, value); err != nil {
+                c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid %s date, expected YYYY-MM-DD", param)})
+                return nil, false
+            }
+            if param == "from" {
+                q.Where(spec.DateColumn + " >= " + q.Arg(value) + "::date")
+            } else {
+                q.Where(spec.DateColumn + " < " + q.Arg(value) + "::date + 1")
+            }
+        }
+    }
+
+    if raw := c.Query("cursor"); raw != "" {
+        cursor, err := decodeListCursor(raw)
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid cursor"})
+            return nil, false
+        }
+        if cursor.Sort != q.sort || cursor.Order != q.order() {
+            c.JSON(400, gin.H{"error": "Cursor belongs to a different sort order. Start again without a cursor"})
+            return nil, false
+        }
+        field := spec.Sorts[q.sort]
+        op := ">"
+        if q.desc {
+            op = "<"
+        }
+        q.Where(fmt.Sprintf("(%s, %s) %s (%s::%s, %s)", field.Expr, spec.ID, op, q.Arg(cursor.Key), field.Cast, q.Arg(cursor.ID)))
+    }
+    return q, true
+}
+
+func (spec listSpec) sortNames() []string {
+    names := make([]string, 0, len(spec.Sorts))
+    for name := range spec.Sorts {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func (q *ListQuery) order() string {
+    if q.desc {
+        return "desc"
+    }
+    return "asc"
+}
+
+// Arg binds value and returns its placeholder, e.g. "$3".
+func (q *ListQuery) Arg(value interface{}) string {
+    q.args = append(q.args, value)
+    return fmt.Sprintf("$%d", len(q.args))
+}
+
+// Where adds a condition; all conditions are ANDed together.
+func (q *ListQuery) Where(condition string) {
+    q.where = append(q.where, condition)
+}
+
+// Build returns the page query. selectSQL is "SELECT <columns>" and from is
+// the FROM clause with any joins; the sort key and ID are appended to the
+// selected columns for Scan.
+func (q *ListQuery) Build(selectSQL string, from string) (string, []interface{}) {
+    field := q.spec.Sorts[q.sort]
+    direction := "ASC"
+    if q.desc {
+        direction = "DESC"
+    }
+
+    query := fmt.Sprintf("%s, (%s)::text, %s %s", selectSQL, field.Expr, q.spec.ID, from)
+    if len(q.where) > 0 {
+        query += " WHERE " + strings.Join(q.where, " AND ")
+    }
+    // One extra row tells us whether there's another page
+    query += fmt.Sprintf(" ORDER BY %s %s, %s %s LIMIT %d", field.Expr, direction, q.spec.ID, direction, q.limit+1)
+    return query, q.args
+}
+
+// Scan reads the current row into dest. It returns false once the page is
+// full, which means the row it was given belongs to the next page.
+func (q *ListQuery) Scan(rows *sql.Rows, dest ...interface{}) (bool, error) {
+    if q.count >= q.limit {
+        q.hasMore = true
+        return false, nil
+    }
+    var key string
+    var id int
+    if err := rows.Scan(append(dest, &key, &id)...); err != nil {
+        return true, err
+    }
+    q.count++
+    q.lastKey, q.lastID = key, id
+    return true, nil
+}
+
+// Info describes the page for the response, including the cursor for the
+// next one when there is more.
+func (q *ListQuery) Info() gin.H {
+    info := gin.H{"limit": q.limit, "sort": q.sort, "order": q.order(), "has_more": q.hasMore, "next_cursor": nil}
+    if q.hasMore {
+        info["next_cursor"] = encodeListCursor(listCursor{Sort: q.sort, Order: q.order(), Key: q.lastKey, ID: q.lastID})
+    }
+    return info
+}
+
+func encodeListCursor(cursor listCursor) string {
+    raw, _ := json.Marshal(cursor)
+    return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeListCursor(s string) (listCursor, error) {
+    var cursor listCursor
+    raw, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return cursor, err
+    }
+    err = json.Unmarshal(raw, &cursor)
+    return cursor, err
+}
+
+// The paged list endpoints.
+var (
+    adminAppointmentList = listSpec{
+        Sorts: map[string]sortField{
+            "date":       {"a.appointment_date + a.appointment_time", "timestamp"},
+            "created_at": {"a.created_at", "timestamp"},
+            "customer":   {"u.name", "text"},
+            "service":    {"s.name", "text"},
+            "status":     {"a.status", "text"},
+        },
+        DefaultSort: "date",
+        DefaultDesc: true,
+        ID:          "a.id",
+        Filters: map[string]string{
+            "status":         "a.status",
+            "date":           "a.appointment_date",
+            "service_type":   "s.type",
+            "payment_status": "pay.status",
+            "user_id":        "a.user_id",
+        },
+        DateColumn: "a.appointment_date",
+    }
+
+    userAppointmentList = listSpec{
+        Sorts: map[string]sortField{
+            "date":       {"a.appointment_date + a.appointment_time", "timestamp"},
+            "created_at": {"a.created_at", "timestamp"},
+        },
+        DefaultSort: "date",
+        DefaultDesc: true,
+        ID:          "a.id",
+        Filters: map[string]string{
+            "status":       "a.status",
+            "service_type": "s.type",
+        },
+        DateColumn: "a.appointment_date",
+    }
+
+    adminCustomerList = listSpec{
+        Sorts: map[string]sortField{
+            "created_at": {"u.created_at", "timestamp"},
+            "name":       {"u.name", "text"},
+            "wash_count": {"u.wash_count", "integer"},
+        },
+        DefaultSort: "created_at",
+        DefaultDesc: true,
+        ID:          "u.id",
+        DateColumn:  "u.created_at",
+    }
+
+    adminUserList = listSpec{
+        Sorts: map[string]sortField{
+            "created_at": {"au.created_at", "timestamp"},
+            "name":       {"u.name", "text"},
+            "last_login": {"COALESCE(u.last_login, 'epoch')", "timestamp"},
+        },
+        DefaultSort: "created_at",
+        DefaultDesc: true,
+        ID:          "au.id",
+        Filters: map[string]string{
+            "role":   "au.role",
+            "status": "u.status",
+        },
+    }
+
+    userPhotoList = listSpec{
+        Sorts: map[string]sortField{
+            "created_at": {"pp.created_at", "timestamp"},
+        },
+        DefaultSort: "created_at",
+        DefaultDesc: true,
+        ID:          "pp.id",
+        DateColumn:  "pp.created_at",
+    }
+)
@@ -0,0 +1,272 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "math"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    throttleAccount = "account"
+    throttleIP      = "ip"
+)
+
+type loginThrottlePolicy struct {
+    AccountMaxAttempts int
+    IPMaxAttempts      int
+    Lockout            time.Duration
+    BackoffBase        time.Duration
+}
+
+func loadLoginThrottlePolicy(db *sql.DB) loginThrottlePolicy {
+    return loginThrottlePolicy{
+        AccountMaxAttempts: getSettingInt(db, "security", "login_max_attempts", 5),
+        IPMaxAttempts:      getSettingInt(db, "security", "login_ip_max_attempts", 20),
+        Lockout:            time.Duration(getSettingInt(db, "security", "login_lockout_minutes", 15)) * time.Minute,
+        BackoffBase:        time.Duration(getSettingInt(db, "security", "login_backoff_seconds", 1)) * time.Second,
+    }
+}
+
+// backoff is the wait before the next try after n consecutive failures:
+// nothing for the first, then base, 2*base, 4*base... capped at the lockout.
+func (p loginThrottlePolicy) backoff(failures int) time.Duration {
+    if failures < 2 || p.BackoffBase <= 0 {
+        return 0
+    }
+    wait := time.Duration(float64(p.BackoffBase) * math.Pow(2, float64(failures-2)))
+    if wait > p.Lockout {
+        return p.Lockout
+    }
+    return wait
+}
+
+type throttleState struct {
+    Failures      int
+    LastFailureAt *time.Time
+    LockedUntil   *time.Time
+}
+
+func loadThrottle(db *sql.DB, scope string, key string) throttleState {
+    var state throttleState
+    db.QueryRow(`
+        SELECT failures, last_failure_at, locked_until FROM login_throttles
+        WHERE scope = $1 AND throttle_key = $2
+    `, scope, key).Scan(&state.Failures, &state.LastFailureAt, &state.LockedUntil)
+    return state
+}
+
+// retryAfter is how long the caller must wait before another attempt.
+func (p loginThrottlePolicy) retryAfter(state throttleState, now time.Time) time.Duration {
+    if state.LockedUntil != nil && state.LockedUntil.After(now) {
+        return state.LockedUntil.Sub(now)
+    }
+    if state.LastFailureAt != nil {
+        if next := state.LastFailureAt.Add(p.backoff(state.Failures)); next.After(now) {
+            return next.Sub(now)
+        }
+    }
+    return 0
+}
+
+func accountThrottleKey(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginThrottle writes a 429 and returns false if the account or the
+// client IP has to wait before trying again.
+func checkLoginThrottle(c *gin.Context, db *sql.DB, email string) bool {
+    policy := loadLoginThrottlePolicy(db)
+    now := time.Now()
+
+    wait := policy.retryAfter(loadThrottle(db, throttleAccount, accountThrottleKey(email)), now)
+    if ipWait := policy.retryAfter(loadThrottle(db, throttleIP, c.ClientIP()), now); ipWait > wait {
+        wait = ipWait
+    }
+    if wait <= 0 {
+        return true
+    }
+
+    seconds := int(math.Ceil(wait.Seconds()))
+    c.Header("Retry-After", fmt.Sprintf("%d", seconds))
+    message := fmt.Sprintf("Too many failed attempts. Try again in %d seconds", seconds)
+    if seconds >= 120 {
+        message = fmt.Sprintf("Too many failed attempts. Try again in %d minutes", (seconds+59)/60)
+    }
+    c.JSON(429, gin.H{"error": message, "retry_after": seconds})
+    return false
+}
+
+// bumpThrottle counts a failure and locks the key once it reaches max.
+// Failures older than the lockout window don't count toward the next lock.
+func bumpThrottle(db *sql.DB, scope string, key string, max int, lockout time.Duration) (throttleState, bool) {
+    var state throttleState
+    var justLocked bool
+    err := db.QueryRow(`
+        INSERT INTO login_throttles (scope, throttle_key, failures, last_failure_at)
+        VALUES ($1, $2, 1, CURRENT_TIMESTAMP)
+        ON CONFLICT (scope, throttle_key) DO UPDATE SET
+            failures = CASE
+                WHEN login_throttles.last_failure_at < CURRENT_TIMESTAMP - make_interval(secs => $3) THEN 1
+                ELSE login_throttles.failures + 1
+            END,
+            last_failure_at = CURRENT_TIMESTAMP
+        RETURNING failures, last_failure_at, locked_until
+    `, scope, key, lockout.Seconds()).Scan(&state.Failures, &state.LastFailureAt, &state.LockedUntil)
+    if err != nil {
+        log.Printf("Failed to record login failure: %v", err)
+        return state, false
+    }
+
+    if max > 0 && state.Failures >= max && (state.LockedUntil == nil || state.LockedUntil.Before(time.Now())) {
+        err := db.QueryRow(`
+            UPDATE login_throttles SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $3), failures = 0
+            WHERE scope = $1 AND throttle_key = $2
+            RETURNING locked_until
+        `, scope, key, lockout.Seconds()).Scan(&state.LockedUntil)
+        if err == nil {
+            justLocked = true
+        }
+    }
+    return state, justLocked
+}
+
+// recordLoginFailure counts a bad password or code against the account and
+// the client IP, auditing any lockout it triggers. It returns how many tries
+// the account has left before locking.
+func recordLoginFailure(c *gin.Context, db *sql.DB, email string, userID *int) int {
+    policy := loadLoginThrottlePolicy(db)
+
+    account, locked := bumpThrottle(db, throttleAccount, accountThrottleKey(email), policy.AccountMaxAttempts, policy.Lockout)
+    if locked {
+        writeAuditLog(db, c, userID, "account_locked", "users", userID, map[string]interface{}{
+            "email":        accountThrottleKey(email),
+            "locked_until": account.LockedUntil,
+        })
+        log.Printf("Locked login for %s until %v", accountThrottleKey(email), account.LockedUntil)
+    }
+
+    ip, ipLocked := bumpThrottle(db, throttleIP, c.ClientIP(), policy.IPMaxAttempts, policy.Lockout)
+    if ipLocked {
+        writeAuditLog(db, c, nil, "ip_locked", "", nil, map[string]interface{}{
+            "ip":           c.ClientIP(),
+            "locked_until": ip.LockedUntil,
+        })
+        log.Printf("Locked login from %s until %v", c.ClientIP(), ip.LockedUntil)
+    }
+
+    if locked {
+        return 0
+    }
+    return policy.AccountMaxAttempts - account.Failures
+}
+
+// clearLoginFailures resets the account after a successful sign-in. The IP
+// counter is left to decay so one good login can't launder a spray attack.
+func clearLoginFailures(db *sql.DB, email string) {
+    db.Exec("DELETE FROM login_throttles WHERE scope = $1 AND throttle_key = $2", throttleAccount, accountThrottleKey(email))
+}
+
+// configureTrustedProxies decides whose X-Forwarded-For gin believes when it
+// works out c.ClientIP(), which throttling is keyed on. proxies is a
+// comma-separated list of IPs or CIDRs for the load balancers in front of
+// us; empty trusts none, so ClientIP is always the socket address and a
+// client can't dodge the per-IP limit by sending its own header.
+func configureTrustedProxies(r *gin.Engine, proxies string) error {
+    var trusted []string
+    for _, proxy := range strings.Split(proxies, ",") {
+        if proxy = strings.TrimSpace(proxy); proxy != "" {
+            trusted = append(trusted, proxy)
+        }
+    }
+    return r.SetTrustedProxies(trusted)
+}
+
+func registerLoginThrottleRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    // Accounts and IPs currently locked out or backing off
+    admin.GET("/security/lockouts", func(c *gin.Context) {
+        if !requirePermission(c, "user_management") {
+            return
+        }
+        rows, err := db.Query(`
+            SELECT t.scope, t.throttle_key, t.failures, t.last_failure_at, t.locked_until, u.id, u.name
+            FROM login_throttles t
+            LEFT JOIN users u ON t.scope = 'account' AND LOWER(u.email) = t.throttle_key
+            WHERE t.locked_until > CURRENT_TIMESTAMP OR t.failures > 0
+            ORDER BY t.locked_until DESC NULLS LAST, t.last_failure_at DESC
+        `)
+        if err != nil {
+            log.Printf("Failed to fetch lockouts: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch lockouts"})
+            return
+        }
+        defer rows.Close()
+
+        lockouts := []map[string]interface{}{}
+        for rows.Next() {
+            var scope, key string
+            var failures int
+            var lastFailure, lockedUntil *time.Time
+            var userID *int
+            var name *string
+            if err := rows.Scan(&scope, &key, &failures, &lastFailure, &lockedUntil, &userID, &name); err != nil {
+                continue
+            }
+            lockouts = append(lockouts, map[string]interface{}{
+                "scope":           scope,
+                "key":             key,
+                "failures":        failures,
+                "last_failure_at": lastFailure,
+                "locked_until":    lockedUntil,
+                "locked":          lockedUntil != nil && lockedUntil.After(time.Now()),
+                "user_id":         userID,
+                "user_name":       name,
+            })
+        }
+
+        c.JSON(200, gin.H{"lockouts": lockouts})
+    })
+
+    admin.POST("/users/:id/unlock", func(c *gin.Context) {
+        if !requirePermission(c, "user_management") {
+            return
+        }
+        var userID int
+        var email string
+        err := db.QueryRow("SELECT id, email FROM users WHERE id = $1", c.Param("id")).Scan(&userID, &email)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "User not found"})
+            return
+        }
+
+        result, err := db.Exec("DELETE FROM login_throttles WHERE scope = $1 AND throttle_key = $2", throttleAccount, accountThrottleKey(email))
+        if err != nil {
+            log.Printf("Failed to unlock account: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to unlock account"})
+            return
+        }
+        cleared, _ := result.RowsAffected()
+
+        writeAuditLog(db, c, actorID(c), "account_unlocked", "users", &userID, map[string]interface{}{"email": accountThrottleKey(email)})
+
+        c.JSON(200, gin.H{"message": "Account unlocked", "was_locked": cleared > 0})
+    })
+
+    admin.DELETE("/security/lockouts/ip/:ip", func(c *gin.Context) {
+        if !requirePermission(c, "user_management") {
+            return
+        }
+        _, err := db.Exec("DELETE FROM login_throttles WHERE scope = $1 AND throttle_key = $2", throttleIP, c.Param("ip"))
+        if err != nil {
+            log.Printf("Failed to clear IP lockout: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to clear IP lockout"})
+            return
+        }
+        writeAuditLog(db, c, actorID(c), "ip_unlocked", "", nil, map[string]interface{}{"ip": c.Param("ip")})
+        c.JSON(200, gin.H{"message": "IP lockout cleared"})
+    })
+}
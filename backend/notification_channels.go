@@ -0,0 +1,152 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// Recipient is who a notification is going to, with the addresses each
+// channel needs.
+type Recipient struct {
+    UserID int
+    Name   string
+    Email  string
+    Phone  string
+}
+
+// OutboxMessage is a rendered notification waiting in the outbox.
+type OutboxMessage struct {
+    ID        int
+    UserID    int
+    EventType string
+    Channel   string
+    Subject   string
+    Body      string
+    Data      json.RawMessage
+    Attempts  int
+}
+
+// Channel delivers rendered notifications over one medium.
+type Channel interface {
+    Name() string
+    Send(to Recipient, msg OutboxMessage) error
+}
+
+// SMSProvider sends text messages and understands the provider's inbound
+// webhook format. Swap the logging fake for a real gateway once one is
+// contracted.
+type SMSProvider interface {
+    SendSMS(to string, body string) error
+    ParseInbound(r *http.Request) (InboundSMS, error)
+}
+
+// InboundSMS is a text a customer sent us.
+type InboundSMS struct {
+    From       string `json:"from"`
+    Body       string `json:"body"`
+    ProviderID string `json:"provider_id"`
+}
+
+type emailChannel struct{}
+
+func (emailChannel) Name() string { return "email" }
+
+func (emailChannel) Send(to Recipient, msg OutboxMessage) error {
+    if to.Email == "" {
+        return fmt.Errorf("user %d has no email address", to.UserID)
+    }
+    return sendEmail(to.Email, msg.Subject, msg.Body)
+}
+
+type smsChannel struct {
+    db       *sql.DB
+    provider SMSProvider
+}
+
+func (smsChannel) Name() string { return "sms" }
+
+func (ch smsChannel) Send(to Recipient, msg OutboxMessage) error {
+    if to.Phone == "" {
+        return fmt.Errorf("user %d has no phone number", to.UserID)
+    }
+    if err := ch.provider.SendSMS(to.Phone, msg.Body); err != nil {
+        return err
+    }
+
+    // Keep outbound texts in the conversation log next to the replies
+    var data struct {
+        AppointmentID *int `json:"appointment_id"`
+    }
+    json.Unmarshal(msg.Data, &data)
+    userID := to.UserID
+    logSMSMessage(ch.db, "outbound", to.Phone, &userID, data.AppointmentID, msg.Body, msg.EventType)
+    return nil
+}
+
+// logSMSProvider writes texts to the server log instead of sending them.
+type logSMSProvider struct{}
+
+func (logSMSProvider) SendSMS(to string, body string) error {
+    log.Printf("[sms] to %s: %s", to, body)
+    return nil
+}
+
+// ParseInbound accepts JSON {"from", "body"} or Twilio-style From/Body form
+// fields, so the webhook can be exercised with curl.
+func (logSMSProvider) ParseInbound(r *http.Request) (InboundSMS, error) {
+    var msg InboundSMS
+    if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+        if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+            return msg, err
+        }
+    } else {
+        if err := r.ParseForm(); err != nil {
+            return msg, err
+        }
+        msg.From = r.PostForm.Get("From")
+        msg.Body = r.PostForm.Get("Body")
+        msg.ProviderID = r.PostForm.Get("MessageSid")
+    }
+    if msg.From == "" {
+        return msg, fmt.Errorf("missing sender")
+    }
+    log.Printf("[sms] from %s: %s", msg.From, msg.Body)
+    return msg, nil
+}
+
+func newSMSProvider() SMSProvider {
+    switch provider := os.Getenv("SMS_PROVIDER"); provider {
+    case "", "log":
+        return logSMSProvider{}
+    default:
+        log.Printf("Unknown SMS_PROVIDER %q, logging texts instead", provider)
+        return logSMSProvider{}
+    }
+}
+
+// inAppChannel stores the notification in the user's inbox.
+type inAppChannel struct {
+    db *sql.DB
+}
+
+func (inAppChannel) Name() string { return "in_app" }
+
+func (ch inAppChannel) Send(to Recipient, msg OutboxMessage) error {
+    n := InboxNotification{UserID: to.UserID, EventType: msg.EventType, Title: msg.Subject, Body: msg.Body, Data: msg.Data}
+    err := ch.db.QueryRow(`
+        INSERT INTO notifications (user_id, event_type, title, body, data, created_at)
+        VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+        RETURNING id, created_at
+    `, to.UserID, msg.EventType, msg.Subject, msg.Body, []byte(msg.Data)).Scan(&n.ID, &n.CreatedAt)
+    if err != nil {
+        return err
+    }
+
+    pushInboxUpdate(ch.db, to.UserID, &n)
+    return nil
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+    "bytes"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "text/template"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// notificationTemplate is the copy for one event type. Body is the long form
+// used for email; Short is used for SMS and the in-app inbox. Preference names
+// the notification_preferences column that lets a user opt out.
+type notificationTemplate struct {
+    Preference string
+    Channels   []string // limits delivery to these channels when set
+    Subject    string
+    Body       string
+    Short      string
+}
+
+var notificationTemplates = map[string]notificationTemplate{
+    "appointment_created": {
+        Preference: "status_updates",
+        Subject:    "Booking received: {{.pet_name}}'s {{.service_name}}",
+        Body: "Hi {{.name}},\n\nThanks for booking with {{.business_name}}! {{.pet_name}} is booked for " +
+            "{{.service_name}} on {{.when}}.\n\nSee you soon!\n{{.business_name}}\n{{.business_phone}}\n",
+        Short: "{{.business_name}}: {{.pet_name}} is booked for {{.service_name}} on {{.when}}.",
+    },
+    "appointment_confirmed": {
+        Preference: "status_updates",
+        Subject:    "Confirmed: {{.pet_name}}'s {{.service_name}}",
+        Body: "Hi {{.name}},\n\nYour appointment for {{.pet_name}} ({{.service_name}}) on {{.when}} is confirmed.\n\n" +
+            "See you soon!\n{{.business_name}}\n{{.business_phone}}\n",
+        Short: "{{.business_name}}: {{.pet_name}}'s {{.service_name}} on {{.when}} is confirmed.",
+    },
+    "appointment_cancelled": {
+        Preference: "status_updates",
+        Subject:    "Cancelled: {{.pet_name}}'s {{.service_name}}",
+        Body: "Hi {{.name}},\n\nYour appointment for {{.pet_name}} ({{.service_name}}) on {{.when}} has been cancelled.\n\n" +
+            "If this wasn't expected, give us a call at {{.business_phone}}.\n\n{{.business_name}}\n",
+        Short: "{{.business_name}}: {{.pet_name}}'s {{.service_name}} on {{.when}} was cancelled.",
+    },
+    "appointment_completed": {
+        Preference: "status_updates",
+        Subject:    "{{.pet_name}} is all done!",
+        Body: "Hi {{.name}},\n\n{{.pet_name}}'s {{.service_name}} is complete and ready for pickup.\n\n" +
+            "Thanks for choosing {{.business_name}}!\n{{.business_phone}}\n",
+        Short: "{{.business_name}}: {{.pet_name}} is all done and ready for pickup!",
+    },
+    "appointment_rescheduled": {
+        Preference: "status_updates",
+        Subject:    "Rescheduled: {{.pet_name}}'s {{.service_name}}",
+        Body: "Hi {{.name}},\n\n{{.pet_name}}'s {{.service_name}} has moved to {{.when}}.\n\n" +
+            "See you then!\n{{.business_name}}\n{{.business_phone}}\n",
+        Short: "{{.business_name}}: {{.pet_name}}'s {{.service_name}} is now {{.when}}.",
+    },
+    "appointment_reminder": {
+        Preference: "appointment_reminders",
+        Subject:    "Reminder: {{.pet_name}}'s {{.service_name}} on {{.day}}",
+        Body: "Hi {{.name}},\n\nThis is a reminder that {{.pet_name}} is booked for {{.service_name}} at " +
+            "{{.business_name}} on {{.when}}.\n\nNeed to change your plans? You can reschedule or cancel from your account.\n\n" +
+            "See you soon!\n{{.business_name}}\n{{.business_phone}}\n",
+        Short: "Reminder from {{.business_name}}: {{.pet_name}}'s {{.service_name}} is {{.when}}.",
+    },
+    "appointment_confirmation_request": {
+        Preference: "appointment_reminders",
+        Channels:   []string{"sms"},
+        Subject:    "Please confirm {{.pet_name}}'s appointment",
+        Short:      "{{.business_name}}: {{.pet_name}}'s {{.service_name}} is {{.when}}. Reply C to confirm, R to reschedule.",
+    },
+    "appointment_needs_followup": {
+        Preference: "status_updates",
+        Subject:    "About your appointment on {{.day}}",
+        Body: "Hi {{.name}},\n\nUnfortunately our hours have changed and {{.pet_name}}'s {{.service_name}} on {{.when}} " +
+            "is affected ({{.reason}}). We'll be in touch to find a new time, or call us at {{.business_phone}}.\n\n" +
+            "Sorry for the trouble!\n{{.business_name}}\n",
+        Short: "{{.business_name}}: our hours changed and {{.pet_name}}'s appointment on {{.when}} is affected. We'll be in touch.",
+    },
+    "waitlist_offer": {
+        Subject: "A spot opened up for {{.pet_name}}",
+        Body: "Hi {{.name}},\n\nGood news! A {{.service_name}} slot opened up on {{.when}}. We're holding it for you " +
+            "for {{.hold_minutes}} minutes. Accept it from your account before it goes to the next person on the list.\n\n" +
+            "{{.business_name}}\n",
+        Short: "{{.business_name}}: a {{.service_name}} slot opened on {{.when}}. It's held for {{.hold_minutes}} min, accept it in the app.",
+    },
+}
+
+// Notifier renders notifications into the outbox and a background worker
+// delivers them, retrying failures with backoff.
+type Notifier struct {
+    db       *sql.DB
+    channels map[string]Channel
+    sms      SMSProvider
+    wake     chan struct{}
+}
+
+var notifier *Notifier
+
+func newNotifier(db *sql.DB) *Notifier {
+    n := &Notifier{db: db, channels: map[string]Channel{}, sms: newSMSProvider(), wake: make(chan struct{}, 1)}
+    for _, ch := range []Channel{emailChannel{}, smsChannel{db: db, provider: n.sms}, inAppChannel{db: db}, pushChannel{db: db}} {
+        n.channels[ch.Name()] = ch
+    }
+    return n
+}
+
+type recipientPreferences struct {
+    Recipient
+    EmailEnabled  bool
+    SMSEnabled    bool
+    PushEnabled   bool
+    Reminders     bool
+    StatusUpdates bool
+}
+
+func (n *Notifier) loadRecipient(userID int) (recipientPreferences, error) {
+    var r recipientPreferences
+    r.UserID = userID
+    err := n.db.QueryRow(`
+        SELECT u.name, u.email, COALESCE(u.phone, ''),
+               COALESCE(np.email_notifications, TRUE), COALESCE(np.sms_notifications, FALSE), COALESCE(np.push_notifications, TRUE),
+               COALESCE(np.appointment_reminders, TRUE), COALESCE(np.status_updates, TRUE)
+        FROM users u
+        LEFT JOIN notification_preferences np ON np.user_id = u.id
+        WHERE u.id = $1
+    `, userID).Scan(&r.Name, &r.Email, &r.Phone, &r.EmailEnabled, &r.SMSEnabled, &r.PushEnabled, &r.Reminders, &r.StatusUpdates)
+    return r, err
+}
+
+func renderTemplate(name string, text string, data map[string]interface{}) (string, error) {
+    tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+    if err != nil {
+        return "", err
+    }
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, data); err != nil {
+        return "", err
+    }
+    return buf.String(), nil
+}
+
+// Notify queues an event for a user on every channel they and the business
+// have enabled. Delivery happens asynchronously from the outbox.
+func (n *Notifier) Notify(userID int, event string, data map[string]interface{}) error {
+    tmpl, ok := notificationTemplates[event]
+    if !ok {
+        return fmt.Errorf("no notification template for %q", event)
+    }
+
+    r, err := n.loadRecipient(userID)
+    if err != nil {
+        return fmt.Errorf("load recipient %d: %w", userID, err)
+    }
+    if (tmpl.Preference == "appointment_reminders" && !r.Reminders) || (tmpl.Preference == "status_updates" && !r.StatusUpdates) {
+        return nil
+    }
+
+    if data == nil {
+        data = map[string]interface{}{}
+    }
+    data["name"] = r.Name
+    data["business_name"] = getSetting(n.db, "business", "business_name", "Jake's Bath House")
+    data["business_phone"] = getSetting(n.db, "business", "phone", "")
+
+    subject, err := renderTemplate(event+".subject", tmpl.Subject, data)
+    if err != nil {
+        return err
+    }
+    body, err := renderTemplate(event+".body", tmpl.Body, data)
+    if err != nil {
+        return err
+    }
+    short, err := renderTemplate(event+".short", tmpl.Short, data)
+    if err != nil {
+        return err
+    }
+    payload, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+
+    channels := map[string]string{"in_app": short}
+    if r.EmailEnabled && getSettingBool(n.db, "notifications", "email_notifications", true) {
+        channels["email"] = body
+    }
+    if r.SMSEnabled && r.Phone != "" && getSettingBool(n.db, "notifications", "sms_notifications", false) {
+        channels["sms"] = short
+    }
+    if _, configured := loadVAPIDKeys(n.db); configured && r.PushEnabled && hasPushSubscription(n.db, userID) {
+        channels["push"] = short
+    }
+    if tmpl.Channels != nil {
+        allowed := map[string]string{}
+        for _, name := range tmpl.Channels {
+            if text, ok := channels[name]; ok {
+                allowed[name] = text
+            }
+        }
+        channels = allowed
+    }
+
+    // All channels or none, so a failed insert can't leave the customer with
+    // the email but not the inbox copy
+    tx, err := n.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    for channel, text := range channels {
+        _, err := tx.Exec(`
+            INSERT INTO notification_outbox (user_id, event_type, channel, subject, body, data, status, next_attempt_at, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+        `, userID, event, channel, subject, text, payload)
+        if err != nil {
+            return fmt.Errorf("queue %s notification: %w", channel, err)
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+
+    // Deliver now rather than on the next tick so the inbox updates live
+    select {
+    case n.wake <- struct{}{}:
+    default:
+    }
+    return nil
+}
+
+// NotifyAppointment queues an event about an appointment for its owner.
+func (n *Notifier) NotifyAppointment(appointmentID int, event string, extra map[string]interface{}) {
+    var userID int
+    var petName, serviceName, date, clock, reason string
+    err := n.db.QueryRow(`
+        SELECT a.user_id, p.name, s.name, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI'),
+               COALESCE(a.followup_reason, '')
+        FROM appointments a
+        JOIN pets p ON a.pet_id = p.id
+        JOIN services s ON a.service_id = s.id
+        WHERE a.id = $1
+    `, appointmentID).Scan(&userID, &petName, &serviceName, &date, &clock, &reason)
+    if err != nil {
+        log.Printf("Failed to load appointment %d for notification: %v", appointmentID, err)
+        return
+    }
+
+    data := map[string]interface{}{
+        "appointment_id": appointmentID,
+        "pet_name":       petName,
+        "service_name":   serviceName,
+        "date":           date,
+        "time":           clock,
+        "reason":         reason,
+    }
+    if startsAt, err := appointmentStart(businessLocation(n.db), date, clock); err == nil {
+        data["when"] = startsAt.Format("Monday, January 2 at 3:04 PM")
+        data["day"] = startsAt.Format("Jan 2")
+        data["starts_at"] = startsAt
+    }
+    for k, v := range extra {
+        data[k] = v
+    }
+
+    if err := n.Notify(userID, event, data); err != nil {
+        log.Printf("Failed to queue %s notification for appointment %d: %v", event, appointmentID, err)
+    }
+}
+
+// notifyStatusChange maps an appointment status to the event customers hear about.
+func (n *Notifier) notifyStatusChange(appointmentID int, status string) {
+    switch status {
+    case "confirmed", "cancelled", "completed":
+        n.NotifyAppointment(appointmentID, "appointment_"+status, nil)
+    }
+}
+
+// claimOutbox takes a batch of due messages. SKIP LOCKED keeps replicas from
+// grabbing the same rows, and a message stuck in "sending" (the process died
+// mid-send) is picked up again after ten minutes.
+func (n *Notifier) claimOutbox(limit int) ([]OutboxMessage, error) {
+    rows, err := n.db.Query(`
+        UPDATE notification_outbox
+        SET status = 'sending', attempts = attempts + 1, claimed_at = CURRENT_TIMESTAMP
+        WHERE id IN (
+            SELECT id FROM notification_outbox
+            WHERE (status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP)
+               OR (status = 'sending' AND claimed_at < CURRENT_TIMESTAMP - INTERVAL '10 minutes')
+            ORDER BY id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, user_id, event_type, channel, subject, body, COALESCE(data, '{}'), attempts
+    `, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var messages []OutboxMessage
+    for rows.Next() {
+        var msg OutboxMessage
+        var data []byte
+        if err := rows.Scan(&msg.ID, &msg.UserID, &msg.EventType, &msg.Channel, &msg.Subject, &msg.Body, &data, &msg.Attempts); err != nil {
+            continue
+        }
+        msg.Data = data
+        messages = append(messages, msg)
+    }
+    return messages, nil
+}
+
+func (n *Notifier) deliver(msg OutboxMessage) {
+    var sendErr error
+    var to recipientPreferences
+    ch, ok := n.channels[msg.Channel]
+    if !ok {
+        sendErr = fmt.Errorf("unknown channel %q", msg.Channel)
+    } else if to, sendErr = n.loadRecipient(msg.UserID); sendErr == nil {
+        sendErr = ch.Send(to.Recipient, msg)
+    }
+
+    status, errText := "sent", ""
+    if sendErr != nil {
+        status, errText = "failed", sendErr.Error()
+    }
+    n.db.Exec(`
+        INSERT INTO notification_deliveries (outbox_id, channel, recipient, status, error, attempted_at)
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''), CURRENT_TIMESTAMP)
+    `, msg.ID, msg.Channel, deliveryAddress(msg.Channel, to.Recipient), status, errText)
+
+    if sendErr == nil {
+        n.db.Exec("UPDATE notification_outbox SET status = 'sent', last_error = NULL, sent_at = CURRENT_TIMESTAMP WHERE id = $1", msg.ID)
+        return
+    }
+
+    maxAttempts := getSettingInt(n.db, "notifications", "max_attempts", 5)
+    if msg.Attempts >= maxAttempts {
+        log.Printf("Giving up on %s notification %d after %d attempts: %v", msg.Channel, msg.ID, msg.Attempts, sendErr)
+        n.db.Exec("UPDATE notification_outbox SET status = 'failed', last_error = $2 WHERE id = $1", msg.ID, errText)
+        return
+    }
+
+    // Back off 1, 2, 4, 8... minutes between attempts
+    backoff := 1 << uint(msg.Attempts-1)
+    log.Printf("Failed to send %s notification %d (attempt %d), retrying in %d min: %v", msg.Channel, msg.ID, msg.Attempts, backoff, sendErr)
+    n.db.Exec(`
+        UPDATE notification_outbox
+        SET status = 'pending', last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + make_interval(mins => $3)
+        WHERE id = $1
+    `, msg.ID, errText, backoff)
+}
+
+func deliveryAddress(channel string, to Recipient) string {
+    switch channel {
+    case "email":
+        return to.Email
+    case "sms":
+        return to.Phone
+    case "push":
+        return "user " + strconv.Itoa(to.UserID) + " devices"
+    default:
+        return strconv.Itoa(to.UserID)
+    }
+}
+
+func (n *Notifier) processOutbox() {
+    messages, err := n.claimOutbox(50)
+    if err != nil {
+        log.Printf("Failed to claim notification outbox: %v", err)
+        return
+    }
+    for _, msg := range messages {
+        n.deliver(msg)
+    }
+}
+
+func (n *Notifier) run() {
+    ticker := time.NewTicker(15 * time.Second)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-n.wake:
+        }
+        n.processOutbox()
+    }
+}
+
+func registerNotificationAdminRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    // Outbox view for support: what's queued, sent or stuck. Recipients are
+    // customer contact details, so this is for customer-facing staff only.
+    admin.GET("/notifications/outbox", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        query := `
+            SELECT id, user_id, event_type, channel, subject, status, attempts, COALESCE(last_error, ''),
+                   next_attempt_at, sent_at, created_at
+            FROM notification_outbox`
+        args := []interface{}{}
+        if status := c.Query("status"); status != "" {
+            query += " WHERE status = $1"
+            args = append(args, status)
+        }
+        query += " ORDER BY created_at DESC LIMIT 200"
+
+        rows, err := db.Query(query, args...)
+        if err != nil {
+            log.Printf("Failed to fetch notification outbox: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch notification outbox"})
+            return
+        }
+        defer rows.Close()
+
+        messages := []map[string]interface{}{}
+        for rows.Next() {
+            var id, userID, attempts int
+            var event, channel, subject, status, lastError string
+            var nextAttempt, createdAt time.Time
+            var sentAt *time.Time
+            err := rows.Scan(&id, &userID, &event, &channel, &subject, &status, &attempts, &lastError, &nextAttempt, &sentAt, &createdAt)
+            if err != nil {
+                continue
+            }
+            messages = append(messages, map[string]interface{}{
+                "id":              id,
+                "user_id":         userID,
+                "event_type":      event,
+                "channel":         channel,
+                "subject":         subject,
+                "status":          status,
+                "attempts":        attempts,
+                "last_error":      lastError,
+                "next_attempt_at": nextAttempt,
+                "sent_at":         sentAt,
+                "created_at":      createdAt,
+            })
+        }
+
+        c.JSON(200, gin.H{"messages": messages})
+    })
+
+    admin.POST("/notifications/outbox/:id/retry", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        result, err := db.Exec(`
+            UPDATE notification_outbox
+            SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND status = 'failed'
+        `, c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to retry notification: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to retry notification"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(404, gin.H{"error": "No failed notification with that ID"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Notification queued for retry"})
+    })
+
+    // Delivery log, one row per attempt
+    admin.GET("/notifications/deliveries", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        query := `
+            SELECT d.id, d.outbox_id, d.channel, COALESCE(d.recipient, ''), d.status, COALESCE(d.error, ''), d.attempted_at,
+                   o.event_type, o.user_id
+            FROM notification_deliveries d
+            JOIN notification_outbox o ON d.outbox_id = o.id`
+        args := []interface{}{}
+        if outboxID := c.Query("outbox_id"); outboxID != "" {
+            query += " WHERE d.outbox_id = $1"
+            args = append(args, outboxID)
+        }
+        query += " ORDER BY d.attempted_at DESC LIMIT 200"
+
+        rows, err := db.Query(query, args...)
+        if err != nil {
+            log.Printf("Failed to fetch notification deliveries: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch delivery log"})
+            return
+        }
+        defer rows.Close()
+
+        deliveries := []map[string]interface{}{}
+        for rows.Next() {
+            var id, outboxID, userID int
+            var channel, recipient, status, deliveryErr, event string
+            var attemptedAt time.Time
+            if err := rows.Scan(&id, &outboxID, &channel, &recipient, &status, &deliveryErr, &attemptedAt, &event, &userID); err != nil {
+                continue
+            }
+            deliveries = append(deliveries, map[string]interface{}{
+                "id":           id,
+                "outbox_id":    outboxID,
+                "channel":      channel,
+                "recipient":    recipient,
+                "status":       status,
+                "error":        deliveryErr,
+                "attempted_at": attemptedAt,
+                "event_type":   event,
+                "user_id":      userID,
+            })
+        }
+
+        c.JSON(200, gin.H{"deliveries": deliveries})
+    })
+}
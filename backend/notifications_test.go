@@ -0,0 +1,115 @@
+package main
+
+import (
+    "database/sql/driver"
+    "errors"
+    "testing"
+)
+
+// stubChannel records what it was asked to send and fails with err.
+type stubChannel struct {
+    name string
+    err  error
+    sent []OutboxMessage
+}
+
+func (ch *stubChannel) Name() string { return ch.name }
+
+func (ch *stubChannel) Send(to Recipient, msg OutboxMessage) error {
+    ch.sent = append(ch.sent, msg)
+    return ch.err
+}
+
+func scriptRecipient(f *fakeDB) {
+    f.on("LEFT JOIN notification_preferences np ON np.user_id = u.id", []string{"name", "email", "phone", "email_on", "sms_on", "push_on", "reminders", "status"}, func(args []driver.Value) [][]driver.Value {
+        return row("Sam", "sam@example.com", "561-555-0142", true, true, false, true, true)
+    })
+}
+
+func TestNotifyQueuesEveryChannelTogether(t *testing.T) {
+    db, f := newFakeDB(t)
+    scriptSettings(f, map[string]string{"notifications.sms_notifications": "true"})
+    scriptRecipient(f)
+    f.onExec("INSERT INTO notification_outbox", func(args []driver.Value) (int64, error) { return 1, nil })
+
+    n := newNotifier(db)
+    if err := n.Notify(ownerID, "appointment_confirmed", map[string]interface{}{"pet_name": "Bella"}); err != nil {
+        t.Fatalf("Notify: %v", err)
+    }
+    channels := map[interface{}]bool{}
+    for _, call := range f.ran("INSERT INTO notification_outbox") {
+        channels[call.args[2]] = true
+    }
+    if len(channels) != 3 || !channels["email"] || !channels["sms"] || !channels["in_app"] {
+        t.Errorf("queued channels %v, want email, sms and in_app", channels)
+    }
+    if len(f.ran("BEGIN")) != 1 || f.commits != 1 {
+        t.Errorf("outbox rows not queued in one transaction")
+    }
+}
+
+func TestNotifyQueuesNothingIfAChannelFails(t *testing.T) {
+    db, f := newFakeDB(t)
+    scriptSettings(f, map[string]string{"notifications.sms_notifications": "true"})
+    scriptRecipient(f)
+    f.onExec("INSERT INTO notification_outbox", func(args []driver.Value) (int64, error) {
+        if args[2] == "sms" {
+            return 0, errors.New("connection reset")
+        }
+        return 1, nil
+    })
+
+    if err := newNotifier(db).Notify(ownerID, "appointment_confirmed", nil); err == nil {
+        t.Fatal("Notify succeeded though the SMS row failed")
+    }
+    if f.commits != 0 || f.rollbacks == 0 {
+        t.Errorf("got %d commits and %d rollbacks, want the other channels rolled back", f.commits, f.rollbacks)
+    }
+}
+
+func TestDeliverOutcomes(t *testing.T) {
+    for _, tc := range []struct {
+        name       string
+        channel    string
+        sendErr    error
+        attempts   int64
+        wantStatus string // the delivery row
+        wantUpdate string // the outbox update that ran
+    }{
+        {"sent", "email", nil, 1, "sent", "UPDATE notification_outbox SET status = 'sent'"},
+        {"retry with backoff", "email", errors.New("mail server down"), 3, "failed", "SET status = 'pending', last_error = $2, next_attempt_at"},
+        {"give up after max attempts", "email", errors.New("mail server down"), 5, "failed", "UPDATE notification_outbox SET status = 'failed'"},
+        {"unknown channel", "fax", nil, 5, "failed", "UPDATE notification_outbox SET status = 'failed'"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            db, f := newFakeDB(t)
+            scriptSettings(f, nil)
+            scriptRecipient(f)
+            f.onExec("INSERT INTO notification_deliveries", func(args []driver.Value) (int64, error) { return 1, nil })
+            f.onExec("UPDATE notification_outbox", func(args []driver.Value) (int64, error) { return 1, nil })
+            f.on("UPDATE notification_outbox SET status = 'sending'", []string{"id", "user_id", "event_type", "channel", "subject", "body", "data", "attempts"}, func(args []driver.Value) [][]driver.Value {
+                return row(int64(9), int64(ownerID), "appointment_confirmed", tc.channel, "Confirmed", "See you soon", []byte(`{}`), tc.attempts)
+            })
+
+            n := newNotifier(db)
+            stub := &stubChannel{name: "email", err: tc.sendErr}
+            n.channels["email"] = stub
+            n.processOutbox()
+
+            if tc.channel == "email" && len(stub.sent) != 1 {
+                t.Errorf("channel got %d messages, want 1", len(stub.sent))
+            }
+            deliveries := f.ran("INSERT INTO notification_deliveries")
+            if len(deliveries) != 1 || deliveries[0].args[3] != tc.wantStatus {
+                t.Errorf("delivery log %v, want one %s row", deliveries, tc.wantStatus)
+            }
+            updates := f.ran(tc.wantUpdate)
+            if len(updates) != 1 {
+                t.Fatalf("%q didn't run", tc.wantUpdate)
+            }
+            if tc.name == "retry with backoff" && updates[0].args[2] != int64(4) {
+                t.Errorf("backoff %v minutes after attempt 3, want 4", updates[0].args[2])
+            }
+        })
+    }
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+    "bufio"
+    "database/sql"
+    _ "embed"
+    "fmt"
+    "log"
+    "strings"
+    "unicode"
+
+    "github.com/gin-gonic/gin"
+    "golang.org/x/crypto/bcrypt"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = loadCommonPasswords(commonPasswordList)
+
+func loadCommonPasswords(list string) map[string]bool {
+    passwords := map[string]bool{}
+    scanner := bufio.NewScanner(strings.NewReader(list))
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        passwords[strings.ToLower(line)] = true
+    }
+    return passwords
+}
+
+type PasswordPolicy struct {
+    MinLength     int  `json:"min_length"`
+    RequireUpper  bool `json:"require_uppercase"`
+    RequireLower  bool `json:"require_lowercase"`
+    RequireDigit  bool `json:"require_digit"`
+    RequireSymbol bool `json:"require_symbol"`
+    RejectCommon  bool `json:"reject_common"`
+    HistoryCount  int  `json:"history_count"`
+}
+
+type PasswordViolation struct {
+    Code    string `json:"code"`
+    Message string `json:"message"`
+}
+
+func loadPasswordPolicy(db *sql.DB) PasswordPolicy {
+    return PasswordPolicy{
+        MinLength:     getSettingInt(db, "security", "password_min_length", 6),
+        RequireUpper:  getSettingBool(db, "security", "password_require_uppercase", false),
+        RequireLower:  getSettingBool(db, "security", "password_require_lowercase", false),
+        RequireDigit:  getSettingBool(db, "security", "password_require_digit", true),
+        RequireSymbol: getSettingBool(db, "security", "password_require_symbol", false),
+        RejectCommon:  getSettingBool(db, "security", "password_reject_common", true),
+        HistoryCount:  getSettingInt(db, "security", "password_history_count", 5),
+    }
+}
+
+// Check lists every rule the password breaks. The email is used to reject
+// passwords that are just the account name.
+func (p PasswordPolicy) Check(password string, email string) []PasswordViolation {
+    violations := []PasswordViolation{}
+
+    if len([]rune(password)) < p.MinLength {
+        violations = append(violations, PasswordViolation{"too_short", fmt.Sprintf("Password must be at least %d characters", p.MinLength)})
+    }
+    // bcrypt ignores everything past 72 bytes
+    if len(password) > 72 {
+        violations = append(violations, PasswordViolation{"too_long", "Password must be at most 72 bytes"})
+    }
+
+    var upper, lower, digit, symbol bool
+    for _, r := range password {
+        switch {
+        case unicode.IsUpper(r):
+            upper = true
+        case unicode.IsLower(r):
+            lower = true
+        case unicode.IsDigit(r):
+            digit = true
+        case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+            symbol = true
+        }
+    }
+    if p.RequireUpper && !upper {
+        violations = append(violations, PasswordViolation{"missing_uppercase", "Password must contain an uppercase letter"})
+    }
+    if p.RequireLower && !lower {
+        violations = append(violations, PasswordViolation{"missing_lowercase", "Password must contain a lowercase letter"})
+    }
+    if p.RequireDigit && !digit {
+        violations = append(violations, PasswordViolation{"missing_digit", "Password must contain a number"})
+    }
+    if p.RequireSymbol && !symbol {
+        violations = append(violations, PasswordViolation{"missing_symbol", "Password must contain a symbol"})
+    }
+
+    if p.RejectCommon {
+        lowered := strings.ToLower(password)
+        local := strings.ToLower(strings.SplitN(email, "@", 2)[0])
+        if commonPasswords[lowered] {
+            violations = append(violations, PasswordViolation{"too_common", "Password is too common"})
+        } else if local != "" && (lowered == local || lowered == strings.ToLower(email)) {
+            violations = append(violations, PasswordViolation{"matches_email", "Password can't be your email address"})
+        }
+    }
+
+    return violations
+}
+
+// passwordReused reports whether password is one of the user's last
+// HistoryCount passwords, counting the current one.
+func (p PasswordPolicy) passwordReused(db *sql.DB, userID int, password string) bool {
+    if p.HistoryCount <= 0 {
+        return false
+    }
+    rows, err := db.Query(`
+        SELECT password FROM users WHERE id = $1
+        UNION ALL
+        (SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2)
+    `, userID, p.HistoryCount-1)
+    if err != nil {
+        log.Printf("Failed to load password history: %v", err)
+        return false
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var hash sql.NullString
+        if err := rows.Scan(&hash); err != nil || !hash.Valid {
+            continue
+        }
+        if bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)) == nil {
+            return true
+        }
+    }
+    return false
+}
+
+// validatePassword checks password against the policy, and against the
+// user's history when userID is set. It writes a 400 listing every
+// violation and returns false when the password should be rejected.
+func validatePassword(c *gin.Context, db *sql.DB, userID *int, password string, email string) bool {
+    policy := loadPasswordPolicy(db)
+    violations := policy.Check(password, email)
+    if userID != nil && len(violations) == 0 && policy.passwordReused(db, *userID, password) {
+        violations = append(violations, PasswordViolation{"reused", fmt.Sprintf("Password can't match any of your last %d passwords", policy.HistoryCount)})
+    }
+    if len(violations) == 0 {
+        return true
+    }
+    c.JSON(400, gin.H{
+        "error":      "Password does not meet requirements",
+        "code":       "password_policy",
+        "violations": violations,
+    })
+    return false
+}
+
+// recordPasswordHistory keeps the hash a user is moving away from so it
+// can't be picked again, trimming anything beyond the policy's window.
+func recordPasswordHistory(db *sql.DB, userID int, oldHash string) {
+    if oldHash == "" {
+        return
+    }
+    // The current password is the newest of the last N, so N-1 old ones
+    keep := getSettingInt(db, "security", "password_history_count", 5) - 1
+    if keep < 0 {
+        keep = 0
+    }
+    _, err := db.Exec(`
+        INSERT INTO password_history (user_id, password_hash, created_at)
+        VALUES ($1, $2, CURRENT_TIMESTAMP)
+    `, userID, oldHash)
+    if err != nil {
+        log.Printf("Failed to record password history: %v", err)
+        return
+    }
+    db.Exec(`
+        DELETE FROM password_history
+        WHERE user_id = $1 AND id NOT IN (
+            SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2
+        )
+    `, userID, keep)
+}
+
+func registerPasswordPolicyRoutes(api *gin.RouterGroup, db *sql.DB) {
+    // Lets the signup and reset forms show the rules up front
+    api.GET("/password-policy", func(c *gin.Context) {
+        c.JSON(200, loadPasswordPolicy(db))
+    })
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "log"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Hard cap so an open-ended series can't flood the calendar
+const maxSeriesOccurrences = 52
+
+var errSlotTaken = errors.New("slot is no longer available")
+
+type AppointmentSeries struct {
+    ID              int       `json:"id" db:"id"`
+    UserID          int       `json:"user_id" db:"user_id"`
+    PetID           int       `json:"pet_id" db:"pet_id"`
+    ServiceID       int       `json:"service_id" db:"service_id"`
+    IntervalWeeks   int       `json:"interval_weeks" db:"interval_weeks"`
+    DayOfWeek       int       `json:"day_of_week" db:"day_of_week"`
+    AppointmentTime string    `json:"appointment_time" db:"appointment_time"`
+    StartDate       string    `json:"start_date" db:"start_date"`
+    EndDate         *string   `json:"end_date" db:"end_date"`
+    OccurrenceCount *int      `json:"occurrence_count" db:"occurrence_count"`
+    GroomerID       *int      `json:"groomer_id" db:"requested_groomer_id"`
+    Status          string    `json:"status" db:"status"`
+    Notes           string    `json:"notes" db:"notes"`
+    CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+type SeriesOccurrence struct {
+    AppointmentID int     `json:"appointment_id"`
+    Date          string  `json:"date"`
+    Time          string  `json:"time"`
+    Status        string  `json:"status"`
+    PaymentID     *int    `json:"payment_id"`
+    PaymentStatus *string `json:"payment_status"`
+}
+
+type SeriesSkip struct {
+    Date   string `json:"date"`
+    Reason string `json:"reason"`
+}
+
+// SeriesConflict is an occurrence a series reschedule couldn't move.
+type SeriesConflict struct {
+    AppointmentID int    `json:"appointment_id"`
+    Date          string `json:"date"`
+    NewDate       string `json:"new_date"`
+    Reason        string `json:"reason"`
+    Paid          bool   `json:"paid"`
+}
+
+type CreateSeriesRequest struct {
+    UserID          int    `json:"user_id" binding:"required"`
+    PetID           int    `json:"pet_id" binding:"required"`
+    ServiceID       int    `json:"service_id" binding:"required"`
+    IntervalWeeks   int    `json:"interval_weeks" binding:"required,min=1,max=52"`
+    DayOfWeek       *int   `json:"day_of_week" binding:"required,min=0,max=6"`
+    AppointmentTime string `json:"appointment_time" binding:"required"`
+    StartDate       string `json:"start_date" binding:"required"`
+    EndDate         string `json:"end_date"`
+    OccurrenceCount int    `json:"occurrence_count" binding:"omitempty,min=1,max=52"`
+    GroomerID       *int   `json:"groomer_id"`
+    Notes           string `json:"notes"`
+}
+
+type RescheduleSeriesRequest struct {
+    DayOfWeek       *int   `json:"day_of_week" binding:"omitempty,min=0,max=6"`
+    AppointmentTime string `json:"appointment_time" binding:"required"`
+}
+
+// seriesDates expands a recurrence rule into concrete dates. The first
+// occurrence is the first matching weekday on or after start.
+func seriesDates(start time.Time, dayOfWeek int, intervalWeeks int, end *time.Time, count int) []time.Time {
+    offset := (dayOfWeek - int(start.Weekday()) + 7) % 7
+    current := start.AddDate(0, 0, offset)
+
+    limit := maxSeriesOccurrences
+    if count > 0 && count < limit {
+        limit = count
+    }
+
+    var dates []time.Time
+    for len(dates) < limit {
+        if end != nil && current.After(*end) {
+            break
+        }
+        dates = append(dates, current)
+        current = current.AddDate(0, 0, 7*intervalWeeks)
+    }
+    return dates
+}
+
+// generateSeriesOccurrences books every free date from today on and records
+// the rest as skipped so staff can see why a week is missing. It runs inside
+// the caller's transaction; broadcast the created IDs after committing.
+func generateSeriesOccurrences(tx *sql.Tx, series AppointmentSeries, dates []time.Time, today string) ([]int, []SeriesSkip, error) {
+    var created []int
+    var skipped []SeriesSkip
+    for _, d := range dates {
+        date := d.Format(dateLayout)
+        if date < today {
+            continue
+        }
+
+        groomerID, reason, err := resolveSlot(tx, series.ServiceID, date, series.AppointmentTime, 0, series.UserID, series.GroomerID)
+        if err != nil {
+            return nil, nil, err
+        }
+        if reason != "" {
+            if err := recordSeriesSkip(tx, series.ID, date, reason); err != nil {
+                return nil, nil, err
+            }
+            skipped = append(skipped, SeriesSkip{Date: date, Reason: reason})
+            continue
+        }
+
+        var appointmentID int
+        err = tx.QueryRow(`
+            INSERT INTO appointments (user_id, pet_id, service_id, appointment_date, appointment_time, status, notes, series_id,
+                                      groomer_id, requested_groomer_id, created_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, 'confirmed', $6, $7, $8, $9, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, series.UserID, series.PetID, series.ServiceID, date, series.AppointmentTime, series.Notes, series.ID,
+            groomerID, series.GroomerID).Scan(&appointmentID)
+        if err != nil {
+            return nil, nil, err
+        }
+        created = append(created, appointmentID)
+    }
+    return created, skipped, nil
+}
+
+func recordSeriesSkip(db querier, seriesID int, date string, reason string) error {
+    _, err := db.Exec(`
+        INSERT INTO appointment_series_skips (series_id, occurrence_date, reason, created_at)
+        VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+        ON CONFLICT (series_id, occurrence_date) DO UPDATE SET reason = EXCLUDED.reason
+    `, seriesID, date, reason)
+    return err
+}
+
+const seriesColumns = `
+    SELECT id, user_id, pet_id, service_id, interval_weeks, day_of_week,
+           to_char(appointment_time, 'HH24:MI'), to_char(start_date, 'YYYY-MM-DD'),
+           to_char(end_date, 'YYYY-MM-DD'), occurrence_count, requested_groomer_id, status, COALESCE(notes, ''), created_at
+    FROM appointment_series`
+
+func scanSeries(row rowScanner) (AppointmentSeries, error) {
+    var s AppointmentSeries
+    err := row.Scan(&s.ID, &s.UserID, &s.PetID, &s.ServiceID, &s.IntervalWeeks, &s.DayOfWeek,
+        &s.AppointmentTime, &s.StartDate, &s.EndDate, &s.OccurrenceCount, &s.GroomerID, &s.Status, &s.Notes, &s.CreatedAt)
+    return s, err
+}
+
+func loadSeries(db *sql.DB, seriesID string) (AppointmentSeries, error) {
+    return scanSeries(db.QueryRow(seriesColumns+" WHERE id = $1", seriesID))
+}
+
+// futureSeriesAppointments returns the live occurrences from today onward.
+func futureSeriesAppointments(db *sql.DB, seriesID int) ([]SeriesOccurrence, error) {
+    rows, err := db.Query(`
+        SELECT id, to_char(appointment_date, 'YYYY-MM-DD'), to_char(appointment_time, 'HH24:MI'), status, payment_id
+        FROM appointments
+        WHERE series_id = $1 AND appointment_date >= $2 AND status IN ('pending', 'confirmed')
+        ORDER BY appointment_date
+    `, seriesID, businessToday(db))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var occurrences []SeriesOccurrence
+    for rows.Next() {
+        var o SeriesOccurrence
+        if err := rows.Scan(&o.AppointmentID, &o.Date, &o.Time, &o.Status, &o.PaymentID); err != nil {
+            return nil, err
+        }
+        occurrences = append(occurrences, o)
+    }
+    return occurrences, rows.Err()
+}
+
+func registerSeriesRoutes(api *gin.RouterGroup, db *sql.DB) {
+    api.POST("/series", func(c *gin.Context) {
+        var req CreateSeriesRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if !authorizeOwner(c, req.UserID, appointmentWritePermissions...) || !requirePetOwner(c, db, req.PetID, req.UserID) {
+            return
+        }
+        if !requireVerifiedEmail(c, db, req.UserID) {
+            return
+        }
+
+        start, err := time.Parse(dateLayout, req.StartDate)
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+            return
+        }
+        if _, err := parseClock(req.AppointmentTime); err != nil {
+            c.JSON(400, gin.H{"error": "Invalid appointment_time, expected HH:MM"})
+            return
+        }
+
+        var end *time.Time
+        var endDate *string
+        if req.EndDate != "" {
+            parsed, err := time.Parse(dateLayout, req.EndDate)
+            if err != nil || parsed.Before(start) {
+                c.JSON(400, gin.H{"error": "end_date must be a YYYY-MM-DD date on or after start_date"})
+                return
+            }
+            end = &parsed
+            endDate = &req.EndDate
+        }
+        if end == nil && req.OccurrenceCount == 0 {
+            c.JSON(400, gin.H{"error": "Either end_date or occurrence_count is required"})
+            return
+        }
+
+        var count *int
+        if req.OccurrenceCount > 0 {
+            count = &req.OccurrenceCount
+        }
+
+        series := AppointmentSeries{
+            UserID:          req.UserID,
+            PetID:           req.PetID,
+            ServiceID:       req.ServiceID,
+            IntervalWeeks:   req.IntervalWeeks,
+            DayOfWeek:       *req.DayOfWeek,
+            AppointmentTime: req.AppointmentTime,
+            StartDate:       req.StartDate,
+            EndDate:         endDate,
+            OccurrenceCount: count,
+            GroomerID:       req.GroomerID,
+            Status:          "active",
+            Notes:           req.Notes,
+        }
+
+        // The series and its appointments land together or not at all
+        tx, err := db.Begin()
+        if err != nil {
+            log.Printf("Failed to start transaction: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create series"})
+            return
+        }
+        defer tx.Rollback()
+
+        err = tx.QueryRow(`
+            INSERT INTO appointment_series (user_id, pet_id, service_id, interval_weeks, day_of_week, appointment_time,
+                                            start_date, end_date, occurrence_count, requested_groomer_id, status, notes, created_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'active', $11, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+            RETURNING id, created_at
+        `, series.UserID, series.PetID, series.ServiceID, series.IntervalWeeks, series.DayOfWeek, series.AppointmentTime,
+            series.StartDate, series.EndDate, series.OccurrenceCount, series.GroomerID, series.Notes).Scan(&series.ID, &series.CreatedAt)
+        if err != nil {
+            log.Printf("Failed to create series: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create series"})
+            return
+        }
+
+        dates := seriesDates(start, series.DayOfWeek, series.IntervalWeeks, end, req.OccurrenceCount)
+        created, skipped, err := generateSeriesOccurrences(tx, series, dates, businessToday(db))
+        if err != nil {
+            log.Printf("Failed to generate series occurrences: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create series"})
+            return
+        }
+        if err := tx.Commit(); err != nil {
+            log.Printf("Failed to commit series: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create series"})
+            return
+        }
+
+        for _, id := range created {
+            if apt, err := loadAppointment(db, id); err == nil {
+                broadcastAppointmentUpdate(apt, "created")
+            }
+        }
+
+        c.JSON(201, gin.H{
+            "message":         "Recurring series created successfully",
+            "series":          series,
+            "appointment_ids": created,
+            "skipped":         skipped,
+        })
+    })
+
+    api.GET("/series/:id", func(c *gin.Context) {
+        if !authorizeSeries(c, db, c.Param("id"), appointmentReadPermissions...) {
+            return
+        }
+        series, err := loadSeries(db, c.Param("id"))
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Series not found"})
+            return
+        }
+
+        rows, err := db.Query(`
+            SELECT a.id, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI'),
+                   a.status, a.payment_id, pay.status
+            FROM appointments a
+            LEFT JOIN payments pay ON a.payment_id = pay.id
+            WHERE a.series_id = $1
+            ORDER BY a.appointment_date
+        `, series.ID)
+        if err != nil {
+            log.Printf("Failed to fetch series occurrences: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch series"})
+            return
+        }
+        defer rows.Close()
+
+        var occurrences []SeriesOccurrence
+        for rows.Next() {
+            var o SeriesOccurrence
+            if err := rows.Scan(&o.AppointmentID, &o.Date, &o.Time, &o.Status, &o.PaymentID, &o.PaymentStatus); err != nil {
+                log.Printf("Error scanning series occurrence: %v", err)
+                continue
+            }
+            occurrences = append(occurrences, o)
+        }
+
+        var skips []SeriesSkip
+        skipRows, err := db.Query(`
+            SELECT to_char(occurrence_date, 'YYYY-MM-DD'), COALESCE(reason, '')
+            FROM appointment_series_skips WHERE series_id = $1 ORDER BY occurrence_date
+        `, series.ID)
+        if err == nil {
+            defer skipRows.Close()
+            for skipRows.Next() {
+                var s SeriesSkip
+                if err := skipRows.Scan(&s.Date, &s.Reason); err == nil {
+                    skips = append(skips, s)
+                }
+            }
+        }
+
+        c.JSON(200, gin.H{"series": series, "occurrences": occurrences, "skipped": skips})
+    })
+
+    api.GET("/users/:id/series", func(c *gin.Context) {
+        if !authorizeUserParam(c, appointmentReadPermissions...) {
+            return
+        }
+        rows, err := db.Query(seriesColumns+" WHERE user_id = $1 ORDER BY created_at DESC", c.Param("id"))
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to fetch series"})
+            return
+        }
+        defer rows.Close()
+
+        var list []AppointmentSeries
+        for rows.Next() {
+            s, err := scanSeries(rows)
+            if err != nil {
+                log.Printf("Error scanning series: %v", err)
+                continue
+            }
+            list = append(list, s)
+        }
+
+        c.JSON(200, gin.H{"series": list})
+    })
+
+    // Skip a single occurrence without touching the rest of the series
+    api.POST("/series/:id/skip", func(c *gin.Context) {
+        if !authorizeSeries(c, db, c.Param("id"), appointmentWritePermissions...) {
+            return
+        }
+        var req struct {
+            Date   string `json:"date" binding:"required"`
+            Reason string `json:"reason"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        series, err := loadSeries(db, c.Param("id"))
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Series not found"})
+            return
+        }
+        if series.Status != "active" {
+            c.JSON(400, gin.H{"error": "Series is not active"})
+            return
+        }
+
+        var appointmentID int
+        err = db.QueryRow(`
+            SELECT id FROM appointments
+            WHERE series_id = $1 AND appointment_date = $2 AND status IN ('pending', 'confirmed')
+        `, series.ID, req.Date).Scan(&appointmentID)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "No upcoming occurrence on that date"})
+            return
+        }
+
+        reason := req.Reason
+        if reason == "" {
+            reason = "Skipped by request"
+        }
+        if _, err := updateAppointmentStatus(db, appointmentID, "cancelled", actorID(c), "Series occurrence skipped: "+reason); err != nil {
+            log.Printf("Failed to skip occurrence: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to skip occurrence"})
+            return
+        }
+        if err := recordSeriesSkip(db, series.ID, req.Date, reason); err != nil {
+            log.Printf("Failed to record series skip: %v", err)
+        }
+
+        c.JSON(200, gin.H{"message": "Occurrence skipped successfully", "appointment_id": appointmentID})
+    })
+
+    // Move every future occurrence to a new weekday and/or time
+    api.PUT("/series/:id/reschedule", func(c *gin.Context) {
+        if !authorizeSeries(c, db, c.Param("id"), appointmentWritePermissions...) {
+            return
+        }
+        var req RescheduleSeriesRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if _, err := parseClock(req.AppointmentTime); err != nil {
+            c.JSON(400, gin.H{"error": "Invalid appointment_time, expected HH:MM"})
+            return
+        }
+
+        series, err := loadSeries(db, c.Param("id"))
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Series not found"})
+            return
+        }
+        if series.Status != "active" {
+            c.JSON(400, gin.H{"error": "Series is not active"})
+            return
+        }
+
+        newDay := series.DayOfWeek
+        if req.DayOfWeek != nil {
+            newDay = *req.DayOfWeek
+        }
+        shift := newDay - series.DayOfWeek
+
+        occurrences, err := futureSeriesAppointments(db, series.ID)
+        if err != nil {
+            log.Printf("Failed to fetch series occurrences: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to reschedule series"})
+            return
+        }
+
+        // Work out every move before touching anything. If any occurrence
+        // can't move, nothing does: cancelling it quietly could drop a paid
+        // booking, so the caller has to skip those dates first.
+        today := businessToday(db)
+        type seriesMove struct {
+            appointmentID int
+            date          string
+        }
+        var moves []seriesMove
+        conflicts := []SeriesConflict{}
+        for _, o := range occurrences {
+            current, err := time.Parse(dateLayout, o.Date)
+            if err != nil {
+                continue
+            }
+            newDate := current.AddDate(0, 0, shift).Format(dateLayout)
+            conflict := SeriesConflict{AppointmentID: o.AppointmentID, Date: o.Date, NewDate: newDate, Paid: o.PaymentID != nil}
+            if newDate < today {
+                conflict.Reason = "The new date has already passed"
+                conflicts = append(conflicts, conflict)
+                continue
+            }
+
+            _, reason, err := resolveSlot(db, series.ServiceID, newDate, req.AppointmentTime, o.AppointmentID, series.UserID, series.GroomerID)
+            if err != nil {
+                log.Printf("Failed to check slot: %v", err)
+                c.JSON(500, gin.H{"error": "Failed to reschedule series"})
+                return
+            }
+            if reason != "" {
+                conflict.Reason = reason
+                conflicts = append(conflicts, conflict)
+                continue
+            }
+            moves = append(moves, seriesMove{o.AppointmentID, newDate})
+        }
+        if len(conflicts) > 0 {
+            c.JSON(409, gin.H{
+                "error":     "Some occurrences can't be moved. Skip them or choose another day or time",
+                "conflicts": conflicts,
+            })
+            return
+        }
+
+        var moved []int
+        var slotTaken string
+        err = withChangeContext(db, actorID(c), "Series rescheduled", func(tx *sql.Tx) error {
+            for _, m := range moves {
+                // Re-check inside the transaction in case the slot was booked meanwhile
+                groomerID, reason, err := resolveSlot(tx, series.ServiceID, m.date, req.AppointmentTime, m.appointmentID, series.UserID, series.GroomerID)
+                if err != nil {
+                    return err
+                }
+                if reason != "" {
+                    slotTaken = reason
+                    return errSlotTaken
+                }
+
+                // Update in place so payment_id stays attached to the occurrence
+                _, err = tx.Exec(`
+                    UPDATE appointments
+                    SET appointment_date = $1, appointment_time = $2, groomer_id = $3, updated_at = CURRENT_TIMESTAMP
+                    WHERE id = $4
+                `, m.date, req.AppointmentTime, groomerID, m.appointmentID)
+                if err != nil {
+                    return err
+                }
+                moved = append(moved, m.appointmentID)
+            }
+
+            _, err := tx.Exec(`
+                UPDATE appointment_series SET day_of_week = $1, appointment_time = $2, updated_at = CURRENT_TIMESTAMP
+                WHERE id = $3
+            `, newDay, req.AppointmentTime, series.ID)
+            return err
+        })
+        if err == errSlotTaken {
+            c.JSON(409, gin.H{"error": slotTaken})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to reschedule series %d: %v", series.ID, err)
+            c.JSON(500, gin.H{"error": "Failed to reschedule series"})
+            return
+        }
+
+        for _, id := range moved {
+            if apt, err := loadAppointment(db, id); err == nil {
+                broadcastAppointmentUpdate(apt, "rescheduled")
+            }
+            go notifier.NotifyAppointment(id, "appointment_rescheduled", nil)
+        }
+
+        c.JSON(200, gin.H{
+            "message":         "Series rescheduled successfully",
+            "appointment_ids": moved,
+        })
+    })
+
+    // Cancel the series and every future occurrence
+    api.DELETE("/series/:id", func(c *gin.Context) {
+        if !authorizeSeries(c, db, c.Param("id"), appointmentWritePermissions...) {
+            return
+        }
+        series, err := loadSeries(db, c.Param("id"))
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Series not found"})
+            return
+        }
+
+        occurrences, err := futureSeriesAppointments(db, series.ID)
+        if err != nil {
+            log.Printf("Failed to fetch series occurrences: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to cancel series"})
+            return
+        }
+
+        // All or nothing, so a failure can't leave half the series booked
+        err = withChangeContext(db, actorID(c), "Series cancelled", func(tx *sql.Tx) error {
+            for _, o := range occurrences {
+                if err := setAppointmentStatus(tx, o.AppointmentID, "cancelled"); err != nil {
+                    return err
+                }
+            }
+            _, err := tx.Exec("UPDATE appointment_series SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP WHERE id = $1", series.ID)
+            return err
+        })
+        if err != nil {
+            log.Printf("Failed to cancel series %d: %v", series.ID, err)
+            c.JSON(500, gin.H{"error": "Failed to cancel series"})
+            return
+        }
+
+        for _, o := range occurrences {
+            announceStatusChange(db, o.AppointmentID)
+        }
+
+        c.JSON(200, gin.H{"message": "Series cancelled successfully", "appointments_cancelled": len(occurrences)})
+    })
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+const seriesID = "400"
+
+// scriptSeries sets up series 400 for ownerID with two upcoming weekly
+// occurrences, 201 and 202. failOn makes the status update for that
+// appointment fail.
+func scriptSeries(f *fakeDB, failOn int64) {
+    next := time.Now().AddDate(0, 0, 3).Format(dateLayout)
+    after := time.Now().AddDate(0, 0, 10).Format(dateLayout)
+    f.on("SELECT user_id FROM appointment_series WHERE id::text = $1", []string{"user_id"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(ownerID))
+    })
+    f.on("FROM appointment_series WHERE id = $1", []string{"id", "user_id", "pet_id", "service_id", "interval_weeks", "day_of_week",
+        "appointment_time", "start_date", "end_date", "occurrence_count", "requested_groomer_id", "status", "notes", "created_at"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(400), int64(ownerID), int64(100), int64(1), int64(1), int64(2), "10:00", next, nil, nil, nil, "active", "", time.Now())
+    })
+    f.on("WHERE series_id = $1 AND appointment_date >= $2", []string{"id", "date", "time", "status", "payment_id"}, func(args []driver.Value) [][]driver.Value {
+        return [][]driver.Value{
+            {int64(201), next, "10:00", "confirmed", nil},
+            {int64(202), after, "10:00", "confirmed", nil},
+        }
+    })
+    f.on("a.status, COALESCE(a.notes, ''), a.created_at", []string{"id", "user_id", "pet_id", "service_id", "appointment_date", "appointment_time",
+        "status", "notes", "created_at", "payment_id", "groomer_id", "pet_name", "service_name", "service_type"}, func(args []driver.Value) [][]driver.Value {
+        return row(args[0], int64(ownerID), int64(100), int64(1), next, "10:00", "cancelled", "", time.Now(), nil, nil, "Bella", "Full Groom", "grooming")
+    })
+    f.onExec("SELECT set_config", func(args []driver.Value) (int64, error) { return 1, nil })
+    f.onExec("SET status = $1", func(args []driver.Value) (int64, error) {
+        if args[1] == failOn {
+            return 0, errors.New("deadlock detected")
+        }
+        return 1, nil
+    })
+    f.onExec("UPDATE appointment_series SET status = 'cancelled'", func(args []driver.Value) (int64, error) { return 1, nil })
+}
+
+func deleteSeries(t *testing.T, db *sql.DB) *httptest.ResponseRecorder {
+    t.Helper()
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodDelete, "/api/v1/series/"+seriesID, nil)
+    req.Header.Set("Authorization", "Bearer "+ownerCustomer.token)
+    newRouter(db).ServeHTTP(w, req)
+    return w
+}
+
+func TestCancelSeriesInOneTransaction(t *testing.T) {
+    db, f := newAuthTestDB(t)
+    startTestHub()
+    useTestNotifier(t, db)
+    scriptSettings(f, nil)
+    scriptSeries(f, 0)
+
+    if w := deleteSeries(t, db); w.Code != 200 {
+        t.Fatalf("got %d: %s", w.Code, w.Body.String())
+    }
+
+    if begins := len(f.ran("BEGIN")); begins != 1 || f.commits != 1 {
+        t.Errorf("got %d transactions and %d commits, want one of each", begins, f.commits)
+    }
+    updates := f.ran("SET status = $1")
+    if len(updates) != 2 || updates[0].args[0] != "cancelled" || updates[1].args[1] != int64(202) {
+        t.Errorf("occurrences not cancelled: %v", updates)
+    }
+
+    // The history trigger reads who and why from the transaction
+    var actor, reason bool
+    for _, call := range f.ran("SELECT set_config") {
+        actor = actor || call.args[0] == fmt.Sprint(ownerID)
+        reason = reason || call.args[0] == "Series cancelled"
+    }
+    if !actor || !reason {
+        t.Errorf("change context not set: %v", f.ran("SELECT set_config"))
+    }
+
+    // Each occurrence is announced like any other status change
+    if loads := f.ran("a.status, COALESCE(a.notes, ''), a.created_at"); len(loads) != 2 {
+        t.Errorf("got %d occurrences announced, want 2", len(loads))
+    }
+}
+
+func TestCancelSeriesRollsBackOnFailure(t *testing.T) {
+    db, f := newAuthTestDB(t)
+    useTestNotifier(t, db)
+    scriptSettings(f, nil)
+    scriptSeries(f, 202)
+
+    if w := deleteSeries(t, db); w.Code != 500 {
+        t.Fatalf("got %d: %s", w.Code, w.Body.String())
+    }
+    if f.commits != 0 || f.rollbacks == 0 {
+        t.Errorf("got %d commits and %d rollbacks, want the transaction rolled back", f.commits, f.rollbacks)
+    }
+    if len(f.ran("UPDATE appointment_series SET status = 'cancelled'")) != 0 {
+        t.Errorf("series cancelled though an occurrence wasn't")
+    }
+    if len(f.ran("a.status, COALESCE(a.notes, ''), a.created_at")) != 0 {
+        t.Errorf("rolled back cancellations were announced")
+    }
+}
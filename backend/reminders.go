@@ -0,0 +1,143 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type upcomingAppointment struct {
+    ID        int
+    UserID    int
+    Date      string
+    Time      string
+    CreatedAt time.Time
+}
+
+// reminderOffsets parses notifications.reminder_offsets_hours ("24,2"),
+// largest first.
+func reminderOffsets(db *sql.DB) []time.Duration {
+    var offsets []time.Duration
+    for _, part := range strings.Split(getSetting(db, "notifications", "reminder_offsets_hours", "24,2"), ",") {
+        hours, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+        if err != nil || hours <= 0 {
+            continue
+        }
+        offsets = append(offsets, time.Duration(hours*float64(time.Hour)))
+    }
+    sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+    return offsets
+}
+
+// claimReminder records that a reminder is going out. The unique key on
+// (appointment_id, offset_minutes) means only one process wins, so restarts
+// and extra replicas never send the same reminder twice. A claim stuck in
+// "sending" (the process died mid-send) can be taken again after ten
+// minutes, the same as the notification outbox.
+func claimReminder(db *sql.DB, appointmentID int, offsetMinutes int) (int, bool) {
+    var id int
+    err := db.QueryRow(`
+        INSERT INTO appointment_reminders (appointment_id, offset_minutes, status, claimed_at, created_at)
+        VALUES ($1, $2, 'sending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+        ON CONFLICT (appointment_id, offset_minutes) DO UPDATE
+            SET claimed_at = CURRENT_TIMESTAMP
+            WHERE appointment_reminders.status = 'sending'
+              AND COALESCE(appointment_reminders.claimed_at, appointment_reminders.created_at) < CURRENT_TIMESTAMP - INTERVAL '10 minutes'
+        RETURNING id
+    `, appointmentID, offsetMinutes).Scan(&id)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Failed to claim reminder for appointment %d: %v", appointmentID, err)
+        }
+        return 0, false
+    }
+    return id, true
+}
+
+// sendDueReminders finds live appointments whose reminder time has arrived
+// and sends each offset's reminder once.
+func sendDueReminders(db *sql.DB) {
+    offsets := reminderOffsets(db)
+    if len(offsets) == 0 {
+        return
+    }
+
+    loc := businessLocation(db)
+    now := time.Now()
+
+    rows, err := db.Query(`
+        SELECT a.id, a.user_id, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI'), a.created_at
+        FROM appointments a
+        LEFT JOIN notification_preferences np ON np.user_id = a.user_id
+        WHERE a.status IN ('pending', 'confirmed')
+          AND a.appointment_date BETWEEN $1 AND $2
+          AND COALESCE(np.appointment_reminders, TRUE)
+    `, now.In(loc).Format(dateLayout), now.In(loc).Add(offsets[0]+24*time.Hour).Format(dateLayout))
+    if err != nil {
+        log.Printf("Failed to fetch upcoming appointments for reminders: %v", err)
+        return
+    }
+
+    var upcoming []upcomingAppointment
+    for rows.Next() {
+        var apt upcomingAppointment
+        if err := rows.Scan(&apt.ID, &apt.UserID, &apt.Date, &apt.Time, &apt.CreatedAt); err == nil {
+            upcoming = append(upcoming, apt)
+        }
+    }
+    rows.Close()
+
+    for _, apt := range upcoming {
+        startsAt, err := appointmentStart(loc, apt.Date, apt.Time)
+        if err != nil || !startsAt.After(now) {
+            continue
+        }
+
+        for i, offset := range offsets {
+            due := startsAt.Add(-offset)
+            // Skip reminders that would have fired before the booking existed
+            if now.Before(due) || due.Before(apt.CreatedAt) {
+                continue
+            }
+            // After downtime only the closest reminder is worth sending
+            if i+1 < len(offsets) && !now.Before(startsAt.Add(-offsets[i+1])) {
+                continue
+            }
+
+            reminderID, ok := claimReminder(db, apt.ID, int(offset/time.Minute))
+            if !ok {
+                continue
+            }
+
+            sendToUser(apt.UserID, "appointment_reminder", map[string]interface{}{
+                "appointment_id": apt.ID,
+                "starts_at":      startsAt,
+                "timestamp":      now,
+            })
+            // Email, SMS and inbox copies go through the outbox, which retries on its own
+            notifier.NotifyAppointment(apt.ID, "appointment_reminder", nil)
+
+            _, err = db.Exec(`
+                UPDATE appointment_reminders SET status = 'sent', sent_at = CURRENT_TIMESTAMP
+                WHERE id = $1
+            `, reminderID)
+            if err != nil {
+                log.Printf("Failed to mark reminder %d sent: %v", reminderID, err)
+            }
+            log.Printf("Sent %s reminder for appointment %d", offset, apt.ID)
+        }
+    }
+}
+
+func runReminderScheduler(db *sql.DB) {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        sendDueReminders(db)
+        sendConfirmationRequests(db)
+    }
+}
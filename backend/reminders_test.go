@@ -0,0 +1,129 @@
+package main
+
+import (
+    "database/sql/driver"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+// reminderTable mimics appointment_reminders behind claimReminder's
+// INSERT ... ON CONFLICT: a new key is claimed, and an existing one only if
+// it has been stuck in "sending" for more than ten minutes.
+type reminderTable struct {
+    nextID int64
+    rows   map[string]*reminderRow
+}
+
+type reminderRow struct {
+    id        int64
+    status    string
+    claimedAt time.Time
+}
+
+func scriptReminderTable(f *fakeDB) *reminderTable {
+    table := &reminderTable{rows: map[string]*reminderRow{}}
+    f.on("INSERT INTO appointment_reminders", []string{"id"}, func(args []driver.Value) [][]driver.Value {
+        key := fmt.Sprint(args[0], "/", args[1])
+        existing, ok := table.rows[key]
+        if !ok {
+            table.nextID++
+            table.rows[key] = &reminderRow{id: table.nextID, status: "sending", claimedAt: time.Now()}
+            return row(table.nextID)
+        }
+        if existing.status == "sending" && existing.claimedAt.Before(time.Now().Add(-10*time.Minute)) {
+            existing.claimedAt = time.Now()
+            return row(existing.id)
+        }
+        return nil
+    })
+    f.onExec("UPDATE appointment_reminders SET status = 'sent'", func(args []driver.Value) (int64, error) {
+        for _, r := range table.rows {
+            if r.id == args[0] {
+                r.status = "sent"
+            }
+        }
+        return 1, nil
+    })
+    return table
+}
+
+func TestClaimReminderOnce(t *testing.T) {
+    db, f := newFakeDB(t)
+    table := scriptReminderTable(f)
+
+    id, ok := claimReminder(db, 200, 120)
+    if !ok || id == 0 {
+        t.Fatalf("first claim failed")
+    }
+    if _, ok := claimReminder(db, 200, 120); ok {
+        t.Errorf("a fresh claim was taken twice")
+    }
+    if _, ok := claimReminder(db, 200, 1440); !ok {
+        t.Errorf("another offset for the same appointment was refused")
+    }
+
+    // The process that claimed it died; ten minutes on, someone else may retry
+    table.rows["200/120"].claimedAt = time.Now().Add(-11 * time.Minute)
+    if reclaimed, ok := claimReminder(db, 200, 120); !ok || reclaimed != id {
+        t.Errorf("stuck claim not reclaimed: got %d %v, want %d", reclaimed, ok, id)
+    }
+
+    // Once sent it stays sent
+    table.rows["200/120"].status = "sent"
+    table.rows["200/120"].claimedAt = time.Now().Add(-time.Hour)
+    if _, ok := claimReminder(db, 200, 120); ok {
+        t.Errorf("a sent reminder was claimed again")
+    }
+
+    query := f.ran("INSERT INTO appointment_reminders")[0].query
+    if !strings.Contains(query, "WHERE appointment_reminders.status = 'sending'") || !strings.Contains(query, "INTERVAL '10 minutes'") {
+        t.Errorf("claim query no longer limits reclaiming to stale sends: %s", query)
+    }
+}
+
+func TestSendDueRemindersSendsEachOffsetOnce(t *testing.T) {
+    db, f := newFakeDB(t)
+    startTestHub()
+    useTestNotifier(t, db)
+    scriptSettings(f, map[string]string{"notifications.reminder_offsets_hours": "24,2"})
+    scriptReminderTable(f)
+
+    startsAt := time.Now().Add(90 * time.Minute)
+    f.on("FROM appointments a LEFT JOIN notification_preferences np ON np.user_id = a.user_id WHERE a.status IN ('pending', 'confirmed')",
+        []string{"id", "user_id", "date", "time", "created_at"}, func(args []driver.Value) [][]driver.Value {
+            return row(int64(200), int64(ownerID), startsAt.Format(dateLayout), startsAt.Format("15:04"), time.Now().Add(-72*time.Hour))
+        })
+    f.on("COALESCE(a.followup_reason, '')", []string{"user_id", "pet", "service", "date", "time", "reason"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(ownerID), "Bella", "Full Groom", startsAt.Format(dateLayout), startsAt.Format("15:04"), "")
+    })
+    f.on("LEFT JOIN notification_preferences np ON np.user_id = u.id", []string{"name", "email", "phone", "email_on", "sms_on", "push_on", "reminders", "status"}, func(args []driver.Value) [][]driver.Value {
+        return row("Sam", "sam@example.com", "", true, false, false, true, true)
+    })
+    f.onExec("INSERT INTO notification_outbox", func(args []driver.Value) (int64, error) { return 1, nil })
+
+    sendDueReminders(db)
+    sendDueReminders(db)
+
+    // Only the two-hour reminder is due, and only once
+    claims := f.ran("INSERT INTO appointment_reminders")
+    if len(claims) != 2 || claims[0].args[1] != int64(120) {
+        t.Errorf("unexpected claims: %v", claims)
+    }
+    if sent := f.ran("UPDATE appointment_reminders SET status = 'sent'"); len(sent) != 1 {
+        t.Errorf("got %d reminders marked sent, want 1", len(sent))
+    }
+    queued := 0
+    for _, call := range f.ran("INSERT INTO notification_outbox") {
+        if call.args[1] == "appointment_reminder" {
+            queued++
+        }
+    }
+    if queued == 0 {
+        t.Errorf("reminder not queued for delivery")
+    }
+    if n := len(f.ran("COALESCE(a.followup_reason, '')")); n != 1 {
+        t.Errorf("reminder notified %d times, want once", n)
+    }
+}
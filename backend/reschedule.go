@@ -0,0 +1,158 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+type RescheduleAppointmentRequest struct {
+    AppointmentDate string `json:"appointment_date" binding:"required"`
+    AppointmentTime string `json:"appointment_time" binding:"required"`
+    Reason          string `json:"reason"`
+}
+
+// withChangeContext runs fn in a transaction tagged with the acting user and
+// reason so the appointment_history trigger can record them.
+func withChangeContext(db *sql.DB, changedBy *int, reason string, fn func(tx *sql.Tx) error) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if changedBy != nil {
+        if _, err := tx.Exec("SELECT set_config('app.current_user_id', $1, true)", fmt.Sprintf("%d", *changedBy)); err != nil {
+            return err
+        }
+    }
+    if reason != "" {
+        if _, err := tx.Exec("SELECT set_config('app.change_reason', $1, true)", reason); err != nil {
+            return err
+        }
+    }
+
+    if err := fn(tx); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func registerRescheduleRoutes(api *gin.RouterGroup, db *sql.DB) {
+    api.PUT("/appointments/:id/reschedule", func(c *gin.Context) {
+        appointmentID := c.Param("id")
+        if !authorizeAppointment(c, db, appointmentID, appointmentWritePermissions...) {
+            return
+        }
+
+        var req RescheduleAppointmentRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        var id, userID, serviceID int
+        var status, oldDate, oldTime string
+        var paymentID, requestedGroomer *int
+        err := db.QueryRow(`
+            SELECT id, user_id, service_id, status, to_char(appointment_date, 'YYYY-MM-DD'), to_char(appointment_time, 'HH24:MI'),
+                   payment_id, requested_groomer_id
+            FROM appointments WHERE id = $1
+        `, appointmentID).Scan(&id, &userID, &serviceID, &status, &oldDate, &oldTime, &paymentID, &requestedGroomer)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Appointment not found"})
+            return
+        }
+
+        if status != "pending" && status != "confirmed" {
+            c.JSON(400, gin.H{"error": fmt.Sprintf("Cannot reschedule an appointment that is %s", status)})
+            return
+        }
+
+        // Enforce the cutoff against the current slot
+        cutoffHours := getSettingInt(db, "scheduling", "reschedule_cutoff_hours", 24)
+        loc := businessLocation(db)
+        current, err := appointmentStart(loc, oldDate, oldTime)
+        if err == nil && time.Until(current) < time.Duration(cutoffHours)*time.Hour {
+            c.JSON(400, gin.H{"error": fmt.Sprintf("Appointments can't be rescheduled within %d hours of the start time", cutoffHours)})
+            return
+        }
+
+        requested, err := appointmentStart(loc, req.AppointmentDate, req.AppointmentTime)
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid date or time, expected YYYY-MM-DD and HH:MM"})
+            return
+        }
+        if requested.Before(time.Now()) {
+            c.JSON(400, gin.H{"error": "New time must be in the future"})
+            return
+        }
+
+        changeReason := req.Reason
+        if changeReason == "" {
+            changeReason = "Rescheduled"
+        }
+
+        var slotTaken string
+        err = withChangeContext(db, actorID(c), changeReason, func(tx *sql.Tx) error {
+            // Check inside the transaction so the slot can't be taken between
+            // the check and the update
+            groomerID, reason, err := resolveSlot(tx, serviceID, req.AppointmentDate, req.AppointmentTime, id, userID, requestedGroomer)
+            if err != nil {
+                return err
+            }
+            if reason != "" {
+                slotTaken = reason
+                return errSlotTaken
+            }
+
+            // Updating in place keeps payment_id (and any deposit) on the appointment
+            _, err = tx.Exec(`
+                UPDATE appointments
+                SET appointment_date = $1, appointment_time = $2, groomer_id = $3, updated_at = CURRENT_TIMESTAMP
+                WHERE id = $4
+            `, req.AppointmentDate, req.AppointmentTime, groomerID, id)
+            if err != nil {
+                return err
+            }
+
+            // Make sure the payment points back at the appointment it now covers
+            if paymentID != nil {
+                _, err = tx.Exec(`
+                    UPDATE payments SET appointment_id = $1, updated_at = CURRENT_TIMESTAMP
+                    WHERE id = $2 AND appointment_id IS NULL
+                `, id, *paymentID)
+            }
+            return err
+        })
+        if err == errSlotTaken {
+            c.JSON(409, gin.H{"error": slotTaken})
+            return
+        }
+        if err != nil {
+            log.Printf("Failed to reschedule appointment %d: %v", id, err)
+            c.JSON(500, gin.H{"error": "Failed to reschedule appointment"})
+            return
+        }
+
+        apt, err := loadAppointment(db, id)
+        if err == nil {
+            broadcastAppointmentUpdate(apt, "rescheduled")
+        }
+        go notifier.NotifyAppointment(id, "appointment_rescheduled", nil)
+
+        // The old slot is now free for the waitlist
+        go offerFreedSlot(db, serviceID, oldDate, oldTime)
+
+        c.JSON(200, gin.H{
+            "message":         "Appointment rescheduled successfully",
+            "appointment":     apt,
+            "old_date":        oldDate,
+            "old_time":        oldTime,
+            "payment_carried": paymentID != nil,
+        })
+    })
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// scriptReschedule sets up appointment 200, a bath for ownerID ten days out,
+// with the given number of bookings already overlapping any new slot and
+// closedOn marked as a holiday.
+func scriptReschedule(f *fakeDB, overlapping int, closedOn string) {
+    current := time.Now().AddDate(0, 0, 10).Format(dateLayout)
+    f.on("payment_id, requested_groomer_id FROM appointments WHERE id = $1", []string{"id", "user_id", "service_id", "status", "date", "time", "payment_id", "requested_groomer_id"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(200), int64(ownerID), int64(1), "confirmed", current, "10:00", nil, nil)
+    })
+    f.on("SELECT type, COALESCE(duration_minutes, 60) FROM services WHERE id = $1", []string{"type", "duration"}, func(args []driver.Value) [][]driver.Value {
+        return row("bath", int64(60))
+    })
+    f.on("FROM business_closures WHERE closure_date = $1", []string{"closure_type", "open", "close", "reason"}, func(args []driver.Value) [][]driver.Value {
+        if args[0] == closedOn {
+            return row("closed", nil, nil, "Thanksgiving")
+        }
+        return nil
+    })
+    f.on("SELECT COUNT(*) FROM staff_schedule", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(1))
+    })
+    f.on("WHERE a.appointment_date = $1 AND a.status != 'cancelled' AND s.type = $2", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(overlapping))
+    })
+    f.on("FROM waitlist_offers o JOIN waitlist_entries e", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(0))
+    })
+    f.onExec("SELECT set_config", func(args []driver.Value) (int64, error) { return 1, nil })
+    f.onExec("UPDATE appointments SET appointment_date", func(args []driver.Value) (int64, error) { return 1, nil })
+}
+
+func putReschedule(t *testing.T, db *sql.DB, date string) *httptest.ResponseRecorder {
+    t.Helper()
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodPut, "/api/v1/appointments/"+appointmentID+"/reschedule",
+        strings.NewReader(`{"appointment_date": "`+date+`", "appointment_time": "11:00"}`))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+ownerCustomer.token)
+    newRouter(db).ServeHTTP(w, req)
+    return w
+}
+
+func TestRescheduleRejections(t *testing.T) {
+    newDate := time.Now().AddDate(0, 0, 12).Format(dateLayout)
+    yesterday := time.Now().AddDate(0, 0, -1).Format(dateLayout)
+
+    for _, tc := range []struct {
+        name        string
+        date        string
+        overlapping int
+        closedOn    string
+        want        int
+        wantError   string
+    }{
+        {"slot already booked", newDate, 1, "", 409, "Conflicts with an existing appointment"},
+        {"closed date", newDate, 0, newDate, 409, "We're closed on " + newDate + " (Thanksgiving)"},
+        {"past date", yesterday, 0, "", 400, "New time must be in the future"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            db, f := newAuthTestDB(t)
+            scriptSettings(f, nil)
+            scriptReschedule(f, tc.overlapping, tc.closedOn)
+
+            w := putReschedule(t, db, tc.date)
+            if w.Code != tc.want || !strings.Contains(w.Body.String(), tc.wantError) {
+                t.Fatalf("got %d %s, want %d %q", w.Code, w.Body.String(), tc.want, tc.wantError)
+            }
+            if len(f.ran("UPDATE appointments SET appointment_date")) != 0 {
+                t.Errorf("appointment was moved")
+            }
+            if f.commits != 0 {
+                t.Errorf("got %d commits, want none", f.commits)
+            }
+        })
+    }
+}
+
+func TestRescheduleChecksSlotInsideTransaction(t *testing.T) {
+    db, f := newAuthTestDB(t)
+    startTestHub()
+    useTestNotifier(t, db)
+    scriptSettings(f, nil)
+    scriptReschedule(f, 0, "")
+
+    w := putReschedule(t, db, time.Now().AddDate(0, 0, 12).Format(dateLayout))
+    if w.Code != 200 {
+        t.Fatalf("got %d: %s", w.Code, w.Body.String())
+    }
+    began, checked, moved := -1, -1, -1
+    for i, call := range f.ran("") {
+        switch {
+        case call.query == "BEGIN" && began < 0:
+            began = i
+        case strings.Contains(call.query, "a.status != 'cancelled' AND s.type = $2") && checked < 0:
+            checked = i
+        case strings.Contains(call.query, "UPDATE appointments SET appointment_date") && moved < 0:
+            moved = i
+        }
+    }
+    if began < 0 || checked < began || moved < checked {
+        t.Errorf("slot check not inside the transaction: begin %d, check %d, update %d", began, checked, moved)
+    }
+    if f.commits != 1 {
+        t.Errorf("got %d commits, want 1", f.commits)
+    }
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Shared slot helpers used by booking, series generation and rescheduling.
+
+const dateLayout = "2006-01-02"
+
+// DayHours is the effective opening window for one date: the weekly
+// business_hours template unless a business_closures entry overrides it.
+type DayHours struct {
+    Date     string `json:"date"`
+    Open     string `json:"open,omitempty"`
+    Close    string `json:"close,omitempty"`
+    Closed   bool   `json:"closed"`
+    Override bool   `json:"override"`
+    Reason   string `json:"reason,omitempty"`
+}
+
+// openHoursFor resolves the business hours for a date.
+func openHoursFor(db querier, day time.Time) (DayHours, error) {
+    hours := DayHours{Date: day.Format(dateLayout)}
+
+    var closureType string
+    var open, close, reason sql.NullString
+    err := db.QueryRow(`
+        SELECT closure_type, to_char(open_time, 'HH24:MI'), to_char(close_time, 'HH24:MI'), reason
+        FROM business_closures WHERE closure_date = $1
+    `, hours.Date).Scan(&closureType, &open, &close, &reason)
+    if err == nil {
+        hours.Override = true
+        hours.Reason = reason.String
+        hours.Closed = closureType == "closed"
+        hours.Open = open.String
+        hours.Close = close.String
+        return hours, nil
+    }
+    if err != sql.ErrNoRows {
+        return hours, err
+    }
+
+    var template struct {
+        Start  string `json:"start"`
+        End    string `json:"end"`
+        Closed bool   `json:"closed"`
+    }
+    raw := getSetting(db, "business_hours", strings.ToLower(day.Weekday().String()), "")
+    if raw == "" {
+        return hours, nil
+    }
+    if err := json.Unmarshal([]byte(raw), &template); err != nil {
+        log.Printf("Invalid business_hours setting for %s: %v", day.Weekday(), err)
+        return hours, nil
+    }
+    hours.Closed = template.Closed
+    hours.Open = template.Start
+    hours.Close = template.End
+    return hours, nil
+}
+
+// parseClock accepts "15:04" or "15:04:05" and returns the time of day.
+func parseClock(value string) (time.Time, error) {
+    if t, err := time.Parse("15:04", value); err == nil {
+        return t, nil
+    }
+    return time.Parse("15:04:05", value)
+}
+
+// checkSlotAvailable reports why a service can't be booked at the given date
+// and time. An empty reason means the slot is free. excludeID lets a caller
+// ignore an appointment that is being moved, and holderID is the customer
+// booking, whose own waitlist holds don't block them (0 for nobody).
+func checkSlotAvailable(db querier, serviceID int, date string, clock string, excludeID int, holderID int) (string, error) {
+    _, reason, err := resolveSlot(db, serviceID, date, clock, excludeID, holderID, nil)
+    return reason, err
+}
+
+// resolveSlot validates a slot and, for grooming, picks the groomer to book.
+// When requestedGroomer is set only that groomer is considered. The returned
+// groomer is nil for services that don't need one or when no groomers are
+// configured yet. Slots held by a pending waitlist offer count as booked
+// unless the hold belongs to holderID.
+func resolveSlot(db querier, serviceID int, date string, clock string, excludeID int, holderID int, requestedGroomer *int) (*int, string, error) {
+    day, err := time.Parse(dateLayout, date)
+    if err != nil {
+        return nil, "Invalid date, expected YYYY-MM-DD", nil
+    }
+    start, err := parseClock(clock)
+    if err != nil {
+        return nil, "Invalid time, expected HH:MM", nil
+    }
+
+    var serviceType string
+    var duration int
+    err = db.QueryRow("SELECT type, COALESCE(duration_minutes, 60) FROM services WHERE id = $1", serviceID).Scan(&serviceType, &duration)
+    if err == sql.ErrNoRows {
+        return nil, "Service not found", nil
+    }
+    if err != nil {
+        return nil, "", err
+    }
+
+    end := start.Add(time.Duration(duration) * time.Minute)
+    if end.Day() != start.Day() {
+        return nil, "Appointment would run past midnight", nil
+    }
+    startStr := start.Format("15:04")
+    endStr := end.Format("15:04")
+
+    // Holiday closures and special hours win over the weekly template
+    hours, err := openHoursFor(db, day)
+    if err != nil {
+        return nil, "", err
+    }
+    if hours.Closed {
+        if hours.Reason != "" {
+            return nil, fmt.Sprintf("We're closed on %s (%s)", date, hours.Reason), nil
+        }
+        return nil, fmt.Sprintf("We're closed on %s", date), nil
+    }
+    if hours.Open != "" && hours.Close != "" && (startStr < hours.Open || endStr > hours.Close) {
+        return nil, fmt.Sprintf("Outside business hours on %s (%s-%s)", date, hours.Open, hours.Close), nil
+    }
+
+    // Must fit inside the staff schedule for this service type. Special hours
+    // replace the regular schedule for that date.
+    if !hours.Override {
+        var scheduled int
+        err = db.QueryRow(`
+            SELECT COUNT(*) FROM staff_schedule
+            WHERE day_of_week = $1 AND service_type = $2 AND active = TRUE
+              AND start_time <= $3::time AND end_time >= $4::time
+        `, int(day.Weekday()), serviceType, startStr, endStr).Scan(&scheduled)
+        if err != nil {
+            return nil, "", err
+        }
+        if scheduled == 0 {
+            return nil, fmt.Sprintf("No %s availability on %s at %s", serviceType, day.Format("Monday"), startStr), nil
+        }
+    }
+
+    if serviceType == "groom" {
+        var groomers int
+        if err := db.QueryRow("SELECT COUNT(*) FROM admin_users WHERE is_groomer = TRUE").Scan(&groomers); err != nil {
+            return nil, "", err
+        }
+        if groomers > 0 {
+            return pickGroomer(db, day, startStr, endStr, excludeID, holderID, requestedGroomer)
+        }
+    }
+
+    // DIY bookings share the wash stations; everything else is one at a time
+    capacity := 1
+    if serviceType == "diy" {
+        var stations int
+        if err := db.QueryRow("SELECT COUNT(*) FROM wash_stations WHERE active = TRUE").Scan(&stations); err != nil {
+            return nil, "", err
+        }
+        if stations > 0 {
+            capacity = stations
+        }
+    }
+
+    // Must not overlap more live appointments of the same type than capacity allows
+    var overlapping int
+    err = db.QueryRow(`
+        SELECT COUNT(*)
+        FROM appointments a
+        JOIN services s ON a.service_id = s.id
+        WHERE a.appointment_date = $1 AND a.status != 'cancelled' AND s.type = $2 AND a.id != $3
+          AND a.appointment_time < $5::time
+          AND a.appointment_time + make_interval(mins => COALESCE(s.duration_minutes, 60)) > $4::time
+    `, date, serviceType, excludeID, startStr, endStr).Scan(&overlapping)
+    if err != nil {
+        return nil, "", err
+    }
+    held, err := countHeldSlots(db, serviceType, date, startStr, endStr, holderID)
+    if err != nil {
+        return nil, "", err
+    }
+    if overlapping+held >= capacity {
+        if serviceType == "diy" {
+            return nil, fmt.Sprintf("All wash stations are booked on %s at %s", date, startStr), nil
+        }
+        return nil, fmt.Sprintf("Conflicts with an existing appointment on %s at %s", date, startStr), nil
+    }
+
+    return nil, "", nil
+}
+
+// pickGroomer returns the least-loaded groomer who is working, not on time
+// off and not already booked for the whole window. Legacy grooming
+// appointments without a groomer still use up one groomer each.
+func pickGroomer(db querier, day time.Time, startStr string, endStr string, excludeID int, holderID int, requested *int) (*int, string, error) {
+    date := day.Format(dateLayout)
+
+    rows, err := db.Query(`
+        SELECT au.id
+        FROM admin_users au
+        JOIN users u ON au.user_id = u.id
+        WHERE au.is_groomer = TRUE AND u.status = 'active'
+          AND ($6::int IS NULL OR au.id = $6)
+          AND EXISTS (
+              SELECT 1 FROM groomer_hours h
+              WHERE h.admin_user_id = au.id AND h.day_of_week = $1 AND h.active = TRUE
+                AND h.start_time <= $3::time AND h.end_time >= $4::time
+          )
+          AND NOT EXISTS (
+              SELECT 1 FROM groomer_time_off t
+              WHERE t.admin_user_id = au.id AND $2::date BETWEEN t.start_date AND t.end_date
+                AND (t.start_time IS NULL OR (t.start_time < $4::time AND t.end_time > $3::time))
+          )
+          AND NOT EXISTS (
+              SELECT 1 FROM appointments a
+              JOIN services s ON a.service_id = s.id
+              WHERE a.groomer_id = au.id AND a.appointment_date = $2 AND a.status != 'cancelled' AND a.id != $5
+                AND a.appointment_time < $4::time
+                AND a.appointment_time + make_interval(mins => COALESCE(s.duration_minutes, 60)) > $3::time
+          )
+        ORDER BY (
+            SELECT COALESCE(SUM(COALESCE(s.duration_minutes, 60)), 0)
+            FROM appointments a
+            JOIN services s ON a.service_id = s.id
+            WHERE a.groomer_id = au.id AND a.appointment_date = $2 AND a.status != 'cancelled'
+        ), au.id
+    `, int(day.Weekday()), date, startStr, endStr, excludeID, requested)
+    if err != nil {
+        return nil, "", err
+    }
+    defer rows.Close()
+
+    var free []int
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            return nil, "", err
+        }
+        free = append(free, id)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, "", err
+    }
+
+    var unassigned int
+    err = db.QueryRow(`
+        SELECT COUNT(*)
+        FROM appointments a
+        JOIN services s ON a.service_id = s.id
+        WHERE a.appointment_date = $1 AND a.status != 'cancelled' AND s.type = 'groom'
+          AND a.groomer_id IS NULL AND a.id != $2
+          AND a.appointment_time < $4::time
+          AND a.appointment_time + make_interval(mins => COALESCE(s.duration_minutes, 60)) > $3::time
+    `, date, excludeID, startStr, endStr).Scan(&unassigned)
+    if err != nil {
+        return nil, "", err
+    }
+    // A held slot hasn't been given a groomer yet but will need one
+    held, err := countHeldSlots(db, "groom", date, startStr, endStr, holderID)
+    if err != nil {
+        return nil, "", err
+    }
+    unassigned += held
+
+    if requested != nil {
+        if len(free) == 0 {
+            return nil, fmt.Sprintf("The requested groomer isn't available on %s at %s", date, startStr), nil
+        }
+        return &free[0], "", nil
+    }
+    if len(free) <= unassigned {
+        return nil, fmt.Sprintf("No groomer available on %s at %s", date, startStr), nil
+    }
+    return &free[0], "", nil
+}
+
+// countHeldSlots counts pending waitlist offers of serviceType overlapping
+// the window, other than those held for holderID.
+func countHeldSlots(db querier, serviceType string, date string, startStr string, endStr string, holderID int) (int, error) {
+    var held int
+    err := db.QueryRow(`
+        SELECT COUNT(*)
+        FROM waitlist_offers o
+        JOIN waitlist_entries e ON o.entry_id = e.id
+        JOIN services s ON o.service_id = s.id
+        WHERE o.status = 'pending' AND o.expires_at > CURRENT_TIMESTAMP
+          AND o.offer_date = $1 AND s.type = $2 AND e.user_id != $3
+          AND o.offer_time < $5::time
+          AND o.offer_time + make_interval(mins => COALESCE(s.duration_minutes, 60)) > $4::time
+    `, date, serviceType, holderID, startStr, endStr).Scan(&held)
+    return held, err
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// loadAppointment fetches an appointment with the joined fields used for
+// WebSocket broadcasts.
+func loadAppointment(db *sql.DB, appointmentID int) (Appointment, error) {
+    var apt Appointment
+    err := db.QueryRow(`
+        SELECT a.id, a.user_id, a.pet_id, a.service_id, a.appointment_date, a.appointment_time,
+               a.status, COALESCE(a.notes, ''), a.created_at, a.payment_id, a.groomer_id, p.name as pet_name, s.name as service_name, s.type as service_type
+        FROM appointments a
+        JOIN pets p ON a.pet_id = p.id
+        JOIN services s ON a.service_id = s.id
+        WHERE a.id = $1
+    `, appointmentID).Scan(&apt.ID, &apt.UserID, &apt.PetID, &apt.ServiceID,
+        &apt.AppointmentDate, &apt.AppointmentTime, &apt.Status, &apt.Notes,
+        &apt.CreatedAt, &apt.PaymentID, &apt.GroomerID, &apt.PetName, &apt.ServiceName, &apt.ServiceType)
+    apt.Date = apt.AppointmentDate
+    apt.Time = apt.AppointmentTime
+    apt.setStartsAt(businessLocation(db))
+    return apt, err
+}
+
+// updateAppointmentStatus applies a status change along with everything that
+// hangs off it: the live dashboard update, the customer notification and
+// handing a cancelled slot to the waitlist. Every path that changes status
+// should come through here, or through setAppointmentStatus and
+// announceStatusChange when several changes share a transaction.
+func updateAppointmentStatus(db *sql.DB, appointmentID int, status string, changedBy *int, reason string) (Appointment, error) {
+    err := withChangeContext(db, changedBy, reason, func(tx *sql.Tx) error {
+        return setAppointmentStatus(tx, appointmentID, status)
+    })
+    if err != nil {
+        return Appointment{}, err
+    }
+    return announceStatusChange(db, appointmentID), nil
+}
+
+// setAppointmentStatus is the write half of updateAppointmentStatus, for a
+// transaction opened with withChangeContext. Call announceStatusChange for
+// each appointment once it commits.
+func setAppointmentStatus(tx *sql.Tx, appointmentID int, status string) error {
+    result, err := tx.Exec(`
+        UPDATE appointments
+        SET status = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, status, appointmentID)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return sql.ErrNoRows
+    }
+    return nil
+}
+
+// announceStatusChange broadcasts, notifies and frees the slot after a
+// status change has committed.
+func announceStatusChange(db *sql.DB, appointmentID int) Appointment {
+    apt, err := loadAppointment(db, appointmentID)
+    if err != nil {
+        log.Printf("Failed to load appointment %d after status change: %v", appointmentID, err)
+        return apt
+    }
+
+    broadcastAppointmentUpdate(apt, "status_updated")
+    go notifier.notifyStatusChange(apt.ID, apt.Status)
+
+    // A cancellation frees the slot for anyone on the waitlist
+    if apt.Status == "cancelled" {
+        go releaseAppointmentSlot(db, apt.ID)
+    }
+    return apt
+}
+
+func registerAvailabilityRoutes(api *gin.RouterGroup, db *sql.DB) {
+    // List bookable start times for a service on a date
+    api.GET("/availability", func(c *gin.Context) {
+        date := c.Query("date")
+        day, err := time.Parse(dateLayout, date)
+        if err != nil {
+            c.JSON(400, gin.H{"error": "date is required as YYYY-MM-DD"})
+            return
+        }
+        serviceID, err := strconv.Atoi(c.Query("service_id"))
+        if err != nil {
+            c.JSON(400, gin.H{"error": "service_id is required"})
+            return
+        }
+        var requestedGroomer *int
+        if id, err := strconv.Atoi(c.Query("groomer_id")); err == nil {
+            requestedGroomer = &id
+        }
+        // A signed-in customer sees slots held for them as open
+        holderID, _ := currentUserID(c)
+
+        hours, err := openHoursFor(db, day)
+        if err != nil {
+            log.Printf("Failed to load business hours: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch availability"})
+            return
+        }
+        // Slot times are shop wall-clock times; look the zone up once, not per slot
+        loc := businessLocation(db)
+
+        slots := []string{}
+        if !hours.Closed {
+            open, close := "00:00", "23:59"
+            if hours.Open != "" && hours.Close != "" {
+                open, close = hours.Open, hours.Close
+            }
+            start, err1 := parseClock(open)
+            end, err2 := parseClock(close)
+            if err1 != nil || err2 != nil {
+                c.JSON(500, gin.H{"error": "Business hours are misconfigured"})
+                return
+            }
+
+            interval := time.Duration(getSettingInt(db, "scheduling", "slot_interval_minutes", 30)) * time.Minute
+            if interval <= 0 {
+                interval = 30 * time.Minute
+            }
+            // Hide slots that have already passed at the shop
+            now := time.Now()
+            for t := start; t.Before(end); t = t.Add(interval) {
+                clock := t.Format("15:04")
+                if startsAt, err := appointmentStart(loc, date, clock); err == nil && startsAt.Before(now) {
+                    continue
+                }
+                _, reason, err := resolveSlot(db, serviceID, date, clock, 0, holderID, requestedGroomer)
+                if err != nil {
+                    log.Printf("Failed to check slot: %v", err)
+                    c.JSON(500, gin.H{"error": "Failed to fetch availability"})
+                    return
+                }
+                if reason == "" {
+                    slots = append(slots, clock)
+                }
+            }
+        }
+
+        c.JSON(200, gin.H{"hours": hours, "slots": slots, "timezone": loc.String()})
+    })
+}
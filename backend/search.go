@@ -0,0 +1,179 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "regexp"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// The document each entity is searched by. These must match the expression
+// indexes in search_migration.sql exactly or Postgres won't use them.
+const (
+    userSearchDocument        = `to_tsvector('simple', COALESCE(u.name, '') || ' ' || COALESCE(u.email, '') || ' ' || COALESCE(u.phone, ''))`
+    petSearchDocument         = `to_tsvector('simple', COALESCE(p.name, '') || ' ' || COALESCE(p.breed, '') || ' ' || COALESCE(p.notes, ''))`
+    appointmentSearchDocument = `to_tsvector('english', COALESCE(a.notes, ''))`
+    phoneDigitsExpression     = `regexp_replace(COALESCE(u.phone, ''), '\D', '', 'g')`
+)
+
+var searchTermChars = regexp.MustCompile(`[^\pL\pN]+`)
+
+// searchTSQuery turns what was typed into a prefix query where every word
+// must match, e.g. "Bella gold" becomes "bella:* & gold:*", so results show
+// up while the front desk is still typing.
+func searchTSQuery(q string) string {
+    terms := []string{}
+    for _, term := range searchTermChars.Split(strings.ToLower(q), -1) {
+        if term != "" {
+            terms = append(terms, term+":*")
+        }
+    }
+    return strings.Join(terms, " & ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes q match literally inside a LIKE pattern, so "50%" or
+// "a_b" aren't wildcards. Backslash is Postgres's default LIKE escape.
+func escapeLike(q string) string {
+    return likeEscaper.Replace(q)
+}
+
+type SearchResult struct {
+    Type     string  `json:"type"` // customer, pet, appointment
+    ID       int     `json:"id"`
+    Title    string  `json:"title"`
+    Subtitle string  `json:"subtitle"`
+    UserID   int     `json:"user_id"`
+    When     string  `json:"when,omitempty"` // appointments only, business local time
+    Rank     float64 `json:"rank"`
+}
+
+func scanSearchResults(rows *sql.Rows, resultType string) []SearchResult {
+    results := []SearchResult{}
+    for rows.Next() {
+        r := SearchResult{Type: resultType}
+        if err := rows.Scan(&r.ID, &r.Title, &r.Subtitle, &r.UserID, &r.When, &r.Rank); err != nil {
+            log.Printf("Error scanning %s search result: %v", resultType, err)
+            continue
+        }
+        results = append(results, r)
+    }
+    return results
+}
+
+func registerSearchRoutes(admin *gin.RouterGroup, db *sql.DB) {
+    // Front desk search box: names, emails, phone fragments, pet names,
+    // breeds and notes, ranked within each type. Needs customer_lookup or
+    // a broader customer permission.
+    admin.GET("/search", func(c *gin.Context) {
+        if !requirePermission(c, customerReadPermissions...) {
+            return
+        }
+        q := strings.TrimSpace(c.Query("q"))
+        if len([]rune(q)) < 2 {
+            c.JSON(400, gin.H{"error": "Search needs at least 2 characters"})
+            return
+        }
+        if runes := []rune(q); len(runes) > 100 {
+            q = string(runes[:100])
+        }
+        limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+        if err != nil || limit < 1 || limit > 25 {
+            limit = 10
+        }
+
+        tsquery := searchTSQuery(q)
+        // Only treat it as a phone search when there are enough digits to mean something
+        digits := nonDigits.ReplaceAllString(q, "")
+        if len(digits) < 3 {
+            digits = ""
+        }
+
+        grouped := gin.H{}
+        total := 0
+
+        // Customers: words, fuzzy name (typos), email fragment or phone digits
+        rows, err := db.Query(`
+            SELECT u.id, u.name, u.email || COALESCE(' · ' || NULLIF(u.phone, ''), ''), u.id, '',
+                   GREATEST(
+                       CASE WHEN $1 != '' THEN ts_rank(`+userSearchDocument+`, to_tsquery('simple', $1)) ELSE 0 END,
+                       similarity(u.name, $2),
+                       CASE WHEN $3 != '' AND `+phoneDigitsExpression+` LIKE '%' || $3 || '%' THEN 1 ELSE 0 END
+                   ) AS rank
+            FROM users u
+            WHERE COALESCE(u.role, 'customer') = 'customer' AND COALESCE(u.status, 'active') NOT IN ('deleted', 'merged')
+              AND (($1 != '' AND `+userSearchDocument+` @@ to_tsquery('simple', $1))
+                   OR u.name % $2
+                   OR u.email ILIKE '%' || $5 || '%'
+                   OR ($3 != '' AND `+phoneDigitsExpression+` LIKE '%' || $3 || '%'))
+            ORDER BY rank DESC, u.name
+            LIMIT $4
+        `, tsquery, q, digits, limit, escapeLike(q))
+        if err != nil {
+            log.Printf("Failed to search customers: %v", err)
+            c.JSON(500, gin.H{"error": "Search failed"})
+            return
+        }
+        customers := scanSearchResults(rows, "customer")
+        rows.Close()
+        grouped["customers"] = customers
+        total += len(customers)
+
+        // Pets: "Bella golden" matches Bella the Golden Retriever
+        rows, err = db.Query(`
+            SELECT p.id, p.name, COALESCE(NULLIF(p.breed, '') || ' · ', '') || u.name, p.user_id, '',
+                   GREATEST(
+                       CASE WHEN $1 != '' THEN ts_rank(`+petSearchDocument+`, to_tsquery('simple', $1)) ELSE 0 END,
+                       similarity(p.name, $2)
+                   ) AS rank
+            FROM pets p
+            JOIN users u ON p.user_id = u.id
+            WHERE COALESCE(u.status, 'active') NOT IN ('deleted', 'merged')
+              AND (($1 != '' AND `+petSearchDocument+` @@ to_tsquery('simple', $1)) OR p.name % $2)
+            ORDER BY rank DESC, p.name
+            LIMIT $3
+        `, tsquery, q, limit)
+        if err != nil {
+            log.Printf("Failed to search pets: %v", err)
+            c.JSON(500, gin.H{"error": "Search failed"})
+            return
+        }
+        pets := scanSearchResults(rows, "pet")
+        rows.Close()
+        grouped["pets"] = pets
+        total += len(pets)
+
+        // Appointment notes, newest first among equally good matches
+        appointments := []SearchResult{}
+        if tsquery != "" {
+            rows, err = db.Query(`
+                SELECT a.id, p.name || ' · ' || s.name, u.name || ': ' || LEFT(a.notes, 120), a.user_id,
+                       to_char(a.appointment_date, 'YYYY-MM-DD') || ' ' || to_char(a.appointment_time, 'HH24:MI'),
+                       ts_rank(`+appointmentSearchDocument+`, to_tsquery('english', $1)) AS rank
+                FROM appointments a
+                JOIN pets p ON a.pet_id = p.id
+                JOIN users u ON a.user_id = u.id
+                JOIN services s ON a.service_id = s.id
+                WHERE COALESCE(u.status, 'active') NOT IN ('deleted', 'merged')
+                  AND `+appointmentSearchDocument+` @@ to_tsquery('english', $1)
+                ORDER BY rank DESC, a.appointment_date DESC
+                LIMIT $2
+            `, tsquery, limit)
+            if err != nil {
+                log.Printf("Failed to search appointments: %v", err)
+                c.JSON(500, gin.H{"error": "Search failed"})
+                return
+            }
+            appointments = scanSearchResults(rows, "appointment")
+            rows.Close()
+        }
+        grouped["appointments"] = appointments
+        total += len(appointments)
+
+        c.JSON(200, gin.H{"query": q, "total": total, "results": grouped})
+    })
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+    tests := []struct {
+        in   string
+        want string
+    }{
+        {"bella", "bella"},
+        {"50%", `50\%`},
+        {"a_b", `a\_b`},
+        {`back\slash`, `back\\slash`},
+        {`%_\`, `\%\_\\`},
+    }
+    for _, tt := range tests {
+        if got := escapeLike(tt.in); got != tt.want {
+            t.Errorf("escapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestSearchTSQuery(t *testing.T) {
+    tests := []struct {
+        in   string
+        want string
+    }{
+        {"Bella gold", "bella:* & gold:*"},
+        {"  o'brien ", "o:* & brien:*"},
+        {"%_", ""},
+    }
+    for _, tt := range tests {
+        if got := searchTSQuery(tt.in); got != tt.want {
+            t.Errorf("searchTSQuery(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "crypto/rand"
+    "database/sql"
+    "encoding/base64"
+    "log"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// issueSession starts a signed-in session for userID and returns the bearer
+// token. Sessions slide: each request pushes expiry out by
+// security.session_timeout minutes.
+func issueSession(db *sql.DB, c *gin.Context, userID int) (string, time.Time, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return "", time.Time{}, err
+    }
+    token := base64.RawURLEncoding.EncodeToString(raw)
+
+    var expiresAt time.Time
+    err := db.QueryRow(`
+        INSERT INTO user_sessions (user_id, token_hash, ip_address, user_agent, expires_at, last_seen_at, created_at)
+        VALUES ($1, $2, NULLIF($3, '')::inet, NULLIF($4, ''), CURRENT_TIMESTAMP + make_interval(mins => $5), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+        RETURNING expires_at
+    `, userID, hashToken(token), c.ClientIP(), c.Request.UserAgent(), getSettingInt(db, "security", "session_timeout", 30)).Scan(&expiresAt)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    return token, expiresAt, nil
+}
+
+// touchSession finds the live session for token and extends it, returning
+// the admin behind it for impersonation sessions, which never extend.
+// Revoked, expired and unknown tokens come back as sql.ErrNoRows.
+func touchSession(db *sql.DB, token string) (int, int, *int, error) {
+    var sessionID, userID int
+    var impersonatorID *int
+    err := db.QueryRow(`
+        UPDATE user_sessions
+        SET last_seen_at = CURRENT_TIMESTAMP,
+            expires_at = CASE WHEN impersonator_id IS NULL
+                THEN CURRENT_TIMESTAMP + make_interval(mins => $2) ELSE expires_at END
+        WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+        RETURNING id, user_id, impersonator_id
+    `, hashToken(token), getSettingInt(db, "security", "session_timeout", 30)).Scan(&sessionID, &userID, &impersonatorID)
+    return sessionID, userID, impersonatorID, err
+}
+
+// revokeUserSessions signs a user out everywhere, e.g. after a password
+// reset. That includes anyone impersonating them.
+func revokeUserSessions(db *sql.DB, userID int) {
+    _, err := db.Exec(`
+        UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND revoked_at IS NULL
+    `, userID)
+    if err != nil {
+        log.Printf("Failed to revoke sessions for user %d: %v", userID, err)
+    }
+}
+
+// loginResponse adds a fresh session to a successful login reply.
+func loginResponse(db *sql.DB, c *gin.Context, userID int, response gin.H) {
+    token, expiresAt, err := issueSession(db, c, userID)
+    if err != nil {
+        log.Printf("Failed to start session: %v", err)
+        c.JSON(500, gin.H{"error": "Login failed"})
+        return
+    }
+    response["token"] = token
+    response["expires_at"] = expiresAt
+    c.JSON(200, response)
+}
+
+func registerSessionRoutes(api *gin.RouterGroup, db *sql.DB) {
+    // Who the bearer token belongs to, with their effective permissions
+    api.GET("/me", func(c *gin.Context) {
+        caller, ok := requireCaller(c)
+        if !ok {
+            return
+        }
+        c.JSON(200, gin.H{"caller": caller})
+    })
+
+    api.POST("/logout", func(c *gin.Context) {
+        if _, ok := requireCaller(c); !ok {
+            return
+        }
+        _, err := db.Exec("UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1", c.GetInt("session_id"))
+        if err != nil {
+            log.Printf("Failed to end session: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to log out"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Logged out"})
+    })
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "database/sql"
+    "strconv"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so read helpers can run
+// inside a caller's transaction.
+type querier interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+    QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getSetting reads a value from business_settings, falling back when the row
+// is missing or empty so a partially migrated database keeps working.
+func getSetting(db querier, category string, key string, fallback string) string {
+    var value sql.NullString
+    err := db.QueryRow(`
+        SELECT setting_value FROM business_settings
+        WHERE category = $1 AND setting_key = $2
+    `, category, key).Scan(&value)
+    if err != nil || !value.Valid || value.String == "" {
+        return fallback
+    }
+    return value.String
+}
+
+func getSettingInt(db querier, category string, key string, fallback int) int {
+    value, err := strconv.Atoi(getSetting(db, category, key, ""))
+    if err != nil {
+        return fallback
+    }
+    return value
+}
+
+func getSettingBool(db querier, category string, key string, fallback bool) bool {
+    value, err := strconv.ParseBool(getSetting(db, category, key, ""))
+    if err != nil {
+        return fallback
+    }
+    return value
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+    "crypto/subtle"
+    "database/sql"
+    "fmt"
+    "log"
+    "os"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+type SMSMessage struct {
+    ID            int       `json:"id" db:"id"`
+    Direction     string    `json:"direction" db:"direction"`
+    Phone         string    `json:"phone" db:"phone"`
+    UserID        *int      `json:"user_id" db:"user_id"`
+    AppointmentID *int      `json:"appointment_id" db:"appointment_id"`
+    Body          string    `json:"body" db:"body"`
+    Action        string    `json:"action" db:"action"`
+    CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+var nonDigits = regexp.MustCompile(`\D`)
+
+// phoneKey reduces a phone number to its last ten digits so "+1 (561)
+// 812-3931" and "5618123931" match.
+func phoneKey(phone string) string {
+    digits := nonDigits.ReplaceAllString(phone, "")
+    if len(digits) > 10 {
+        digits = digits[len(digits)-10:]
+    }
+    return digits
+}
+
+func logSMSMessage(db *sql.DB, direction string, phone string, userID *int, appointmentID *int, body string, action string) {
+    _, err := db.Exec(`
+        INSERT INTO sms_messages (direction, phone, user_id, appointment_id, body, action, created_at)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), CURRENT_TIMESTAMP)
+    `, direction, phone, userID, appointmentID, body, action)
+    if err != nil {
+        log.Printf("Failed to log SMS message: %v", err)
+    }
+}
+
+// sendConfirmationRequests texts customers with a booking coming up, asking
+// them to reply C or R. Bookings are confirmed on our side when they're
+// made, so this goes by whether we've asked rather than by status. Claiming
+// the appointment's confirmation_requested_at first keeps it to one text per
+// booking.
+func sendConfirmationRequests(db *sql.DB) {
+    if !getSettingBool(db, "notifications", "sms_notifications", false) {
+        return
+    }
+
+    loc := businessLocation(db)
+    now := time.Now()
+    window := time.Duration(getSettingInt(db, "sms", "confirmation_hours_before", 24)) * time.Hour
+
+    rows, err := db.Query(`
+        SELECT a.id, to_char(a.appointment_date, 'YYYY-MM-DD'), to_char(a.appointment_time, 'HH24:MI')
+        FROM appointments a
+        JOIN users u ON a.user_id = u.id
+        LEFT JOIN notification_preferences np ON np.user_id = a.user_id
+        WHERE a.status IN ('pending', 'confirmed') AND a.confirmation_requested_at IS NULL
+          AND a.appointment_date BETWEEN $1 AND $2
+          AND COALESCE(u.phone, '') <> ''
+          AND COALESCE(np.sms_notifications, FALSE) AND COALESCE(np.appointment_reminders, TRUE)
+    `, now.In(loc).Format(dateLayout), now.In(loc).Add(window+24*time.Hour).Format(dateLayout))
+    if err != nil {
+        log.Printf("Failed to fetch appointments needing confirmation: %v", err)
+        return
+    }
+
+    var due []int
+    for rows.Next() {
+        var id int
+        var date, clock string
+        if err := rows.Scan(&id, &date, &clock); err != nil {
+            continue
+        }
+        startsAt, err := appointmentStart(loc, date, clock)
+        if err == nil && startsAt.After(now) && startsAt.Sub(now) <= window {
+            due = append(due, id)
+        }
+    }
+    rows.Close()
+
+    for _, id := range due {
+        result, err := db.Exec(`
+            UPDATE appointments SET confirmation_requested_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND confirmation_requested_at IS NULL
+        `, id)
+        if err != nil {
+            log.Printf("Failed to claim confirmation request for appointment %d: %v", id, err)
+            continue
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            continue
+        }
+        notifier.NotifyAppointment(id, "appointment_confirmation_request", nil)
+    }
+}
+
+// nextAppointmentForUser is the customer's soonest live booking that hasn't
+// started yet, which is what a bare "C" or "R" reply refers to.
+func nextAppointmentForUser(db *sql.DB, userID int) (*Appointment, error) {
+    loc := businessLocation(db)
+    rows, err := db.Query(`
+        SELECT id, to_char(appointment_date, 'YYYY-MM-DD'), to_char(appointment_time, 'HH24:MI')
+        FROM appointments
+        WHERE user_id = $1 AND status IN ('pending', 'confirmed') AND appointment_date >= $2
+        ORDER BY appointment_date, appointment_time
+    `, userID, businessToday(db))
+    if err != nil {
+        return nil, err
+    }
+
+    var nextID int
+    now := time.Now()
+    for rows.Next() {
+        var id int
+        var date, clock string
+        if err := rows.Scan(&id, &date, &clock); err != nil {
+            continue
+        }
+        if startsAt, err := appointmentStart(loc, date, clock); err == nil && startsAt.After(now) {
+            nextID = id
+            break
+        }
+    }
+    rows.Close()
+
+    if nextID == 0 {
+        return nil, nil
+    }
+    apt, err := loadAppointment(db, nextID)
+    if err != nil {
+        return nil, err
+    }
+    return &apt, nil
+}
+
+// recordConfirmationReply stores the customer's answer to a confirmation
+// text.
+func recordConfirmationReply(db *sql.DB, appointmentID int, reply string) error {
+    _, err := db.Exec(`
+        UPDATE appointments
+        SET confirmation_reply = $2, confirmation_replied_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `, appointmentID, reply)
+    return err
+}
+
+// handleSMSReply works out what a customer's text means and acts on it.
+// It returns the reply to send back and a short action label for the log.
+func handleSMSReply(db *sql.DB, userID int, apt *Appointment, body string) (string, string) {
+    phone := getSetting(db, "business", "phone", "")
+    if apt == nil {
+        return fmt.Sprintf("We couldn't find an upcoming appointment for this number. Questions? Call us at %s.", phone), "no_appointment"
+    }
+
+    when := apt.AppointmentDate + " " + apt.AppointmentTime
+    if apt.StartsAt != nil {
+        when = apt.StartsAt.Format("Mon Jan 2 at 3:04 PM")
+    }
+
+    words := strings.Fields(strings.ToUpper(body))
+    command := ""
+    if len(words) > 0 {
+        command = strings.Trim(words[0], ".!")
+    }
+
+    switch command {
+    case "C", "CONFIRM", "YES", "Y":
+        if err := recordConfirmationReply(db, apt.ID, "confirmed"); err != nil {
+            log.Printf("Failed to record SMS confirmation for appointment %d: %v", apt.ID, err)
+            return fmt.Sprintf("Sorry, something went wrong. Please call us at %s to confirm.", phone), "error"
+        }
+        if apt.Status != "confirmed" {
+            if _, err := updateAppointmentStatus(db, apt.ID, "confirmed", &userID, "Confirmed by SMS reply"); err != nil {
+                log.Printf("Failed to confirm appointment %d by SMS: %v", apt.ID, err)
+                return fmt.Sprintf("Sorry, something went wrong. Please call us at %s to confirm.", phone), "error"
+            }
+        }
+        return fmt.Sprintf("Thanks! %s's %s on %s is confirmed. See you then!", apt.PetName, apt.ServiceName, when), "confirmed"
+
+    case "R", "RESCHEDULE":
+        _, err := db.Exec(`
+            UPDATE appointments
+            SET needs_followup = TRUE, followup_reason = 'Customer asked by SMS to reschedule',
+                confirmation_reply = 'reschedule_requested', confirmation_replied_at = CURRENT_TIMESTAMP,
+                updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, apt.ID)
+        if err != nil {
+            log.Printf("Failed to flag appointment %d for rescheduling: %v", apt.ID, err)
+        }
+        if updated, err := loadAppointment(db, apt.ID); err == nil {
+            broadcastAppointmentUpdate(updated, "needs_followup")
+        }
+        return fmt.Sprintf("No problem! We'll reach out to find a new time for %s, or you can reschedule anytime in the app.", apt.PetName), "reschedule_requested"
+    }
+
+    return fmt.Sprintf("Reply C to confirm or R to reschedule %s's appointment on %s. Questions? Call us at %s.", apt.PetName, when, phone), "help"
+}
+
+func registerSMSRoutes(api *gin.RouterGroup, admin *gin.RouterGroup, db *sql.DB) {
+    // Provider webhook for texts customers send us
+    api.POST("/sms/inbound", func(c *gin.Context) {
+        // Without a shared secret anyone could post texts that confirm or
+        // cancel other people's bookings, so refuse until one is configured
+        secret := os.Getenv("SMS_WEBHOOK_SECRET")
+        if secret == "" {
+            log.Printf("Rejected inbound SMS: SMS_WEBHOOK_SECRET is not set")
+            c.JSON(503, gin.H{"error": "Inbound SMS is not configured"})
+            return
+        }
+        token := c.GetHeader("X-Webhook-Secret")
+        if token == "" {
+            token = c.Query("token")
+        }
+        if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+            c.JSON(401, gin.H{"error": "Invalid webhook secret"})
+            return
+        }
+
+        msg, err := notifier.sms.ParseInbound(c.Request)
+        if err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        var userID *int
+        var id int
+        err = db.QueryRow(`
+            SELECT id FROM users
+            WHERE RIGHT(regexp_replace(COALESCE(phone, ''), '\D', '', 'g'), 10) = $1
+            ORDER BY id LIMIT 1
+        `, phoneKey(msg.From)).Scan(&id)
+        if err == nil {
+            userID = &id
+        }
+
+        var apt *Appointment
+        if userID != nil {
+            apt, err = nextAppointmentForUser(db, *userID)
+            if err != nil {
+                log.Printf("Failed to find next appointment for SMS reply: %v", err)
+            }
+        }
+        var appointmentID *int
+        if apt != nil {
+            appointmentID = &apt.ID
+        }
+        logSMSMessage(db, "inbound", msg.From, userID, appointmentID, msg.Body, "")
+
+        var reply, action string
+        if userID == nil {
+            reply = fmt.Sprintf("We don't recognize this number. Please call us at %s.", getSetting(db, "business", "phone", ""))
+            action = "unknown_sender"
+        } else {
+            reply, action = handleSMSReply(db, *userID, apt, msg.Body)
+        }
+
+        if err := notifier.sms.SendSMS(msg.From, reply); err != nil {
+            log.Printf("Failed to send SMS reply: %v", err)
+        }
+        logSMSMessage(db, "outbound", msg.From, userID, appointmentID, reply, action)
+
+        c.JSON(200, gin.H{"action": action, "reply": reply})
+    })
+
+    // Conversation log for staff
+    admin.GET("/sms/messages", func(c *gin.Context) {
+        if !requirePermission(c, "customer_management", "customer_service") {
+            return
+        }
+        query := `
+            SELECT id, direction, phone, user_id, appointment_id, body, COALESCE(action, ''), created_at
+            FROM sms_messages`
+        args := []interface{}{}
+        if userID := c.Query("user_id"); userID != "" {
+            query += " WHERE user_id = $1"
+            args = append(args, userID)
+        } else if phone := c.Query("phone"); phone != "" {
+            query += " WHERE RIGHT(regexp_replace(phone, '\\D', '', 'g'), 10) = $1"
+            args = append(args, phoneKey(phone))
+        }
+        query += " ORDER BY created_at DESC, id DESC LIMIT 200"
+
+        rows, err := db.Query(query, args...)
+        if err != nil {
+            log.Printf("Failed to fetch SMS messages: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch SMS messages"})
+            return
+        }
+        defer rows.Close()
+
+        messages := []SMSMessage{}
+        for rows.Next() {
+            var m SMSMessage
+            if err := rows.Scan(&m.ID, &m.Direction, &m.Phone, &m.UserID, &m.AppointmentID, &m.Body, &m.Action, &m.CreatedAt); err == nil {
+                messages = append(messages, m)
+            }
+        }
+
+        c.JSON(200, gin.H{"messages": messages})
+    })
+}
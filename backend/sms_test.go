@@ -0,0 +1,221 @@
+package main
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// recordingSMSProvider is the logging fake that also keeps what it sent.
+type recordingSMSProvider struct {
+    logSMSProvider
+    mu   sync.Mutex
+    sent []InboundSMS // From is the recipient here
+}
+
+func (p *recordingSMSProvider) SendSMS(to string, body string) error {
+    p.logSMSProvider.SendSMS(to, body)
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.sent = append(p.sent, InboundSMS{From: to, Body: body})
+    return nil
+}
+
+var testHubOnce sync.Once
+
+// startTestHub gives handlers that broadcast a hub with nobody listening.
+func startTestHub() {
+    testHubOnce.Do(func() {
+        hub = &Hub{
+            broadcast:  make(chan []byte),
+            direct:     make(chan userMessage),
+            register:   make(chan *Client),
+            unregister: make(chan *Client),
+            clients:    make(map[*Client]bool),
+        }
+        go hub.run()
+    })
+}
+
+// useTestNotifier points the global notifier at db with a recording SMS
+// provider.
+func useTestNotifier(t *testing.T, db *sql.DB) *recordingSMSProvider {
+    t.Helper()
+    sms := &recordingSMSProvider{}
+    n := newNotifier(db)
+    n.sms = sms
+    n.channels["sms"] = smsChannel{db: db, provider: sms}
+    previous := notifier
+    notifier = n
+    t.Cleanup(func() { notifier = previous })
+    return sms
+}
+
+// scriptSettings answers business_settings lookups from values, keyed
+// "category.key"; anything else falls back to the caller's default.
+func scriptSettings(f *fakeDB, values map[string]string) {
+    f.on("FROM business_settings WHERE category = $1 AND setting_key = $2", []string{"setting_value"}, func(args []driver.Value) [][]driver.Value {
+        if value, ok := values[fmt.Sprint(args[0])+"."+fmt.Sprint(args[1])]; ok {
+            return row(value)
+        }
+        return nil
+    })
+}
+
+const customerPhone = "+1 (561) 555-0142"
+
+// scriptUpcomingBooking sets up customer 7 with a booking tomorrow in the
+// given status.
+func scriptUpcomingBooking(f *fakeDB, status string) {
+    tomorrow := time.Now().Add(24 * time.Hour).Format(dateLayout)
+    f.on("SELECT id FROM users WHERE RIGHT(regexp_replace", []string{"id"}, func(args []driver.Value) [][]driver.Value {
+        if args[0] == phoneKey(customerPhone) {
+            return row(int64(7))
+        }
+        return nil
+    })
+    f.on("FROM appointments WHERE user_id = $1 AND status IN ('pending', 'confirmed')", []string{"id", "date", "time"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(200), tomorrow, "10:00")
+    })
+    f.on("a.status, COALESCE(a.notes, ''), a.created_at", []string{"id", "user_id", "pet_id", "service_id", "appointment_date", "appointment_time",
+        "status", "notes", "created_at", "payment_id", "groomer_id", "pet_name", "service_name", "service_type"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(200), int64(7), int64(100), int64(1), tomorrow, "10:00", status, "", time.Now(), nil, nil, "Bella", "Full Groom", "grooming")
+    })
+    f.onExec("INSERT INTO sms_messages", func(args []driver.Value) (int64, error) { return 1, nil })
+    f.onExec("UPDATE appointments", func(args []driver.Value) (int64, error) { return 1, nil })
+}
+
+func postInboundSMS(t *testing.T, db *sql.DB, body string) *httptest.ResponseRecorder {
+    t.Helper()
+    t.Setenv("SMS_WEBHOOK_SECRET", "webhook-secret")
+    gin.SetMode(gin.TestMode)
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodPost, "/api/v1/sms/inbound", strings.NewReader(fmt.Sprintf(`{"from": %q, "body": %q}`, customerPhone, body)))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Webhook-Secret", "webhook-secret")
+    newRouter(db).ServeHTTP(w, req)
+    return w
+}
+
+func TestSMSConfirmReplyOnConfirmedBooking(t *testing.T) {
+    db, f := newFakeDB(t)
+    scriptSettings(f, map[string]string{"business.phone": "561-555-0100"})
+    scriptUpcomingBooking(f, "confirmed")
+    sms := useTestNotifier(t, db)
+
+    w := postInboundSMS(t, db, "c")
+    if w.Code != 200 {
+        t.Fatalf("got %d: %s", w.Code, w.Body.String())
+    }
+
+    replies := f.ran("SET confirmation_reply = $2")
+    if len(replies) != 1 || replies[0].args[0] != int64(200) || replies[0].args[1] != "confirmed" {
+        t.Errorf("reply not recorded on the booking: %v", replies)
+    }
+    if calls := f.ran("SET status = $1"); len(calls) != 0 {
+        t.Errorf("an already confirmed booking had its status changed again")
+    }
+
+    if len(sms.sent) != 1 || sms.sent[0].From != customerPhone || !strings.Contains(sms.sent[0].Body, "is confirmed") {
+        t.Errorf("unexpected texts sent: %v", sms.sent)
+    }
+    logged := f.ran("INSERT INTO sms_messages")
+    if len(logged) != 2 || logged[0].args[0] != "inbound" || logged[1].args[0] != "outbound" || logged[1].args[5] != "confirmed" {
+        t.Errorf("conversation not logged: %v", logged)
+    }
+}
+
+func TestSMSConfirmReplyConfirmsPendingBooking(t *testing.T) {
+    db, f := newFakeDB(t)
+    startTestHub()
+    scriptSettings(f, nil)
+    scriptUpcomingBooking(f, "pending")
+    useTestNotifier(t, db)
+    f.onExec("SELECT set_config", func(args []driver.Value) (int64, error) { return 1, nil })
+
+    if w := postInboundSMS(t, db, "Yes!"); w.Code != 200 {
+        t.Fatalf("got %d: %s", w.Code, w.Body.String())
+    }
+    calls := f.ran("SET status = $1")
+    if len(calls) != 1 || calls[0].args[0] != "confirmed" {
+        t.Errorf("pending booking not confirmed through updateAppointmentStatus: %v", calls)
+    }
+    if len(f.ran("SET confirmation_reply = $2")) != 1 {
+        t.Errorf("reply not recorded on the booking")
+    }
+}
+
+func TestSMSRescheduleReply(t *testing.T) {
+    db, f := newFakeDB(t)
+    startTestHub()
+    scriptSettings(f, nil)
+    scriptUpcomingBooking(f, "confirmed")
+    sms := useTestNotifier(t, db)
+
+    if w := postInboundSMS(t, db, "R"); w.Code != 200 {
+        t.Fatalf("got %d: %s", w.Code, w.Body.String())
+    }
+    if len(f.ran("confirmation_reply = 'reschedule_requested'")) != 1 {
+        t.Errorf("reschedule request not recorded on the booking")
+    }
+    if len(sms.sent) != 1 || !strings.Contains(sms.sent[0].Body, "new time") {
+        t.Errorf("unexpected texts sent: %v", sms.sent)
+    }
+}
+
+func TestConfirmationRequestsGoToConfirmedBookings(t *testing.T) {
+    db, f := newFakeDB(t)
+    scriptSettings(f, map[string]string{"notifications.sms_notifications": "true"})
+    sms := useTestNotifier(t, db)
+
+    startsAt := time.Now().Add(3 * time.Hour)
+    date, clock := startsAt.Format(dateLayout), startsAt.Format("15:04")
+    f.on("WHERE a.status IN ('pending', 'confirmed') AND a.confirmation_requested_at IS NULL", []string{"id", "date", "time"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(200), date, clock)
+    })
+    f.onExec("UPDATE appointments SET confirmation_requested_at", func(args []driver.Value) (int64, error) { return 1, nil })
+    f.on("COALESCE(a.followup_reason, '')", []string{"user_id", "pet", "service", "date", "time", "reason"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(7), "Bella", "Full Groom", date, clock, "")
+    })
+    f.on("LEFT JOIN notification_preferences np ON np.user_id = u.id", []string{"name", "email", "phone", "email_on", "sms_on", "push_on", "reminders", "status"}, func(args []driver.Value) [][]driver.Value {
+        return row("Sam", "sam@example.com", customerPhone, true, true, true, true, true)
+    })
+    var queued []driver.Value
+    f.onExec("INSERT INTO notification_outbox", func(args []driver.Value) (int64, error) {
+        queued = args
+        return 1, nil
+    })
+
+    sendConfirmationRequests(db)
+
+    if len(f.ran("UPDATE appointments SET confirmation_requested_at")) != 1 {
+        t.Fatalf("confirmed booking was not picked up")
+    }
+    if queued == nil || queued[1] != "appointment_confirmation_request" || queued[2] != "sms" {
+        t.Fatalf("confirmation request not queued for SMS: %v", queued)
+    }
+
+    // Deliver it through the fake provider
+    f.on("UPDATE notification_outbox SET status = 'sending'", []string{"id", "user_id", "event_type", "channel", "subject", "body", "data", "attempts"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(1), int64(7), queued[1], queued[2], queued[3], queued[4], queued[5], int64(1))
+    })
+    f.onExec("INSERT INTO notification_deliveries", func(args []driver.Value) (int64, error) { return 1, nil })
+    f.onExec("UPDATE notification_outbox SET status = 'sent'", func(args []driver.Value) (int64, error) { return 1, nil })
+    f.onExec("INSERT INTO sms_messages", func(args []driver.Value) (int64, error) { return 1, nil })
+    notifier.processOutbox()
+
+    if len(sms.sent) != 1 || sms.sent[0].From != customerPhone || !strings.Contains(sms.sent[0].Body, "Reply C to confirm") {
+        t.Errorf("unexpected texts sent: %v", sms.sent)
+    }
+    if logged := f.ran("INSERT INTO sms_messages"); len(logged) != 1 || logged[0].args[0] != "outbound" {
+        t.Errorf("outbound text not logged: %v", logged)
+    }
+}
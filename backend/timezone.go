@@ -0,0 +1,107 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "strings"
+    "sync"
+    "time"
+
+    // Embedded so the business timezone resolves even on images without /usr/share/zoneinfo
+    _ "time/tzdata"
+)
+
+var (
+    locationMu    sync.Mutex
+    locationCache = map[string]*time.Location{}
+)
+
+// businessLocation is the shop's timezone from the business.timezone setting.
+// Appointment dates and times are wall-clock values in this zone, so anything
+// that asks "what day is it" or "has this started" must go through it rather
+// than the server's local zone.
+func businessLocation(db *sql.DB) *time.Location {
+    name := getSetting(db, "business", "timezone", "")
+    if name == "" {
+        return time.Local
+    }
+
+    locationMu.Lock()
+    defer locationMu.Unlock()
+    if loc, ok := locationCache[name]; ok {
+        return loc
+    }
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        log.Printf("Invalid business timezone %q, using server time: %v", name, err)
+        loc = time.Local
+    }
+    locationCache[name] = loc
+    return loc
+}
+
+func businessNow(db *sql.DB) time.Time {
+    return time.Now().In(businessLocation(db))
+}
+
+// businessToday is the current calendar date at the shop as YYYY-MM-DD.
+func businessToday(db *sql.DB) string {
+    return businessDate(time.Now(), businessLocation(db))
+}
+
+// businessDate is the calendar date at the shop at instant now.
+func businessDate(now time.Time, loc *time.Location) string {
+    return now.In(loc).Format(dateLayout)
+}
+
+// appointmentStart turns a stored DATE and TIME into an instant in loc.
+// A wall time skipped by a spring-forward transition lands just after the
+// gap, and an ambiguous fall-back time resolves to its first occurrence.
+func appointmentStart(loc *time.Location, date string, clock string) (time.Time, error) {
+    if len(date) > len(dateLayout) {
+        date = date[:len(dateLayout)]
+    }
+    day, err := time.Parse(dateLayout, date)
+    if err != nil {
+        return time.Time{}, err
+    }
+    start, err := parseClock(clock)
+    if err != nil {
+        return time.Time{}, err
+    }
+    t := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+
+    // time.Date resolves a wall time inside a spring-forward gap with the
+    // offset from after the transition, so 02:30 reads back as 01:30 on the
+    // old offset; push it forward by what went missing
+    if t.Hour() != start.Hour() || t.Minute() != start.Minute() {
+        wanted := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+        got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+        t = t.Add(wanted.Sub(got))
+    }
+    return t, nil
+}
+
+// setStartsAt fills in the offset-qualified start time for API responses.
+func (apt *Appointment) setStartsAt(loc *time.Location) {
+    if start, err := appointmentStart(loc, apt.AppointmentDate, apt.AppointmentTime); err == nil {
+        apt.StartsAt = &start
+    }
+}
+
+// withUTCSession pins the Postgres session timezone to UTC. CURRENT_TIMESTAMP
+// values land in TIMESTAMP columns in the session zone and lib/pq reads them
+// back as UTC, so the two have to agree for created_at and friends to carry
+// the right offset.
+func withUTCSession(dsn string) string {
+    if strings.Contains(dsn, "timezone=") {
+        return dsn
+    }
+    if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+        if strings.Contains(dsn, "?") {
+            return dsn + "&timezone=UTC"
+        }
+        return dsn + "?timezone=UTC"
+    }
+    return dsn + " timezone=UTC"
+}
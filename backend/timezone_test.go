@@ -0,0 +1,89 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+    t.Helper()
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        t.Fatalf("LoadLocation(%q): %v", name, err)
+    }
+    return loc
+}
+
+func TestAppointmentStartAcrossDST(t *testing.T) {
+    loc := mustLoadLocation(t, "America/New_York")
+
+    tests := []struct {
+        name     string
+        date     string
+        clock    string
+        wantWall string
+        wantUTC  string
+        wantZone string
+    }{
+        {"ordinary day", "2026-06-15", "10:00", "10:00", "14:00", "EDT"},
+        // Clocks jump from 02:00 to 03:00, so 02:30 never happens
+        {"spring-forward gap", "2026-03-08", "02:30", "03:30", "07:30", "EDT"},
+        {"just before the gap", "2026-03-08", "01:30", "01:30", "06:30", "EST"},
+        {"just after the gap", "2026-03-08", "03:00", "03:00", "07:00", "EDT"},
+        // Clocks fall back from 02:00 to 01:00, so 01:30 happens twice; take the first
+        {"fall-back overlap", "2026-11-01", "01:30", "01:30", "05:30", "EDT"},
+        {"after the overlap", "2026-11-01", "02:30", "02:30", "07:30", "EST"},
+        {"timestamp-shaped date", "2026-03-08T00:00:00Z", "02:30:00", "03:30", "07:30", "EDT"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := appointmentStart(loc, tt.date, tt.clock)
+            if err != nil {
+                t.Fatalf("appointmentStart(%q, %q): %v", tt.date, tt.clock, err)
+            }
+            if wall := got.Format("15:04"); wall != tt.wantWall {
+                t.Errorf("wall time = %s, want %s", wall, tt.wantWall)
+            }
+            if utc := got.UTC().Format("15:04"); utc != tt.wantUTC {
+                t.Errorf("UTC time = %s, want %s", utc, tt.wantUTC)
+            }
+            if zone, _ := got.Zone(); zone != tt.wantZone {
+                t.Errorf("zone = %s, want %s", zone, tt.wantZone)
+            }
+        })
+    }
+}
+
+func TestAppointmentStartRejectsBadInput(t *testing.T) {
+    loc := mustLoadLocation(t, "America/New_York")
+    if _, err := appointmentStart(loc, "2026-13-01", "10:00"); err == nil {
+        t.Error("expected an error for an invalid date")
+    }
+    if _, err := appointmentStart(loc, "2026-06-15", "25:00"); err == nil {
+        t.Error("expected an error for an invalid time")
+    }
+}
+
+func TestBusinessDateNearMidnightUTC(t *testing.T) {
+    tests := []struct {
+        name string
+        zone string
+        now  time.Time
+        want string
+    }{
+        // Evening on the US west coast is already tomorrow in UTC
+        {"west of UTC, after UTC midnight", "America/Los_Angeles", time.Date(2026, 7, 1, 3, 30, 0, 0, time.UTC), "2026-06-30"},
+        {"west of UTC, before UTC midnight", "America/Los_Angeles", time.Date(2026, 6, 30, 23, 30, 0, 0, time.UTC), "2026-06-30"},
+        // Morning in Sydney is still yesterday in UTC
+        {"east of UTC, before UTC midnight", "Australia/Sydney", time.Date(2026, 6, 30, 23, 30, 0, 0, time.UTC), "2026-07-01"},
+        {"UTC itself", "UTC", time.Date(2026, 6, 30, 23, 59, 0, 0, time.UTC), "2026-06-30"},
+        {"new year in New York", "America/New_York", time.Date(2027, 1, 1, 4, 59, 0, 0, time.UTC), "2026-12-31"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := businessDate(tt.now, mustLoadLocation(t, tt.zone)); got != tt.want {
+                t.Errorf("businessDate(%s, %s) = %s, want %s", tt.now.Format(time.RFC3339), tt.zone, got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/subtle"
+    "database/sql"
+    "encoding/base32"
+    "encoding/binary"
+    "fmt"
+    "log"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    tokenLoginChallenge = "login_challenge"
+
+    totpPeriod        = 30 // seconds per step (RFC 6238 default)
+    totpDigits        = 6
+    totpSkew          = 1 // steps either side accepted for clock drift
+    recoveryCodeCount = 10
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorRequest struct {
+    ChallengeToken string `json:"challenge_token" binding:"required"`
+    Code           string `json:"code"`
+    RecoveryCode   string `json:"recovery_code"`
+}
+
+type TOTPCodeRequest struct {
+    Code string `json:"code" binding:"required"`
+}
+
+// totpCode computes the RFC 6238 code for a time step (HOTP over the step
+// counter, RFC 4226 dynamic truncation).
+func totpCode(secret []byte, step int64) string {
+    var counter [8]byte
+    binary.BigEndian.PutUint64(counter[:], uint64(step))
+    mac := hmac.New(sha1.New, secret)
+    mac.Write(counter[:])
+    sum := mac.Sum(nil)
+
+    offset := sum[len(sum)-1] & 0x0f
+    value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+    return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the step a code is valid for, allowing for a little
+// clock drift, or -1.
+func matchTOTP(secret []byte, code string, now time.Time) int64 {
+    code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+    if len(code) != totpDigits {
+        return -1
+    }
+    current := now.Unix() / totpPeriod
+    for step := current - totpSkew; step <= current+totpSkew; step++ {
+        if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+            return step
+        }
+    }
+    return -1
+}
+
+func generateTOTPSecret() (string, error) {
+    raw := make([]byte, 20)
+    if _, err := rand.Read(raw); err != nil {
+        return "", err
+    }
+    return base32NoPadding.EncodeToString(raw), nil
+}
+
+// totpURI is the otpauth:// link authenticator apps read from a QR code.
+func totpURI(issuer string, account string, secret string) string {
+    label := url.PathEscape(issuer + ":" + account)
+    params := url.Values{}
+    params.Set("secret", secret)
+    params.Set("issuer", issuer)
+    params.Set("algorithm", "SHA1")
+    params.Set("digits", fmt.Sprintf("%d", totpDigits))
+    params.Set("period", fmt.Sprintf("%d", totpPeriod))
+    return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// isStaffRole reports whether a role is one of the admin roles.
+func isStaffRole(db *sql.DB, role string) bool {
+    if role == "" || role == "customer" {
+        return false
+    }
+    var exists bool
+    db.QueryRow("SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists)
+    return exists
+}
+
+func totpEnrolled(db *sql.DB, userID int) bool {
+    var enrolled bool
+    db.QueryRow("SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)", userID).Scan(&enrolled)
+    return enrolled
+}
+
+// requiresTwoFactor: anyone who has enrolled always gets challenged, and
+// staff must enroll while security.two_factor_auth is on.
+func requiresTwoFactor(db *sql.DB, userID int, role string) bool {
+    if totpEnrolled(db, userID) {
+        return true
+    }
+    return isStaffRole(db, role) && getSettingBool(db, "security", "two_factor_auth", false)
+}
+
+// startTOTPEnrollment stores a new unconfirmed secret, replacing any earlier
+// unconfirmed one, and returns it with its otpauth URI.
+func startTOTPEnrollment(db *sql.DB, userID int) (string, string, error) {
+    if totpEnrolled(db, userID) {
+        return "", "", fmt.Errorf("two-factor authentication is already enabled")
+    }
+    secret, err := generateTOTPSecret()
+    if err != nil {
+        return "", "", err
+    }
+    var email string
+    if err := db.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&email); err != nil {
+        return "", "", err
+    }
+    _, err = db.Exec(`
+        INSERT INTO user_totp (user_id, secret, created_at)
+        VALUES ($1, $2, CURRENT_TIMESTAMP)
+        ON CONFLICT (user_id) DO UPDATE SET secret = $2, confirmed_at = NULL, last_used_step = NULL, created_at = CURRENT_TIMESTAMP
+    `, userID, secret)
+    if err != nil {
+        return "", "", err
+    }
+    issuer := getSetting(db, "business", "business_name", "Jake's Bath House")
+    return secret, totpURI(issuer, email, secret), nil
+}
+
+// verifyTOTP checks a code against the user's secret, enrolled or pending.
+// Each step is accepted once so a shoulder-surfed code can't be replayed.
+func verifyTOTP(db *sql.DB, userID int, code string) bool {
+    var secret string
+    var lastStep sql.NullInt64
+    err := db.QueryRow("SELECT secret, last_used_step FROM user_totp WHERE user_id = $1", userID).Scan(&secret, &lastStep)
+    if err != nil {
+        return false
+    }
+    key, err := base32NoPadding.DecodeString(secret)
+    if err != nil {
+        return false
+    }
+    step := matchTOTP(key, code, time.Now())
+    if step < 0 || (lastStep.Valid && step <= lastStep.Int64) {
+        return false
+    }
+    result, err := db.Exec(`
+        UPDATE user_totp SET last_used_step = $2
+        WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)
+    `, userID, step)
+    if err != nil {
+        return false
+    }
+    n, _ := result.RowsAffected()
+    return n == 1
+}
+
+// issueRecoveryCodes replaces the user's recovery codes and returns the
+// plaintext set, which is shown exactly once.
+func issueRecoveryCodes(db *sql.DB, userID int) ([]string, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = $1", userID); err != nil {
+        return nil, err
+    }
+
+    codes := make([]string, 0, recoveryCodeCount)
+    for i := 0; i < recoveryCodeCount; i++ {
+        raw := make([]byte, 5)
+        if _, err := rand.Read(raw); err != nil {
+            return nil, err
+        }
+        encoded := strings.ToLower(base32NoPadding.EncodeToString(raw))
+        code := encoded[:4] + "-" + encoded[4:]
+        if _, err := tx.Exec(`
+            INSERT INTO totp_recovery_codes (user_id, code_hash, created_at)
+            VALUES ($1, $2, CURRENT_TIMESTAMP)
+        `, userID, hashToken(code)); err != nil {
+            return nil, err
+        }
+        codes = append(codes, code)
+    }
+    return codes, tx.Commit()
+}
+
+func useRecoveryCode(db *sql.DB, userID int, code string) bool {
+    code = strings.ToLower(strings.TrimSpace(code))
+    result, err := db.Exec(`
+        UPDATE totp_recovery_codes SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+    `, userID, hashToken(code))
+    if err != nil {
+        return false
+    }
+    n, _ := result.RowsAffected()
+    return n == 1
+}
+
+// confirmTOTPEnrollment switches a pending secret on after a valid code and
+// hands back the first set of recovery codes.
+func confirmTOTPEnrollment(db *sql.DB, userID int) ([]string, error) {
+    _, err := db.Exec("UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND confirmed_at IS NULL", userID)
+    if err != nil {
+        return nil, err
+    }
+    return issueRecoveryCodes(db, userID)
+}
+
+// completeLogin finishes a sign-in: stamps last_login and returns the user.
+func completeLogin(db *sql.DB, userID int) (User, error) {
+    db.Exec("UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = $1", userID)
+
+    var user User
+    err := db.QueryRow(`
+        SELECT id, name, email, phone, wash_count, role, status, last_login, email_verified_at, created_at
+        FROM users WHERE id = $1
+    `, userID).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.WashCount, &user.Role, &user.Status,
+        &user.LastLogin, &user.EmailVerifiedAt, &user.CreatedAt)
+    return user, err
+}
+
+func registerTwoFactorRoutes(api *gin.RouterGroup, db *sql.DB) {
+    // During an enrollment-required login: fetch a secret to scan
+    api.POST("/login/2fa/setup", func(c *gin.Context) {
+        var req struct {
+            ChallengeToken string `json:"challenge_token" binding:"required"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        // Peek without consuming; the code step redeems the challenge
+        var userID int
+        err := db.QueryRow(`
+            SELECT user_id FROM account_tokens
+            WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+        `, hashToken(req.ChallengeToken), tokenLoginChallenge).Scan(&userID)
+        if err != nil {
+            c.JSON(401, gin.H{"error": "Login challenge is invalid or has expired"})
+            return
+        }
+
+        secret, uri, err := startTOTPEnrollment(db, userID)
+        if err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(200, gin.H{"secret": secret, "otpauth_uri": uri})
+    })
+
+    // Second step of login: TOTP code or recovery code
+    api.POST("/login/2fa", func(c *gin.Context) {
+        var req TwoFactorRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if req.Code == "" && req.RecoveryCode == "" {
+            c.JSON(400, gin.H{"error": "code or recovery_code is required"})
+            return
+        }
+
+        var userID int
+        err := db.QueryRow(`
+            SELECT user_id FROM account_tokens
+            WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+        `, hashToken(req.ChallengeToken), tokenLoginChallenge).Scan(&userID)
+        if err != nil {
+            c.JSON(401, gin.H{"error": "Login challenge is invalid or has expired"})
+            return
+        }
+
+        var email string
+        db.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&email)
+        if !checkLoginThrottle(c, db, email) {
+            return
+        }
+
+        enrolled := totpEnrolled(db, userID)
+        ok := false
+        if req.Code != "" {
+            ok = verifyTOTP(db, userID, req.Code)
+        } else if enrolled {
+            ok = useRecoveryCode(db, userID, req.RecoveryCode)
+        }
+        if !ok {
+            remaining := recordLoginFailure(c, db, email, &userID)
+            c.JSON(401, gin.H{"error": "Invalid verification code", "attempts_remaining": remaining})
+            return
+        }
+
+        // The challenge is single use
+        if _, err := redeemAccountToken(db, req.ChallengeToken, tokenLoginChallenge); err != nil {
+            c.JSON(401, gin.H{"error": "Login challenge is invalid or has expired"})
+            return
+        }
+
+        response := gin.H{"message": "Login successful"}
+        if !enrolled {
+            codes, err := confirmTOTPEnrollment(db, userID)
+            if err != nil {
+                log.Printf("Failed to confirm two-factor enrollment: %v", err)
+                c.JSON(500, gin.H{"error": "Failed to enable two-factor authentication"})
+                return
+            }
+            response["recovery_codes"] = codes
+        }
+
+        clearLoginFailures(db, email)
+
+        user, err := completeLogin(db, userID)
+        if err != nil {
+            log.Printf("Failed to load user after two-factor login: %v", err)
+            c.JSON(500, gin.H{"error": "Login failed"})
+            return
+        }
+        response["user"] = user
+        loginResponse(db, c, userID, response)
+    })
+
+    // Voluntary enrollment from account settings
+    api.POST("/2fa/setup", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        secret, uri, err := startTOTPEnrollment(db, userID)
+        if err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(200, gin.H{"secret": secret, "otpauth_uri": uri})
+    })
+
+    api.POST("/2fa/confirm", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        var req TOTPCodeRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if totpEnrolled(db, userID) {
+            c.JSON(400, gin.H{"error": "Two-factor authentication is already enabled"})
+            return
+        }
+        if !verifyTOTP(db, userID, req.Code) {
+            c.JSON(401, gin.H{"error": "Invalid verification code"})
+            return
+        }
+
+        codes, err := confirmTOTPEnrollment(db, userID)
+        if err != nil {
+            log.Printf("Failed to confirm two-factor enrollment: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to enable two-factor authentication"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+    })
+
+    api.POST("/2fa/recovery-codes", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        var req TOTPCodeRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if !totpEnrolled(db, userID) || !verifyTOTP(db, userID, req.Code) {
+            c.JSON(401, gin.H{"error": "Invalid verification code"})
+            return
+        }
+
+        codes, err := issueRecoveryCodes(db, userID)
+        if err != nil {
+            log.Printf("Failed to issue recovery codes: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to issue recovery codes"})
+            return
+        }
+        c.JSON(200, gin.H{"recovery_codes": codes})
+    })
+
+    api.GET("/2fa/status", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        var remaining int
+        db.QueryRow("SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&remaining)
+        c.JSON(200, gin.H{"enabled": totpEnrolled(db, userID), "recovery_codes_remaining": remaining})
+    })
+}
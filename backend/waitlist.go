@@ -0,0 +1,515 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+type WaitlistEntry struct {
+    ID          int       `json:"id" db:"id"`
+    UserID      int       `json:"user_id" db:"user_id"`
+    PetID       int       `json:"pet_id" db:"pet_id"`
+    ServiceID   int       `json:"service_id" db:"service_id"`
+    Date        string    `json:"date" db:"waitlist_date"`
+    WindowStart string    `json:"window_start" db:"window_start"`
+    WindowEnd   string    `json:"window_end" db:"window_end"`
+    Status      string    `json:"status" db:"status"`
+    Notes       string    `json:"notes" db:"notes"`
+    CreatedAt   time.Time `json:"created_at" db:"created_at"`
+
+    // Joined fields for display
+    Position    int    `json:"position,omitempty"`
+    ServiceName string `json:"service_name,omitempty"`
+    PetName     string `json:"pet_name,omitempty"`
+}
+
+type WaitlistOffer struct {
+    ID            int       `json:"id" db:"id"`
+    EntryID       int       `json:"entry_id" db:"entry_id"`
+    ServiceID     int       `json:"service_id" db:"service_id"`
+    Date          string    `json:"date" db:"offer_date"`
+    Time          string    `json:"time" db:"offer_time"`
+    Status        string    `json:"status" db:"status"`
+    AppointmentID *int      `json:"appointment_id" db:"appointment_id"`
+    ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
+}
+
+type JoinWaitlistRequest struct {
+    UserID      int    `json:"user_id" binding:"required"`
+    PetID       int    `json:"pet_id" binding:"required"`
+    ServiceID   int    `json:"service_id" binding:"required"`
+    Date        string `json:"date" binding:"required"`
+    WindowStart string `json:"window_start" binding:"required"`
+    WindowEnd   string `json:"window_end" binding:"required"`
+    Notes       string `json:"notes"`
+}
+
+// releaseAppointmentSlot offers a cancelled appointment's slot to the waitlist.
+func releaseAppointmentSlot(db *sql.DB, appointmentID int) {
+    var serviceID int
+    var date, clock string
+    err := db.QueryRow(`
+        SELECT service_id, to_char(appointment_date, 'YYYY-MM-DD'), to_char(appointment_time, 'HH24:MI')
+        FROM appointments WHERE id = $1
+    `, appointmentID).Scan(&serviceID, &date, &clock)
+    if err != nil {
+        log.Printf("Failed to load freed slot for appointment %d: %v", appointmentID, err)
+        return
+    }
+    offerFreedSlot(db, serviceID, date, clock)
+}
+
+// offerFreedSlot holds a newly free slot for the longest-waiting customer
+// whose date, service type and time window match. Customers who already saw
+// this slot are skipped so an expired or declined offer moves down the list.
+// A cancellation, the sweeper and a declined offer can all free the same slot
+// at once, so the check and the hold happen in one transaction under an
+// advisory lock on the slot.
+func offerFreedSlot(db *sql.DB, serviceID int, date string, clock string) {
+    if date < businessToday(db) {
+        return
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        log.Printf("Failed to start waitlist offer: %v", err)
+        return
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('waitlist_offer ' || $1::text || ' ' || $2::text))", date, clock); err != nil {
+        log.Printf("Failed to lock slot %s %s for the waitlist: %v", date, clock, err)
+        return
+    }
+
+    // Only one live hold per slot. Holds past expires_at don't count; the
+    // sweeper marks them expired and puts their entries back in line.
+    var held int
+    err = tx.QueryRow(`
+        SELECT COUNT(*)
+        FROM waitlist_offers o
+        JOIN services os ON o.service_id = os.id
+        WHERE o.status = 'pending' AND o.expires_at > CURRENT_TIMESTAMP
+          AND o.offer_date = $1 AND o.offer_time = $2::time
+          AND os.type = (SELECT type FROM services WHERE id = $3)
+    `, date, clock, serviceID).Scan(&held)
+    if err != nil || held > 0 {
+        return
+    }
+
+    rows, err := tx.Query(`
+        SELECT e.id, e.user_id, e.service_id
+        FROM waitlist_entries e
+        JOIN services s ON e.service_id = s.id
+        WHERE e.waitlist_date = $1 AND e.status = 'waiting'
+          AND s.type = (SELECT type FROM services WHERE id = $3)
+          AND e.window_start <= $2::time AND e.window_end >= $2::time
+          AND NOT EXISTS (
+              SELECT 1 FROM waitlist_offers o
+              WHERE o.entry_id = e.id AND o.offer_date = $1 AND o.offer_time = $2::time
+          )
+        ORDER BY e.created_at
+    `, date, clock, serviceID)
+    if err != nil {
+        log.Printf("Failed to fetch waitlist candidates: %v", err)
+        return
+    }
+
+    type candidate struct{ entryID, userID, serviceID int }
+    var candidates []candidate
+    for rows.Next() {
+        var cand candidate
+        if err := rows.Scan(&cand.entryID, &cand.userID, &cand.serviceID); err == nil {
+            candidates = append(candidates, cand)
+        }
+    }
+    rows.Close()
+
+    holdMinutes := getSettingInt(db, "scheduling", "waitlist_hold_minutes", 30)
+
+    for _, cand := range candidates {
+        // The waitlisted service may run longer than the one that was cancelled
+        reason, err := checkSlotAvailable(tx, cand.serviceID, date, clock, 0, cand.userID)
+        if err != nil || reason != "" {
+            continue
+        }
+
+        var offer WaitlistOffer
+        err = tx.QueryRow(`
+            INSERT INTO waitlist_offers (entry_id, service_id, offer_date, offer_time, status, expires_at, created_at)
+            VALUES ($1, $2, $3, $4, 'pending', CURRENT_TIMESTAMP + make_interval(mins => $5), CURRENT_TIMESTAMP)
+            RETURNING id, expires_at
+        `, cand.entryID, cand.serviceID, date, clock, holdMinutes).Scan(&offer.ID, &offer.ExpiresAt)
+        if err != nil {
+            log.Printf("Failed to create waitlist offer: %v", err)
+            return
+        }
+        offer.EntryID = cand.entryID
+        offer.ServiceID = cand.serviceID
+        offer.Date = date
+        offer.Time = clock
+        offer.Status = "pending"
+
+        if _, err := tx.Exec("UPDATE waitlist_entries SET status = 'offered' WHERE id = $1", cand.entryID); err != nil {
+            log.Printf("Failed to mark waitlist entry %d offered: %v", cand.entryID, err)
+            return
+        }
+        if err := tx.Commit(); err != nil {
+            log.Printf("Failed to create waitlist offer: %v", err)
+            return
+        }
+
+        sendToUser(cand.userID, "waitlist_offer", map[string]interface{}{
+            "offer":        offer,
+            "hold_minutes": holdMinutes,
+            "timestamp":    time.Now(),
+        })
+        notifyWaitlistOffer(db, cand.userID, cand.entryID, offer, holdMinutes)
+        log.Printf("Offered %s %s to waitlist entry %d (hold %d min)", date, clock, cand.entryID, holdMinutes)
+        return
+    }
+}
+
+func notifyWaitlistOffer(db *sql.DB, userID int, entryID int, offer WaitlistOffer, holdMinutes int) {
+    var petName, serviceName string
+    db.QueryRow(`
+        SELECT p.name, s.name FROM waitlist_entries e
+        JOIN pets p ON e.pet_id = p.id
+        JOIN services s ON s.id = $2
+        WHERE e.id = $1
+    `, entryID, offer.ServiceID).Scan(&petName, &serviceName)
+
+    data := map[string]interface{}{
+        "offer_id":     offer.ID,
+        "pet_name":     petName,
+        "service_name": serviceName,
+        "date":         offer.Date,
+        "time":         offer.Time,
+        "hold_minutes": holdMinutes,
+        "expires_at":   offer.ExpiresAt,
+    }
+    if startsAt, err := appointmentStart(businessLocation(db), offer.Date, offer.Time); err == nil {
+        data["when"] = startsAt.Format("Monday, January 2 at 3:04 PM")
+    }
+    if err := notifier.Notify(userID, "waitlist_offer", data); err != nil {
+        log.Printf("Failed to queue waitlist offer notification: %v", err)
+    }
+}
+
+// expireWaitlistOffers releases holds that ran out and passes each slot on.
+// The UPDATE ... RETURNING claims rows atomically, so running it from several
+// replicas never hands the same slot on twice.
+func expireWaitlistOffers(db *sql.DB) {
+    rows, err := db.Query(`
+        UPDATE waitlist_offers
+        SET status = 'expired', responded_at = CURRENT_TIMESTAMP
+        WHERE status = 'pending' AND expires_at < CURRENT_TIMESTAMP
+        RETURNING entry_id, service_id, to_char(offer_date, 'YYYY-MM-DD'), to_char(offer_time, 'HH24:MI')
+    `)
+    if err != nil {
+        log.Printf("Failed to expire waitlist offers: %v", err)
+        return
+    }
+
+    type expired struct {
+        entryID, serviceID int
+        date, clock        string
+    }
+    var offers []expired
+    for rows.Next() {
+        var e expired
+        if err := rows.Scan(&e.entryID, &e.serviceID, &e.date, &e.clock); err == nil {
+            offers = append(offers, e)
+        }
+    }
+    rows.Close()
+
+    for _, e := range offers {
+        db.Exec("UPDATE waitlist_entries SET status = 'waiting' WHERE id = $1 AND status = 'offered'", e.entryID)
+        offerFreedSlot(db, e.serviceID, e.date, e.clock)
+    }
+
+    // Entries for days that have passed are no longer useful
+    db.Exec(`
+        UPDATE waitlist_entries SET status = 'expired'
+        WHERE waitlist_date < $1 AND status IN ('waiting', 'offered')
+    `, businessToday(db))
+}
+
+func runWaitlistSweeper(db *sql.DB) {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        expireWaitlistOffers(db)
+    }
+}
+
+func registerWaitlistRoutes(api *gin.RouterGroup, admin *gin.RouterGroup, db *sql.DB) {
+    api.POST("/waitlist", func(c *gin.Context) {
+        var req JoinWaitlistRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if !authorizeOwner(c, req.UserID, appointmentWritePermissions...) || !requirePetOwner(c, db, req.PetID, req.UserID) {
+            return
+        }
+
+        if _, err := time.Parse(dateLayout, req.Date); err != nil {
+            c.JSON(400, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+            return
+        }
+        if req.Date < businessToday(db) {
+            c.JSON(400, gin.H{"error": "Cannot join the waitlist for a past date"})
+            return
+        }
+        start, err := parseClock(req.WindowStart)
+        if err != nil {
+            c.JSON(400, gin.H{"error": "Invalid window_start, expected HH:MM"})
+            return
+        }
+        end, err := parseClock(req.WindowEnd)
+        if err != nil || !end.After(start) {
+            c.JSON(400, gin.H{"error": "window_end must be an HH:MM time after window_start"})
+            return
+        }
+
+        var entryID int
+        err = db.QueryRow(`
+            INSERT INTO waitlist_entries (user_id, pet_id, service_id, waitlist_date, window_start, window_end, status, notes, created_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, $6, 'waiting', $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, req.UserID, req.PetID, req.ServiceID, req.Date, req.WindowStart, req.WindowEnd, req.Notes).Scan(&entryID)
+        if err != nil {
+            log.Printf("Failed to join waitlist: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to join waitlist"})
+            return
+        }
+
+        var position int
+        db.QueryRow(`
+            SELECT COUNT(*) FROM waitlist_entries
+            WHERE waitlist_date = $1 AND status IN ('waiting', 'offered') AND id <= $2
+        `, req.Date, entryID).Scan(&position)
+
+        c.JSON(201, gin.H{"message": "Added to waitlist", "entry_id": entryID, "position": position})
+    })
+
+    api.GET("/users/:id/waitlist", func(c *gin.Context) {
+        if !authorizeUserParam(c, appointmentReadPermissions...) {
+            return
+        }
+        rows, err := db.Query(`
+            SELECT e.id, e.user_id, e.pet_id, e.service_id, to_char(e.waitlist_date, 'YYYY-MM-DD'),
+                   to_char(e.window_start, 'HH24:MI'), to_char(e.window_end, 'HH24:MI'), e.status,
+                   COALESCE(e.notes, ''), e.created_at, s.name, p.name,
+                   (SELECT COUNT(*) FROM waitlist_entries w
+                    WHERE w.waitlist_date = e.waitlist_date AND w.status IN ('waiting', 'offered') AND w.created_at <= e.created_at)
+            FROM waitlist_entries e
+            JOIN services s ON e.service_id = s.id
+            JOIN pets p ON e.pet_id = p.id
+            WHERE e.user_id = $1 AND e.status IN ('waiting', 'offered')
+            ORDER BY e.waitlist_date
+        `, c.Param("id"))
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to fetch waitlist"})
+            return
+        }
+        defer rows.Close()
+
+        var entries []WaitlistEntry
+        for rows.Next() {
+            var e WaitlistEntry
+            err := rows.Scan(&e.ID, &e.UserID, &e.PetID, &e.ServiceID, &e.Date, &e.WindowStart, &e.WindowEnd,
+                &e.Status, &e.Notes, &e.CreatedAt, &e.ServiceName, &e.PetName, &e.Position)
+            if err != nil {
+                log.Printf("Error scanning waitlist entry: %v", err)
+                continue
+            }
+            entries = append(entries, e)
+        }
+
+        c.JSON(200, gin.H{"waitlist": entries})
+    })
+
+    api.DELETE("/waitlist/:id", func(c *gin.Context) {
+        if !authorizeWaitlistEntry(c, db, c.Param("id"), appointmentWritePermissions...) {
+            return
+        }
+        _, err := db.Exec(`
+            UPDATE waitlist_entries SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND status IN ('waiting', 'offered')
+        `, c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to leave waitlist: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to leave waitlist"})
+            return
+        }
+
+        // Release any slot currently held for this entry
+        var serviceID int
+        var date, clock string
+        err = db.QueryRow(`
+            UPDATE waitlist_offers SET status = 'declined', responded_at = CURRENT_TIMESTAMP
+            WHERE entry_id = $1 AND status = 'pending'
+            RETURNING service_id, to_char(offer_date, 'YYYY-MM-DD'), to_char(offer_time, 'HH24:MI')
+        `, c.Param("id")).Scan(&serviceID, &date, &clock)
+        if err == nil {
+            go offerFreedSlot(db, serviceID, date, clock)
+        }
+
+        c.JSON(200, gin.H{"message": "Removed from waitlist"})
+    })
+
+    api.POST("/waitlist/offers/:id/accept", func(c *gin.Context) {
+        if !authorizeWaitlistOffer(c, db, c.Param("id"), appointmentWritePermissions...) {
+            return
+        }
+        tx, err := db.Begin()
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to accept offer"})
+            return
+        }
+        defer tx.Rollback()
+
+        var offer WaitlistOffer
+        var userID, petID int
+        var notes string
+        err = tx.QueryRow(`
+            SELECT o.id, o.entry_id, o.service_id, to_char(o.offer_date, 'YYYY-MM-DD'), to_char(o.offer_time, 'HH24:MI'),
+                   o.status, o.expires_at, e.user_id, e.pet_id, COALESCE(e.notes, '')
+            FROM waitlist_offers o
+            JOIN waitlist_entries e ON o.entry_id = e.id
+            WHERE o.id = $1
+            FOR UPDATE OF o
+        `, c.Param("id")).Scan(&offer.ID, &offer.EntryID, &offer.ServiceID, &offer.Date, &offer.Time,
+            &offer.Status, &offer.ExpiresAt, &userID, &petID, &notes)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Offer not found"})
+            return
+        }
+        if offer.Status != "pending" || time.Now().After(offer.ExpiresAt) {
+            c.JSON(410, gin.H{"error": "This offer is no longer available"})
+            return
+        }
+
+        // Check inside the transaction so the slot can't be taken between the
+        // check and the insert
+        groomerID, reason, err := resolveSlot(tx, offer.ServiceID, offer.Date, offer.Time, 0, userID, nil)
+        if err != nil {
+            log.Printf("Failed to check slot: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to accept offer"})
+            return
+        }
+        if reason != "" {
+            c.JSON(409, gin.H{"error": reason})
+            return
+        }
+
+        var appointmentID int
+        err = tx.QueryRow(`
+            INSERT INTO appointments (user_id, pet_id, service_id, appointment_date, appointment_time, status, notes, groomer_id, created_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, 'confirmed', $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, userID, petID, offer.ServiceID, offer.Date, offer.Time, notes, groomerID).Scan(&appointmentID)
+        if err != nil {
+            log.Printf("Failed to book waitlist offer: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to accept offer"})
+            return
+        }
+
+        _, err = tx.Exec(`
+            UPDATE waitlist_offers SET status = 'accepted', appointment_id = $1, responded_at = CURRENT_TIMESTAMP
+            WHERE id = $2
+        `, appointmentID, offer.ID)
+        if err != nil {
+            log.Printf("Failed to mark waitlist offer %d accepted: %v", offer.ID, err)
+            c.JSON(500, gin.H{"error": "Failed to accept offer"})
+            return
+        }
+        _, err = tx.Exec("UPDATE waitlist_entries SET status = 'booked' WHERE id = $1", offer.EntryID)
+        if err != nil {
+            log.Printf("Failed to mark waitlist entry %d booked: %v", offer.EntryID, err)
+            c.JSON(500, gin.H{"error": "Failed to accept offer"})
+            return
+        }
+
+        if err := tx.Commit(); err != nil {
+            log.Printf("Failed to commit waitlist booking: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to accept offer"})
+            return
+        }
+
+        if apt, err := loadAppointment(db, appointmentID); err == nil {
+            broadcastAppointmentUpdate(apt, "created")
+        }
+
+        c.JSON(201, gin.H{"message": "Appointment booked from waitlist", "appointment_id": appointmentID})
+    })
+
+    api.POST("/waitlist/offers/:id/decline", func(c *gin.Context) {
+        if !authorizeWaitlistOffer(c, db, c.Param("id"), appointmentWritePermissions...) {
+            return
+        }
+        var entryID, serviceID int
+        var date, clock string
+        err := db.QueryRow(`
+            UPDATE waitlist_offers SET status = 'declined', responded_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND status = 'pending'
+            RETURNING entry_id, service_id, to_char(offer_date, 'YYYY-MM-DD'), to_char(offer_time, 'HH24:MI')
+        `, c.Param("id")).Scan(&entryID, &serviceID, &date, &clock)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Offer not found or already answered"})
+            return
+        }
+
+        db.Exec("UPDATE waitlist_entries SET status = 'waiting' WHERE id = $1 AND status = 'offered'", entryID)
+        go offerFreedSlot(db, serviceID, date, clock)
+
+        c.JSON(200, gin.H{"message": "Offer declined"})
+    })
+
+    admin.GET("/waitlist", func(c *gin.Context) {
+        if !requirePermission(c, appointmentReadPermissions...) {
+            return
+        }
+        date := c.Query("date")
+        if date == "" {
+            date = businessToday(db)
+        }
+
+        rows, err := db.Query(`
+            SELECT e.id, e.user_id, e.pet_id, e.service_id, to_char(e.waitlist_date, 'YYYY-MM-DD'),
+                   to_char(e.window_start, 'HH24:MI'), to_char(e.window_end, 'HH24:MI'), e.status,
+                   COALESCE(e.notes, ''), e.created_at, s.name, p.name
+            FROM waitlist_entries e
+            JOIN services s ON e.service_id = s.id
+            JOIN pets p ON e.pet_id = p.id
+            WHERE e.waitlist_date = $1 AND e.status IN ('waiting', 'offered')
+            ORDER BY e.created_at
+        `, date)
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to fetch waitlist"})
+            return
+        }
+        defer rows.Close()
+
+        var entries []WaitlistEntry
+        for rows.Next() {
+            var e WaitlistEntry
+            err := rows.Scan(&e.ID, &e.UserID, &e.PetID, &e.ServiceID, &e.Date, &e.WindowStart, &e.WindowEnd,
+                &e.Status, &e.Notes, &e.CreatedAt, &e.ServiceName, &e.PetName)
+            if err != nil {
+                continue
+            }
+            e.Position = len(entries) + 1
+            entries = append(entries, e)
+        }
+
+        c.JSON(200, gin.H{"date": date, "waitlist": entries})
+    })
+}
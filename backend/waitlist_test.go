@@ -0,0 +1,86 @@
+package main
+
+import (
+    "database/sql/driver"
+    "strings"
+    "testing"
+    "time"
+)
+
+// scriptFreedSlot sets up one waitlisted customer for a bath slot next week,
+// with held live holds already on the slot.
+func scriptFreedSlot(f *fakeDB, held int) string {
+    date := time.Now().AddDate(0, 0, 7).Format(dateLayout)
+    f.onExec("pg_advisory_xact_lock", func(args []driver.Value) (int64, error) { return 1, nil })
+    f.on("FROM waitlist_offers o JOIN services os ON o.service_id = os.id", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(held))
+    })
+    f.on("FROM waitlist_entries e JOIN services s ON e.service_id = s.id", []string{"id", "user_id", "service_id"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(50), int64(ownerID), int64(1))
+    })
+    f.on("SELECT type, COALESCE(duration_minutes, 60) FROM services WHERE id = $1", []string{"type", "duration"}, func(args []driver.Value) [][]driver.Value {
+        return row("bath", int64(60))
+    })
+    f.on("FROM business_closures WHERE closure_date = $1", []string{"closure_type", "open", "close", "reason"}, nil)
+    f.on("SELECT COUNT(*) FROM staff_schedule", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(1))
+    })
+    f.on("WHERE a.appointment_date = $1 AND a.status != 'cancelled' AND s.type = $2", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(0))
+    })
+    f.on("FROM waitlist_offers o JOIN waitlist_entries e", []string{"count"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(0))
+    })
+    f.on("INSERT INTO waitlist_offers", []string{"id", "expires_at"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(60), time.Now().Add(30*time.Minute))
+    })
+    f.onExec("UPDATE waitlist_entries SET status = 'offered'", func(args []driver.Value) (int64, error) { return 1, nil })
+    return date
+}
+
+func TestFreedSlotIsHeldUnderLock(t *testing.T) {
+    db, f := newFakeDB(t)
+    startTestHub()
+    useTestNotifier(t, db)
+    scriptSettings(f, nil)
+    date := scriptFreedSlot(f, 0)
+
+    offerFreedSlot(db, 1, date, "10:00")
+
+    steps := []string{"BEGIN", "pg_advisory_xact_lock", "o.expires_at > CURRENT_TIMESTAMP", "INSERT INTO waitlist_offers", "UPDATE waitlist_entries SET status = 'offered'"}
+    order := map[string]int{}
+    for i, call := range f.ran("") {
+        for _, step := range steps {
+            if _, seen := order[step]; !seen && strings.Contains(call.query, step) {
+                order[step] = i
+            }
+        }
+    }
+    for i, step := range steps {
+        if _, ok := order[step]; !ok {
+            t.Fatalf("%s never ran", step)
+        }
+        if i > 0 && order[step] < order[steps[i-1]] {
+            t.Errorf("%s ran before %s", step, steps[i-1])
+        }
+    }
+    if f.commits != 1 {
+        t.Errorf("got %d commits, want the hold committed once", f.commits)
+    }
+}
+
+func TestFreedSlotAlreadyHeld(t *testing.T) {
+    db, f := newFakeDB(t)
+    useTestNotifier(t, db)
+    scriptSettings(f, nil)
+    date := scriptFreedSlot(f, 1)
+
+    offerFreedSlot(db, 1, date, "10:00")
+
+    if len(f.ran("INSERT INTO waitlist_offers")) != 0 {
+        t.Errorf("second hold created for a slot with a live hold")
+    }
+    if f.commits != 0 {
+        t.Errorf("got %d commits, want none", f.commits)
+    }
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+type WashStation struct {
+    ID              int       `json:"id" db:"id"`
+    Name            string    `json:"name" db:"name"`
+    Status          string    `json:"status" db:"status"`
+    Active          bool      `json:"active" db:"active"`
+    StatusChangedAt time.Time `json:"status_changed_at" db:"status_changed_at"`
+    CurrentCustomer string    `json:"current_customer,omitempty"`
+    FreeAt          time.Time `json:"free_at"`
+}
+
+type QueueEntry struct {
+    ID                   int        `json:"id" db:"id"`
+    UserID               *int       `json:"user_id,omitempty" db:"user_id"`
+    PetID                *int       `json:"pet_id,omitempty" db:"pet_id"`
+    AppointmentID        *int       `json:"appointment_id,omitempty" db:"appointment_id"`
+    ServiceID            int        `json:"service_id" db:"service_id"`
+    CustomerName         string     `json:"customer_name" db:"customer_name"`
+    Status               string     `json:"status" db:"status"`
+    StationID            *int       `json:"station_id,omitempty" db:"station_id"`
+    CheckedInAt          time.Time  `json:"checked_in_at" db:"checked_in_at"`
+    StartedAt            *time.Time `json:"started_at,omitempty" db:"started_at"`
+    DurationMinutes      int        `json:"duration_minutes"`
+    Position             int        `json:"position,omitempty"`
+    EstimatedWaitMinutes int        `json:"estimated_wait_minutes"`
+}
+
+// LobbyQueueEntry is what the public lobby display and its WebSocket feed
+// see of a queue entry: no account, pet or booking IDs.
+type LobbyQueueEntry struct {
+    ID                   int    `json:"id"`
+    CustomerName         string `json:"customer_name"`
+    Status               string `json:"status"`
+    StationID            *int   `json:"station_id,omitempty"`
+    Position             int    `json:"position,omitempty"`
+    EstimatedWaitMinutes int    `json:"estimated_wait_minutes"`
+}
+
+type CheckInRequest struct {
+    UserID        *int   `json:"user_id"`
+    PetID         *int   `json:"pet_id"`
+    AppointmentID *int   `json:"appointment_id"`
+    ServiceID     int    `json:"service_id"`
+    CustomerName  string `json:"customer_name"`
+}
+
+// estimateWaits hands each waiting customer, in order, the station that frees
+// up first and returns how long each will wait from now.
+func estimateWaits(freeAt []time.Time, durations []int, cleaning time.Duration, now time.Time) []time.Duration {
+    waits := make([]time.Duration, len(durations))
+    if len(freeAt) == 0 {
+        return waits
+    }
+
+    slots := make([]time.Time, len(freeAt))
+    copy(slots, freeAt)
+    for i, minutes := range durations {
+        sort.Slice(slots, func(a, b int) bool { return slots[a].Before(slots[b]) })
+        start := slots[0]
+        if start.Before(now) {
+            start = now
+        }
+        waits[i] = start.Sub(now)
+        slots[0] = start.Add(time.Duration(minutes)*time.Minute + cleaning)
+    }
+    return waits
+}
+
+// lobbyName keeps the lobby display to a first name and last initial.
+func lobbyName(name string) string {
+    parts := strings.Fields(name)
+    if len(parts) < 2 {
+        return name
+    }
+    return parts[0] + " " + string([]rune(parts[len(parts)-1])[0]) + "."
+}
+
+// lobbyQueue strips queue entries down to what the lobby display shows.
+func lobbyQueue(queue []QueueEntry) []LobbyQueueEntry {
+    lobby := make([]LobbyQueueEntry, len(queue))
+    for i, e := range queue {
+        lobby[i] = LobbyQueueEntry{
+            ID:                   e.ID,
+            CustomerName:         lobbyName(e.CustomerName),
+            Status:               e.Status,
+            StationID:            e.StationID,
+            Position:             e.Position,
+            EstimatedWaitMinutes: e.EstimatedWaitMinutes,
+        }
+    }
+    return lobby
+}
+
+// loadQueueSnapshot returns every station and the live queue with estimated
+// waits filled in.
+func loadQueueSnapshot(db *sql.DB) ([]WashStation, []QueueEntry, error) {
+    now := time.Now()
+    cleaning := time.Duration(getSettingInt(db, "diy", "cleaning_minutes", 10)) * time.Minute
+
+    rows, err := db.Query(`
+        SELECT q.id, q.user_id, q.pet_id, q.appointment_id, q.service_id, q.customer_name, q.status,
+               q.station_id, q.checked_in_at, q.started_at, COALESCE(s.duration_minutes, 60)
+        FROM diy_queue q
+        JOIN services s ON q.service_id = s.id
+        WHERE q.status IN ('waiting', 'washing')
+        ORDER BY q.checked_in_at
+    `)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer rows.Close()
+
+    var queue []QueueEntry
+    washing := make(map[int]QueueEntry)
+    for rows.Next() {
+        var e QueueEntry
+        err := rows.Scan(&e.ID, &e.UserID, &e.PetID, &e.AppointmentID, &e.ServiceID, &e.CustomerName, &e.Status,
+            &e.StationID, &e.CheckedInAt, &e.StartedAt, &e.DurationMinutes)
+        if err != nil {
+            return nil, nil, err
+        }
+        if e.Status == "washing" && e.StationID != nil {
+            washing[*e.StationID] = e
+            continue
+        }
+        queue = append(queue, e)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, nil, err
+    }
+
+    stationRows, err := db.Query(`
+        SELECT id, name, status, active, status_changed_at
+        FROM wash_stations ORDER BY id
+    `)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer stationRows.Close()
+
+    var stations []WashStation
+    var freeAt []time.Time
+    for stationRows.Next() {
+        var st WashStation
+        if err := stationRows.Scan(&st.ID, &st.Name, &st.Status, &st.Active, &st.StatusChangedAt); err != nil {
+            return nil, nil, err
+        }
+
+        st.FreeAt = now
+        switch st.Status {
+        case "in_use":
+            if e, ok := washing[st.ID]; ok && e.StartedAt != nil {
+                st.CurrentCustomer = lobbyName(e.CustomerName)
+                st.FreeAt = e.StartedAt.Add(time.Duration(e.DurationMinutes)*time.Minute + cleaning)
+            }
+        case "cleaning":
+            st.FreeAt = st.StatusChangedAt.Add(cleaning)
+        }
+        if st.FreeAt.Before(now) {
+            st.FreeAt = now
+        }
+
+        if st.Active {
+            freeAt = append(freeAt, st.FreeAt)
+        }
+        stations = append(stations, st)
+    }
+
+    durations := make([]int, len(queue))
+    for i, e := range queue {
+        durations[i] = e.DurationMinutes
+    }
+    waits := estimateWaits(freeAt, durations, cleaning, now)
+    for i := range queue {
+        queue[i].Position = i + 1
+        queue[i].EstimatedWaitMinutes = int(waits[i].Round(time.Minute).Minutes())
+    }
+
+    return stations, queue, nil
+}
+
+// broadcastQueue pushes the current lobby view to every connected display.
+func broadcastQueue(db *sql.DB) {
+    stations, queue, err := loadQueueSnapshot(db)
+    if err != nil {
+        log.Printf("Failed to load DIY queue for broadcast: %v", err)
+        return
+    }
+
+    broadcastMessage("diy_queue_update", map[string]interface{}{
+        "stations":  stations,
+        "queue":     lobbyQueue(queue),
+        "timestamp": time.Now(),
+    })
+}
+
+func setStationStatus(tx *sql.Tx, stationID int, status string) error {
+    _, err := tx.Exec(`
+        UPDATE wash_stations SET status = $1, status_changed_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, status, stationID)
+    return err
+}
+
+func registerWashStationRoutes(api *gin.RouterGroup, admin *gin.RouterGroup, db *sql.DB) {
+    // Walk-in (or booked customer arriving) joins the live queue
+    api.POST("/diy/check-in", func(c *gin.Context) {
+        var req CheckInRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        // Customers check themselves in; anyone else's check-in, including
+        // walk-ins without an account, is done by staff at the desk
+        switch {
+        case req.AppointmentID != nil:
+            if !authorizeAppointment(c, db, strconv.Itoa(*req.AppointmentID), appointmentWritePermissions...) {
+                return
+            }
+        case req.UserID != nil:
+            if !authorizeOwner(c, *req.UserID, appointmentWritePermissions...) {
+                return
+            }
+            if req.PetID != nil && !requirePetOwner(c, db, *req.PetID, *req.UserID) {
+                return
+            }
+        default:
+            if !requirePermission(c, appointmentWritePermissions...) {
+                return
+            }
+        }
+
+        if req.AppointmentID != nil {
+            var userID, petID int
+            err := db.QueryRow(`
+                SELECT a.user_id, a.pet_id, a.service_id, u.name
+                FROM appointments a
+                JOIN users u ON a.user_id = u.id
+                JOIN services s ON a.service_id = s.id
+                WHERE a.id = $1 AND s.type = 'diy'
+            `, *req.AppointmentID).Scan(&userID, &petID, &req.ServiceID, &req.CustomerName)
+            if err != nil {
+                c.JSON(404, gin.H{"error": "DIY appointment not found"})
+                return
+            }
+            req.UserID = &userID
+            req.PetID = &petID
+        } else if req.UserID != nil && req.CustomerName == "" {
+            db.QueryRow("SELECT name FROM users WHERE id = $1", *req.UserID).Scan(&req.CustomerName)
+        }
+
+        if strings.TrimSpace(req.CustomerName) == "" {
+            c.JSON(400, gin.H{"error": "customer_name is required for walk-ins"})
+            return
+        }
+
+        if req.ServiceID == 0 {
+            err := db.QueryRow(`
+                SELECT id FROM services WHERE type = 'diy' AND active = TRUE ORDER BY price LIMIT 1
+            `).Scan(&req.ServiceID)
+            if err != nil {
+                c.JSON(400, gin.H{"error": "No DIY service is available"})
+                return
+            }
+        }
+
+        var entryID int
+        err := db.QueryRow(`
+            INSERT INTO diy_queue (user_id, pet_id, appointment_id, service_id, customer_name, status, checked_in_at)
+            VALUES ($1, $2, $3, $4, $5, 'waiting', CURRENT_TIMESTAMP)
+            RETURNING id
+        `, req.UserID, req.PetID, req.AppointmentID, req.ServiceID, req.CustomerName).Scan(&entryID)
+        if err != nil {
+            log.Printf("Failed to check in: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to check in"})
+            return
+        }
+
+        _, queue, err := loadQueueSnapshot(db)
+        if err != nil {
+            log.Printf("Failed to load DIY queue: %v", err)
+        }
+        var entry QueueEntry
+        for _, e := range queue {
+            if e.ID == entryID {
+                entry = e
+            }
+        }
+
+        go broadcastQueue(db)
+
+        c.JSON(201, gin.H{
+            "message":                "Checked in successfully",
+            "queue_id":               entryID,
+            "position":               entry.Position,
+            "estimated_wait_minutes": entry.EstimatedWaitMinutes,
+        })
+    })
+
+    // Lobby display feed
+    api.GET("/diy/queue", func(c *gin.Context) {
+        stations, queue, err := loadQueueSnapshot(db)
+        if err != nil {
+            log.Printf("Failed to load DIY queue: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch queue"})
+            return
+        }
+        c.JSON(200, gin.H{"stations": stations, "queue": lobbyQueue(queue)})
+    })
+
+    api.DELETE("/diy/queue/:id", func(c *gin.Context) {
+        // Walk-in entries have no user, so only staff can remove them
+        if !authorizeRecord(c, db, "SELECT COALESCE(user_id, 0) FROM diy_queue WHERE id::text = $1", c.Param("id"), "Queue entry not found", appointmentWritePermissions...) {
+            return
+        }
+        result, err := db.Exec(`
+            UPDATE diy_queue SET status = 'left', finished_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND status = 'waiting'
+        `, c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to leave queue: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to leave queue"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(404, gin.H{"error": "Queue entry not found or already started"})
+            return
+        }
+
+        go broadcastQueue(db)
+        c.JSON(200, gin.H{"message": "Removed from queue"})
+    })
+
+    admin.GET("/stations", func(c *gin.Context) {
+        if !requirePermission(c, appointmentReadPermissions...) {
+            return
+        }
+        stations, queue, err := loadQueueSnapshot(db)
+        if err != nil {
+            log.Printf("Failed to load DIY queue: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch stations"})
+            return
+        }
+        c.JSON(200, gin.H{"stations": stations, "queue": queue})
+    })
+
+    admin.POST("/stations", func(c *gin.Context) {
+        if !requirePermission(c, "business_settings") {
+            return
+        }
+        var req struct {
+            Name string `json:"name" binding:"required"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        var stationID int
+        err := db.QueryRow(`
+            INSERT INTO wash_stations (name, status, active, status_changed_at, created_at)
+            VALUES ($1, 'free', TRUE, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, req.Name).Scan(&stationID)
+        if err != nil {
+            log.Printf("Failed to create station: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to create station"})
+            return
+        }
+
+        go broadcastQueue(db)
+        c.JSON(201, gin.H{"message": "Station created successfully", "station_id": stationID})
+    })
+
+    admin.PUT("/stations/:id", func(c *gin.Context) {
+        if !requirePermission(c, "business_settings") {
+            return
+        }
+        var req struct {
+            Name   string `json:"name"`
+            Active *bool  `json:"active"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        _, err := db.Exec(`
+            UPDATE wash_stations
+            SET name = COALESCE(NULLIF($1, ''), name), active = COALESCE($2, active)
+            WHERE id = $3
+        `, req.Name, req.Active, c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to update station: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to update station"})
+            return
+        }
+
+        go broadcastQueue(db)
+        c.JSON(200, gin.H{"message": "Station updated successfully"})
+    })
+
+    // Put the next customer (or a specific one) on a free station
+    admin.POST("/stations/:id/start", func(c *gin.Context) {
+        if !requirePermission(c, appointmentWritePermissions...) {
+            return
+        }
+        var req struct {
+            QueueID *int `json:"queue_id"`
+        }
+        c.ShouldBindJSON(&req)
+
+        tx, err := db.Begin()
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to start wash"})
+            return
+        }
+        defer tx.Rollback()
+
+        var stationID int
+        var status string
+        var active bool
+        err = tx.QueryRow("SELECT id, status, active FROM wash_stations WHERE id = $1 FOR UPDATE", c.Param("id")).Scan(&stationID, &status, &active)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Station not found"})
+            return
+        }
+        if status != "free" || !active {
+            c.JSON(409, gin.H{"error": "Station is not free"})
+            return
+        }
+
+        var entryID int
+        var appointmentID *int
+        if req.QueueID != nil {
+            err = tx.QueryRow(`
+                SELECT id, appointment_id FROM diy_queue WHERE id = $1 AND status = 'waiting' FOR UPDATE
+            `, *req.QueueID).Scan(&entryID, &appointmentID)
+        } else {
+            err = tx.QueryRow(`
+                SELECT id, appointment_id FROM diy_queue WHERE status = 'waiting'
+                ORDER BY checked_in_at LIMIT 1 FOR UPDATE SKIP LOCKED
+            `).Scan(&entryID, &appointmentID)
+        }
+        if err != nil {
+            c.JSON(404, gin.H{"error": "No waiting customer found"})
+            return
+        }
+
+        _, err = tx.Exec(`
+            UPDATE diy_queue SET status = 'washing', station_id = $1, started_at = CURRENT_TIMESTAMP
+            WHERE id = $2
+        `, stationID, entryID)
+        if err == nil {
+            err = setStationStatus(tx, stationID, "in_use")
+        }
+        if err == nil {
+            err = tx.Commit()
+        }
+        if err != nil {
+            log.Printf("Failed to start wash: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to start wash"})
+            return
+        }
+
+        if appointmentID != nil {
+            if _, err := updateAppointmentStatus(db, *appointmentID, "in_progress", actorID(c), "DIY wash started"); err != nil {
+                log.Printf("Failed to mark appointment %d in progress: %v", *appointmentID, err)
+            }
+        }
+        go broadcastQueue(db)
+        c.JSON(200, gin.H{"message": "Wash started", "queue_id": entryID, "station_id": stationID})
+    })
+
+    // Customer is done; the station goes to cleaning
+    admin.POST("/stations/:id/finish", func(c *gin.Context) {
+        if !requirePermission(c, appointmentWritePermissions...) {
+            return
+        }
+        tx, err := db.Begin()
+        if err != nil {
+            c.JSON(500, gin.H{"error": "Failed to finish wash"})
+            return
+        }
+        defer tx.Rollback()
+
+        var stationID int
+        var status string
+        err = tx.QueryRow("SELECT id, status FROM wash_stations WHERE id = $1 FOR UPDATE", c.Param("id")).Scan(&stationID, &status)
+        if err != nil {
+            c.JSON(404, gin.H{"error": "Station not found"})
+            return
+        }
+        if status != "in_use" {
+            c.JSON(409, gin.H{"error": "Station is not in use"})
+            return
+        }
+
+        var appointmentID *int
+        err = tx.QueryRow(`
+            UPDATE diy_queue SET status = 'done', finished_at = CURRENT_TIMESTAMP
+            WHERE station_id = $1 AND status = 'washing'
+            RETURNING appointment_id
+        `, stationID).Scan(&appointmentID)
+        if err != nil && err != sql.ErrNoRows {
+            log.Printf("Failed to finish queue entry: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to finish wash"})
+            return
+        }
+
+        err = setStationStatus(tx, stationID, "cleaning")
+        if err == nil {
+            err = tx.Commit()
+        }
+        if err != nil {
+            log.Printf("Failed to finish wash: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to finish wash"})
+            return
+        }
+
+        // Completing goes through the shared path so the customer hears
+        // their pet is ready and the history records why
+        if appointmentID != nil {
+            if _, err := updateAppointmentStatus(db, *appointmentID, "completed", actorID(c), "DIY wash finished"); err != nil {
+                log.Printf("Failed to complete appointment %d: %v", *appointmentID, err)
+            }
+        }
+        go broadcastQueue(db)
+        c.JSON(200, gin.H{"message": "Wash finished, station is being cleaned"})
+    })
+
+    // Cleaning is done; the station can take the next customer
+    admin.POST("/stations/:id/ready", func(c *gin.Context) {
+        if !requirePermission(c, appointmentWritePermissions...) {
+            return
+        }
+        result, err := db.Exec(`
+            UPDATE wash_stations SET status = 'free', status_changed_at = CURRENT_TIMESTAMP
+            WHERE id = $1 AND status = 'cleaning'
+        `, c.Param("id"))
+        if err != nil {
+            log.Printf("Failed to mark station ready: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to update station"})
+            return
+        }
+        if n, _ := result.RowsAffected(); n == 0 {
+            c.JSON(409, gin.H{"error": "Station is not being cleaned"})
+            return
+        }
+
+        go broadcastQueue(db)
+        c.JSON(200, gin.H{"message": "Station is free"})
+    })
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestLobbyQueueHidesCustomerRecords(t *testing.T) {
+    db, f := newAuthTestDB(t)
+    scriptSettings(f, nil)
+    f.on("FROM diy_queue q JOIN services s ON q.service_id = s.id", []string{"id", "user_id", "pet_id", "appointment_id", "service_id",
+        "customer_name", "status", "station_id", "checked_in_at", "started_at", "duration"}, func(args []driver.Value) [][]driver.Value {
+        return [][]driver.Value{
+            {int64(1), int64(ownerID), int64(100), int64(200), int64(3), "Sam Rivera", "washing", int64(1), time.Now(), time.Now(), int64(30)},
+            {int64(2), int64(otherID), int64(101), nil, int64(3), "Alex Chen", "waiting", nil, time.Now(), nil, int64(30)},
+        }
+    })
+    f.on("FROM wash_stations ORDER BY id", []string{"id", "name", "status", "active", "status_changed_at"}, func(args []driver.Value) [][]driver.Value {
+        return row(int64(1), "Station 1", "in_use", true, time.Now())
+    })
+
+    w := httptest.NewRecorder()
+    newRouter(db).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/diy/queue", nil))
+    if w.Code != 200 {
+        t.Fatalf("got %d: %s", w.Code, w.Body.String())
+    }
+
+    var body struct {
+        Stations []map[string]interface{} `json:"stations"`
+        Queue    []map[string]interface{} `json:"queue"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+        t.Fatal(err)
+    }
+    if len(body.Queue) != 1 || len(body.Stations) != 1 {
+        t.Fatalf("unexpected snapshot: %s", w.Body.String())
+    }
+    entry := body.Queue[0]
+    for _, field := range []string{"user_id", "pet_id", "appointment_id", "service_id", "checked_in_at"} {
+        if _, ok := entry[field]; ok {
+            t.Errorf("lobby queue exposes %s", field)
+        }
+    }
+    if entry["customer_name"] != "Alex C." || entry["status"] != "waiting" || entry["position"] != float64(1) {
+        t.Errorf("unexpected lobby entry: %v", entry)
+    }
+    if body.Stations[0]["current_customer"] != "Sam R." {
+        t.Errorf("station shows %v, want the masked name", body.Stations[0]["current_customer"])
+    }
+}
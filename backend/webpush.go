@@ -0,0 +1,552 @@
+package main
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/hkdf"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math/big"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Push services reject payloads over 4096 bytes once encrypted; the
+// aes128gcm header, padding delimiter and tag take 103 of those.
+const (
+    pushRecordSize     = 4096
+    pushMaxPayloadSize = pushRecordSize - 103
+)
+
+var errPushSubscriptionGone = errors.New("push subscription expired or unsubscribed")
+
+type PushSubscription struct {
+    ID         int        `json:"id" db:"id"`
+    UserID     int        `json:"user_id" db:"user_id"`
+    Endpoint   string     `json:"endpoint" db:"endpoint"`
+    P256dh     string     `json:"-" db:"p256dh"`
+    Auth       string     `json:"-" db:"auth"`
+    UserAgent  string     `json:"user_agent" db:"user_agent"`
+    CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+    LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+}
+
+// PushSubscriptionRequest is the browser's PushSubscription.toJSON().
+type PushSubscriptionRequest struct {
+    Endpoint string `json:"endpoint" binding:"required,url"`
+    Keys     struct {
+        P256dh string `json:"p256dh" binding:"required"`
+        Auth   string `json:"auth" binding:"required"`
+    } `json:"keys" binding:"required"`
+}
+
+type vapidKeys struct {
+    PublicKey  string // base64url uncompressed P-256 point, handed to PushManager.subscribe
+    PrivateKey string // base64url raw 32-byte scalar
+    Subject    string // mailto: or https: contact for the push service operator
+}
+
+// loadVAPIDKeys reads the server's VAPID identity. The key pair comes only
+// from the environment so the private key never sits in business_settings,
+// which the settings API hands out; only the contact subject falls back to
+// the push settings. Generate a pair with `jakes-bath-house vapid-keys`.
+func loadVAPIDKeys(db *sql.DB) (vapidKeys, bool) {
+    keys := vapidKeys{
+        PublicKey:  os.Getenv("VAPID_PUBLIC_KEY"),
+        PrivateKey: os.Getenv("VAPID_PRIVATE_KEY"),
+        Subject:    os.Getenv("VAPID_SUBJECT"),
+    }
+    if keys.Subject == "" {
+        keys.Subject = getSetting(db, "push", "vapid_subject", "mailto:hello@jakesbathhouse.local")
+    }
+    return keys, keys.PublicKey != "" && keys.PrivateKey != ""
+}
+
+func generateVAPIDKeys() (vapidKeys, error) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return vapidKeys{}, err
+    }
+    public, err := key.PublicKey.ECDH()
+    if err != nil {
+        return vapidKeys{}, err
+    }
+    return vapidKeys{
+        PublicKey:  base64.RawURLEncoding.EncodeToString(public.Bytes()),
+        PrivateKey: base64.RawURLEncoding.EncodeToString(key.D.FillBytes(make([]byte, 32))),
+    }, nil
+}
+
+// printVAPIDKeys backs the `vapid-keys` CLI command.
+func printVAPIDKeys() {
+    keys, err := generateVAPIDKeys()
+    if err != nil {
+        log.Fatal("Failed to generate VAPID keys:", err)
+    }
+    fmt.Println("# Add to .env; keep the private key out of the database")
+    fmt.Printf("VAPID_PUBLIC_KEY=%s\n", keys.PublicKey)
+    fmt.Printf("VAPID_PRIVATE_KEY=%s\n", keys.PrivateKey)
+    fmt.Println("VAPID_SUBJECT=mailto:hello@jakesbathhouse.local")
+}
+
+func decodeBase64URL(value string) ([]byte, error) {
+    return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func (k vapidKeys) signingKey() (*ecdsa.PrivateKey, error) {
+    raw, err := decodeBase64URL(k.PrivateKey)
+    if err != nil {
+        return nil, fmt.Errorf("decode VAPID private key: %w", err)
+    }
+    private, err := ecdh.P256().NewPrivateKey(raw)
+    if err != nil {
+        return nil, fmt.Errorf("parse VAPID private key: %w", err)
+    }
+    point := private.PublicKey().Bytes()
+    return &ecdsa.PrivateKey{
+        PublicKey: ecdsa.PublicKey{
+            Curve: elliptic.P256(),
+            X:     new(big.Int).SetBytes(point[1:33]),
+            Y:     new(big.Int).SetBytes(point[33:]),
+        },
+        D: new(big.Int).SetBytes(raw),
+    }, nil
+}
+
+// vapidAuthorization builds the RFC 8292 Authorization header: an ES256 JWT
+// scoped to the push service's origin plus our public key.
+func (k vapidKeys) vapidAuthorization(endpoint string) (string, error) {
+    u, err := url.Parse(endpoint)
+    if err != nil {
+        return "", err
+    }
+    key, err := k.signingKey()
+    if err != nil {
+        return "", err
+    }
+
+    header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+    claims, err := json.Marshal(map[string]interface{}{
+        "aud": u.Scheme + "://" + u.Host,
+        "exp": time.Now().Add(12 * time.Hour).Unix(),
+        "sub": k.Subject,
+    })
+    if err != nil {
+        return "", err
+    }
+    signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+    digest := sha256.Sum256([]byte(signingInput))
+    r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+    if err != nil {
+        return "", err
+    }
+    signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+    token := signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+    return fmt.Sprintf("vapid t=%s, k=%s", token, k.PublicKey), nil
+}
+
+// encryptPushPayload encrypts a message for one subscription using the
+// aes128gcm content coding (RFC 8188) keyed as described in RFC 8291.
+func encryptPushPayload(payload []byte, p256dh string, authSecret string) ([]byte, error) {
+    // A fresh application-server key pair and salt per message
+    asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, err
+    }
+    salt := make([]byte, 16)
+    if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+        return nil, err
+    }
+    return encryptPushPayloadWith(payload, p256dh, authSecret, asPrivate, salt)
+}
+
+// encryptPushPayloadWith is encryptPushPayload with the key pair and salt
+// supplied, so the RFC 8291 test vector can be reproduced.
+func encryptPushPayloadWith(payload []byte, p256dh string, authSecret string, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+    if len(payload) > pushMaxPayloadSize {
+        return nil, fmt.Errorf("push payload is %d bytes, limit is %d", len(payload), pushMaxPayloadSize)
+    }
+
+    uaPublicBytes, err := decodeBase64URL(p256dh)
+    if err != nil {
+        return nil, fmt.Errorf("decode p256dh: %w", err)
+    }
+    uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+    if err != nil {
+        return nil, fmt.Errorf("parse p256dh: %w", err)
+    }
+    auth, err := decodeBase64URL(authSecret)
+    if err != nil || len(auth) != 16 {
+        return nil, fmt.Errorf("auth secret must be 16 bytes")
+    }
+
+    asPublic := asPrivate.PublicKey().Bytes()
+    ecdhSecret, err := asPrivate.ECDH(uaPublic)
+    if err != nil {
+        return nil, err
+    }
+
+    // IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 || ua_public || as_public, 32)
+    keyInfo := "WebPush: info\x00" + string(uaPublicBytes) + string(asPublic)
+    ikm, err := hkdf.Key(sha256.New, ecdhSecret, auth, keyInfo, 32)
+    if err != nil {
+        return nil, err
+    }
+
+    prk, err := hkdf.Extract(sha256.New, ikm, salt)
+    if err != nil {
+        return nil, err
+    }
+    cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+    if err != nil {
+        return nil, err
+    }
+    nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+    if err != nil {
+        return nil, err
+    }
+
+    block, err := aes.NewCipher(cek)
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    // Single record, so it ends with the 0x02 last-record delimiter
+    record := gcm.Seal(nil, nonce, append(append([]byte{}, payload...), 0x02), nil)
+
+    // Header: salt(16) || rs(4) || idlen(1) || keyid(as_public)
+    var body bytes.Buffer
+    body.Write(salt)
+    binary.Write(&body, binary.BigEndian, uint32(pushRecordSize))
+    body.WriteByte(byte(len(asPublic)))
+    body.Write(asPublic)
+    body.Write(record)
+    return body.Bytes(), nil
+}
+
+// publicIP reports whether ip is somewhere on the internet a push service
+// could be, rather than loopback, private, link-local or otherwise internal.
+func publicIP(ip net.IP) bool {
+    if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+        ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+        return false
+    }
+    // Carrier-grade NAT space, which IsPrivate doesn't cover
+    if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+        return false
+    }
+    return true
+}
+
+// validatePushEndpoint checks a subscription endpoint before we store it or
+// post to it: https only, and every address the host resolves to must be
+// public. Endpoints come from customers, so without this the test push
+// could be aimed at internal hosts.
+func validatePushEndpoint(endpoint string) error {
+    u, err := url.Parse(endpoint)
+    if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+        return errors.New("endpoint must be an https URL")
+    }
+    ips, err := net.LookupIP(u.Hostname())
+    if err != nil || len(ips) == 0 {
+        return errors.New("endpoint host could not be resolved")
+    }
+    for _, ip := range ips {
+        if !publicIP(ip) {
+            return errors.New("endpoint must be a public push service")
+        }
+    }
+    return nil
+}
+
+// pushClient refuses to connect to internal addresses at dial time too, so
+// a host that resolved to a public address when the subscription was saved
+// can't later be re-pointed somewhere internal.
+var pushClient = &http.Client{
+    Timeout: 15 * time.Second,
+    Transport: &http.Transport{
+        DialContext: (&net.Dialer{
+            Timeout: 10 * time.Second,
+            Control: func(network, address string, _ syscall.RawConn) error {
+                host, _, err := net.SplitHostPort(address)
+                if err != nil {
+                    return err
+                }
+                if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+                    return fmt.Errorf("refusing to connect to internal address %s", host)
+                }
+                return nil
+            },
+        }).DialContext,
+        TLSHandshakeTimeout: 10 * time.Second,
+    },
+    // A redirect could point anywhere; push services don't send them
+    CheckRedirect: func(*http.Request, []*http.Request) error {
+        return http.ErrUseLastResponse
+    },
+}
+
+// sendWebPush delivers one encrypted message to a subscription's push service.
+func sendWebPush(keys vapidKeys, sub PushSubscription, payload []byte, ttl time.Duration) error {
+    if u, err := url.Parse(sub.Endpoint); err != nil || u.Scheme != "https" {
+        return errors.New("push endpoint is not an https URL")
+    }
+    body, err := encryptPushPayload(payload, sub.P256dh, sub.Auth)
+    if err != nil {
+        return err
+    }
+    authorization, err := keys.vapidAuthorization(sub.Endpoint)
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest("POST", sub.Endpoint, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", authorization)
+    req.Header.Set("Content-Encoding", "aes128gcm")
+    req.Header.Set("Content-Type", "application/octet-stream")
+    req.Header.Set("TTL", fmt.Sprintf("%d", int(ttl.Seconds())))
+    req.Header.Set("Urgency", "normal")
+
+    resp, err := pushClient.Do(req)
+    if err != nil {
+        return fmt.Errorf("push to subscription %d: %w", sub.ID, err)
+    }
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode == 404 || resp.StatusCode == 410:
+        return errPushSubscriptionGone
+    case resp.StatusCode >= 300:
+        // The body stays in our logs; the error can reach the customer
+        detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        log.Printf("Push service rejected subscription %d with %d: %s", sub.ID, resp.StatusCode, strings.TrimSpace(string(detail)))
+        return fmt.Errorf("push service returned %d", resp.StatusCode)
+    }
+    return nil
+}
+
+func loadPushSubscriptions(db *sql.DB, userID int) ([]PushSubscription, error) {
+    rows, err := db.Query(`
+        SELECT id, user_id, endpoint, p256dh, auth, COALESCE(user_agent, ''), created_at, last_used_at
+        FROM push_subscriptions
+        WHERE user_id = $1
+        ORDER BY id
+    `, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var subs []PushSubscription
+    for rows.Next() {
+        var sub PushSubscription
+        if err := rows.Scan(&sub.ID, &sub.UserID, &sub.Endpoint, &sub.P256dh, &sub.Auth, &sub.UserAgent, &sub.CreatedAt, &sub.LastUsedAt); err == nil {
+            subs = append(subs, sub)
+        }
+    }
+    return subs, nil
+}
+
+func hasPushSubscription(db *sql.DB, userID int) bool {
+    var exists bool
+    db.QueryRow("SELECT EXISTS(SELECT 1 FROM push_subscriptions WHERE user_id = $1)", userID).Scan(&exists)
+    return exists
+}
+
+// pushToUser sends a payload to every device the user has subscribed,
+// dropping subscriptions the push service says are gone. It only fails if
+// no device accepted the message.
+func pushToUser(db *sql.DB, userID int, payload []byte) error {
+    keys, ok := loadVAPIDKeys(db)
+    if !ok {
+        return errors.New("VAPID keys are not configured")
+    }
+    subs, err := loadPushSubscriptions(db, userID)
+    if err != nil {
+        return err
+    }
+    if len(subs) == 0 {
+        return fmt.Errorf("user %d has no push subscriptions", userID)
+    }
+
+    var lastErr error
+    delivered := 0
+    for _, sub := range subs {
+        err := sendWebPush(keys, sub, payload, 24*time.Hour)
+        switch {
+        case err == errPushSubscriptionGone:
+            db.Exec("DELETE FROM push_subscriptions WHERE id = $1", sub.ID)
+        case err != nil:
+            lastErr = err
+        default:
+            delivered++
+            db.Exec("UPDATE push_subscriptions SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1", sub.ID)
+        }
+    }
+    if delivered == 0 && lastErr != nil {
+        return lastErr
+    }
+    return nil
+}
+
+// pushChannel delivers notifications to the user's subscribed browsers and
+// phones, so they arrive even with the app closed.
+type pushChannel struct {
+    db *sql.DB
+}
+
+func (pushChannel) Name() string { return "push" }
+
+func (ch pushChannel) Send(to Recipient, msg OutboxMessage) error {
+    payload, err := json.Marshal(map[string]interface{}{
+        "title": msg.Subject,
+        "body":  msg.Body,
+        "tag":   msg.EventType,
+        "data":  msg.Data,
+    })
+    if err != nil {
+        return err
+    }
+    return pushToUser(ch.db, to.UserID, payload)
+}
+
+func registerPushRoutes(api *gin.RouterGroup, db *sql.DB) {
+    // The service worker needs this to subscribe
+    api.GET("/push/vapid-public-key", func(c *gin.Context) {
+        keys, ok := loadVAPIDKeys(db)
+        if !ok {
+            c.JSON(503, gin.H{"error": "Push notifications are not configured"})
+            return
+        }
+        c.JSON(200, gin.H{"public_key": keys.PublicKey})
+    })
+
+    api.POST("/push/subscriptions", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        var req PushSubscriptionRequest
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validatePushEndpoint(req.Endpoint); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+        if raw, err := decodeBase64URL(req.Keys.P256dh); err != nil || len(raw) != 65 {
+            c.JSON(400, gin.H{"error": "keys.p256dh must be a base64url P-256 public key"})
+            return
+        }
+        if raw, err := decodeBase64URL(req.Keys.Auth); err != nil || len(raw) != 16 {
+            c.JSON(400, gin.H{"error": "keys.auth must be a base64url 16-byte secret"})
+            return
+        }
+
+        // Browsers reuse an endpoint per device; re-subscribing moves it to the current user
+        var subscriptionID int
+        err := db.QueryRow(`
+            INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, user_agent, created_at)
+            VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+            ON CONFLICT (endpoint) DO UPDATE SET user_id = $1, p256dh = $3, auth = $4, user_agent = $5
+            RETURNING id
+        `, userID, req.Endpoint, req.Keys.P256dh, req.Keys.Auth, c.Request.UserAgent()).Scan(&subscriptionID)
+        if err != nil {
+            log.Printf("Failed to save push subscription: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to save push subscription"})
+            return
+        }
+
+        c.JSON(201, gin.H{"message": "Push subscription saved", "subscription_id": subscriptionID})
+    })
+
+    api.GET("/push/subscriptions", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        subs, err := loadPushSubscriptions(db, userID)
+        if err != nil {
+            log.Printf("Failed to fetch push subscriptions: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to fetch push subscriptions"})
+            return
+        }
+        if subs == nil {
+            subs = []PushSubscription{}
+        }
+        c.JSON(200, gin.H{"subscriptions": subs})
+    })
+
+    api.DELETE("/push/subscriptions", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        var req struct {
+            Endpoint string `json:"endpoint" binding:"required"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(400, gin.H{"error": err.Error()})
+            return
+        }
+
+        _, err := db.Exec("DELETE FROM push_subscriptions WHERE endpoint = $1 AND user_id = $2", req.Endpoint, userID)
+        if err != nil {
+            log.Printf("Failed to delete push subscription: %v", err)
+            c.JSON(500, gin.H{"error": "Failed to delete push subscription"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Push subscription removed"})
+    })
+
+    // Send a test push to the caller's devices
+    api.POST("/push/test", func(c *gin.Context) {
+        userID, ok := currentUserID(c)
+        if !ok {
+            c.JSON(401, gin.H{"error": "User ID is required"})
+            return
+        }
+        payload, _ := json.Marshal(map[string]interface{}{
+            "title": getSetting(db, "business", "business_name", "Jake's Bath House"),
+            "body":  "Push notifications are working!",
+            "tag":   "test",
+        })
+        if err := pushToUser(db, userID, payload); err != nil {
+            log.Printf("Failed to send test push to user %d: %v", userID, err)
+            c.JSON(502, gin.H{"error": "Failed to send test notification"})
+            return
+        }
+        c.JSON(200, gin.H{"message": "Test notification sent"})
+    })
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/hkdf"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "io"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func mustDecodeBase64URL(t *testing.T, value string) []byte {
+    t.Helper()
+    raw, err := decodeBase64URL(value)
+    if err != nil {
+        t.Fatalf("decode %q: %v", value, err)
+    }
+    return raw
+}
+
+// decryptPushPayload is the user agent's half of RFC 8291, for checking
+// what we send.
+func decryptPushPayload(t *testing.T, body []byte, uaPrivate *ecdh.PrivateKey, auth []byte) []byte {
+    t.Helper()
+    if len(body) < 21 {
+        t.Fatalf("body is only %d bytes", len(body))
+    }
+    salt := body[:16]
+    if rs := binary.BigEndian.Uint32(body[16:20]); rs != pushRecordSize {
+        t.Errorf("record size = %d, want %d", rs, pushRecordSize)
+    }
+    idlen := int(body[20])
+    asPublicBytes := body[21 : 21+idlen]
+    record := body[21+idlen:]
+
+    asPublic, err := ecdh.P256().NewPublicKey(asPublicBytes)
+    if err != nil {
+        t.Fatalf("parse keyid: %v", err)
+    }
+    ecdhSecret, err := uaPrivate.ECDH(asPublic)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyInfo := "WebPush: info\x00" + string(uaPrivate.PublicKey().Bytes()) + string(asPublicBytes)
+    ikm, _ := hkdf.Key(sha256.New, ecdhSecret, auth, keyInfo, 32)
+    prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+    cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+    nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+
+    block, _ := aes.NewCipher(cek)
+    gcm, _ := cipher.NewGCM(block)
+    plain, err := gcm.Open(nil, nonce, record, nil)
+    if err != nil {
+        t.Fatalf("decrypt record: %v", err)
+    }
+    // Strip padding back to the last-record delimiter
+    end := bytes.LastIndexByte(plain, 0x02)
+    if end < 0 || len(bytes.Trim(plain[end+1:], "\x00")) != 0 {
+        t.Fatalf("record has no 0x02 delimiter")
+    }
+    return plain[:end]
+}
+
+// RFC 8291 Appendix A
+func TestEncryptPushPayloadRFC8291Vector(t *testing.T) {
+    asPrivate, err := ecdh.P256().NewPrivateKey(mustDecodeBase64URL(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    plaintext := mustDecodeBase64URL(t, "V2hlbiBJIGdyb3cgdXAsIEkgd2FudCB0byBiZSBhIHdhdGVybWVsb24")
+    salt := mustDecodeBase64URL(t, "DGv6ra1nlYgDCS1FRnbzlw")
+
+    body, err := encryptPushPayloadWith(plaintext,
+        "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+        "BTBZMqHH6r4Tts7J_aSIgg", asPrivate, salt)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+    if got := base64.RawURLEncoding.EncodeToString(body); got != want {
+        t.Errorf("body =\n%s\nwant\n%s", got, want)
+    }
+
+    uaPrivate, err := ecdh.P256().NewPrivateKey(mustDecodeBase64URL(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := decryptPushPayload(t, body, uaPrivate, mustDecodeBase64URL(t, "BTBZMqHH6r4Tts7J_aSIgg")); !bytes.Equal(got, plaintext) {
+        t.Errorf("decrypted %q, want %q", got, plaintext)
+    }
+}
+
+func newTestSubscriber(t *testing.T) (*ecdh.PrivateKey, []byte, string, string) {
+    t.Helper()
+    uaPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    auth := make([]byte, 16)
+    rand.Read(auth)
+    return uaPrivate, auth,
+        base64.RawURLEncoding.EncodeToString(uaPrivate.PublicKey().Bytes()),
+        base64.RawURLEncoding.EncodeToString(auth)
+}
+
+func TestEncryptPushPayloadRoundTrip(t *testing.T) {
+    uaPrivate, auth, p256dh, authSecret := newTestSubscriber(t)
+
+    for _, payload := range [][]byte{
+        []byte(`{"title":"Bella is ready for pickup"}`),
+        {},
+        bytes.Repeat([]byte("x"), pushMaxPayloadSize),
+    } {
+        body, err := encryptPushPayload(payload, p256dh, authSecret)
+        if err != nil {
+            t.Fatalf("encrypt %d bytes: %v", len(payload), err)
+        }
+        if len(body) > pushRecordSize {
+            t.Errorf("%d byte payload encrypted to %d bytes, over the %d limit", len(payload), len(body), pushRecordSize)
+        }
+        if got := decryptPushPayload(t, body, uaPrivate, auth); !bytes.Equal(got, payload) {
+            t.Errorf("round trip of %d bytes came back as %d bytes", len(payload), len(got))
+        }
+    }
+
+    if _, err := encryptPushPayload(bytes.Repeat([]byte("x"), pushMaxPayloadSize+1), p256dh, authSecret); err == nil {
+        t.Error("expected an error for an oversized payload")
+    }
+}
+
+// verifyVAPID checks an RFC 8292 Authorization header and returns its claims.
+func verifyVAPID(t *testing.T, header string, publicKey string) map[string]interface{} {
+    t.Helper()
+    var token, key string
+    for _, part := range strings.Split(strings.TrimPrefix(header, "vapid "), ", ") {
+        switch {
+        case strings.HasPrefix(part, "t="):
+            token = part[2:]
+        case strings.HasPrefix(part, "k="):
+            key = part[2:]
+        }
+    }
+    if !strings.HasPrefix(header, "vapid ") || token == "" || key != publicKey {
+        t.Fatalf("Authorization = %q, want vapid t=<jwt>, k=%s", header, publicKey)
+    }
+
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        t.Fatalf("JWT has %d parts", len(parts))
+    }
+    point := mustDecodeBase64URL(t, key)
+    public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(point[1:33]), Y: new(big.Int).SetBytes(point[33:])}
+    signature := mustDecodeBase64URL(t, parts[2])
+    digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+    if len(signature) != 64 || !ecdsa.Verify(public, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+        t.Fatal("JWT signature doesn't verify against the VAPID public key")
+    }
+
+    var claims map[string]interface{}
+    if err := json.Unmarshal(mustDecodeBase64URL(t, parts[1]), &claims); err != nil {
+        t.Fatal(err)
+    }
+    return claims
+}
+
+func TestSendWebPushToStubService(t *testing.T) {
+    keys, err := generateVAPIDKeys()
+    if err != nil {
+        t.Fatal(err)
+    }
+    keys.Subject = "mailto:test@example.com"
+    uaPrivate, auth, p256dh, authSecret := newTestSubscriber(t)
+    payload := []byte(`{"title":"Reminder","body":"Bath tomorrow at 10:00"}`)
+
+    status := 201
+    var received []byte
+    srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        claims := verifyVAPID(t, r.Header.Get("Authorization"), keys.PublicKey)
+        if aud := claims["aud"]; aud != "https://"+r.Host {
+            t.Errorf("aud = %v, want https://%s", aud, r.Host)
+        }
+        if sub := claims["sub"]; sub != keys.Subject {
+            t.Errorf("sub = %v, want %s", sub, keys.Subject)
+        }
+        if exp, _ := claims["exp"].(float64); time.Unix(int64(exp), 0).After(time.Now().Add(24 * time.Hour)) {
+            t.Errorf("exp is more than 24 hours out")
+        }
+        if got := r.Header.Get("Content-Encoding"); got != "aes128gcm" {
+            t.Errorf("Content-Encoding = %q, want aes128gcm", got)
+        }
+        if got := r.Header.Get("TTL"); got != "3600" {
+            t.Errorf("TTL = %q, want 3600", got)
+        }
+        received, _ = io.ReadAll(r.Body)
+        w.WriteHeader(status)
+        w.Write([]byte("internal detail from the push service"))
+    }))
+    defer srv.Close()
+
+    defaultClient := pushClient
+    pushClient = srv.Client()
+    defer func() { pushClient = defaultClient }()
+
+    sub := PushSubscription{ID: 1, Endpoint: srv.URL + "/push/abc", P256dh: p256dh, Auth: authSecret}
+    if err := sendWebPush(keys, sub, payload, time.Hour); err != nil {
+        t.Fatalf("sendWebPush: %v", err)
+    }
+    if got := decryptPushPayload(t, received, uaPrivate, auth); !bytes.Equal(got, payload) {
+        t.Errorf("push service got %q, want %q", got, payload)
+    }
+
+    status = 410
+    if err := sendWebPush(keys, sub, payload, time.Hour); err != errPushSubscriptionGone {
+        t.Errorf("410 gave %v, want errPushSubscriptionGone", err)
+    }
+
+    status = 500
+    err = sendWebPush(keys, sub, payload, time.Hour)
+    if err == nil || strings.Contains(err.Error(), "internal detail") {
+        t.Errorf("500 gave %v, want an error without the response body", err)
+    }
+}
+
+func TestPushClientRefusesInternalAddresses(t *testing.T) {
+    keys, _ := generateVAPIDKeys()
+    _, _, p256dh, authSecret := newTestSubscriber(t)
+    called := false
+    srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        called = true
+    }))
+    defer srv.Close()
+
+    sub := PushSubscription{ID: 1, Endpoint: srv.URL, P256dh: p256dh, Auth: authSecret}
+    if err := sendWebPush(keys, sub, []byte("{}"), time.Hour); err == nil || called {
+        t.Errorf("push to %s succeeded, want it refused", srv.URL)
+    }
+
+    sub.Endpoint = strings.Replace(srv.URL, "https://", "http://", 1)
+    if err := sendWebPush(keys, sub, []byte("{}"), time.Hour); err == nil || called {
+        t.Errorf("push to %s succeeded, want it refused", sub.Endpoint)
+    }
+}
+
+func TestValidatePushEndpoint(t *testing.T) {
+    tests := []struct {
+        endpoint string
+        ok       bool
+    }{
+        {"https://8.8.8.8/push/abc", true},
+        {"https://[2606:4700:4700::1111]/push/abc", true},
+        {"http://8.8.8.8/push/abc", false},
+        {"ftp://8.8.8.8/push/abc", false},
+        {"https://127.0.0.1/push", false},
+        {"https://localhost:8081/api/v1/admin/users", false},
+        {"https://10.0.0.5/push", false},
+        {"https://192.168.1.1/push", false},
+        {"https://172.16.0.1/push", false},
+        {"https://100.64.0.1/push", false},
+        {"https://169.254.169.254/latest/meta-data/", false},
+        {"https://[::1]/push", false},
+        {"https://[fe80::1]/push", false},
+        {"https://[fd00::1]/push", false},
+        {"https://0.0.0.0/push", false},
+        {"not a url", false},
+    }
+    for _, tt := range tests {
+        if err := validatePushEndpoint(tt.endpoint); (err == nil) != tt.ok {
+            t.Errorf("validatePushEndpoint(%q) = %v, want ok=%v", tt.endpoint, err, tt.ok)
+        }
+    }
+}
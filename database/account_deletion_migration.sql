@@ -0,0 +1,10 @@
+-- Account Deletion Migration
+-- Supports anonymizing customers who ask to delete their account
+
+-- 1. Record when an account was anonymized (status becomes 'deleted')
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+COMMENT ON COLUMN users.deleted_at IS 'When the customer was anonymized; payments are kept for tax retention';
+
+\echo 'Account deletion migration completed successfully!';
+\echo 'Updated tables: users';
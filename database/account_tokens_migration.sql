@@ -0,0 +1,36 @@
+-- Account Tokens Migration
+-- Single-use hashed tokens for password reset and email verification
+
+-- 1. Create account_tokens table (only the SHA-256 of each token is stored)
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(30) NOT NULL, -- password_reset, email_verification
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (purpose IN ('password_reset', 'email_verification'))
+);
+
+-- 2. Track verified email addresses
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
+-- Accounts that predate verification are trusted as-is
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
+
+-- 3. Settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('security', 'password_reset_minutes', '60', 'number', 'Minutes a password reset link stays valid'),
+('security', 'email_verification_hours', '48', 'number', 'Hours an email verification link stays valid'),
+('security', 'require_email_verification', 'false', 'boolean', 'Block booking until the customer verifies their email')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 4. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user_id ON account_tokens(user_id, purpose);
+
+COMMENT ON TABLE account_tokens IS 'Hashed single-use tokens for password reset and email verification';
+
+\echo 'Account tokens migration completed successfully!';
+\echo 'Created table: account_tokens';
+\echo 'Added users.email_verified_at and security token settings';
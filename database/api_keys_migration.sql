@@ -0,0 +1,23 @@
+-- API Keys Migration
+-- Hashed, permission-scoped keys for integrations like the accounting sheet and kiosk
+
+-- 1. Create api_keys table
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL, -- shown in the admin list, e.g. jbh_ab12cd34
+    key_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 of the full key
+    permissions TEXT[] NOT NULL, -- subset of the permission catalog
+    allowed_ips TEXT[], -- addresses or CIDR ranges; empty allows any
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    last_used_ip INET,
+    created_by INTEGER REFERENCES users(id),
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE api_keys IS 'Integration API keys; only hashes are stored';
+
+\echo 'API keys migration completed successfully!';
+\echo 'Created tables: api_keys';
@@ -0,0 +1,33 @@
+-- Appointment Reminders Migration
+-- Tracks which reminders have gone out so each one is sent exactly once
+
+-- 1. Create appointment_reminders table (one row per appointment and offset)
+CREATE TABLE IF NOT EXISTS appointment_reminders (
+    id SERIAL PRIMARY KEY,
+    appointment_id INTEGER REFERENCES appointments(id) ON DELETE CASCADE,
+    offset_minutes INTEGER NOT NULL,
+    status VARCHAR(20) DEFAULT 'sending', -- sending, sent, failed
+    channels VARCHAR(100),
+    error TEXT,
+    sent_at TIMESTAMP,
+    claimed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(appointment_id, offset_minutes)
+);
+
+-- Claims stuck in 'sending' after a crash are retaken once claimed_at is stale
+ALTER TABLE appointment_reminders ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMP;
+
+-- 2. Reminder timing
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('notifications', 'reminder_offsets_hours', '24,2', 'string', 'Comma-separated hours before an appointment to send reminders')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 3. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_appointment_reminders_appointment_id ON appointment_reminders(appointment_id);
+
+COMMENT ON TABLE appointment_reminders IS 'Reminders claimed or sent per appointment; the unique key prevents double-sends across restarts and replicas';
+
+\echo 'Appointment reminders migration completed successfully!';
+\echo 'Created table: appointment_reminders';
+\echo 'Added notifications.reminder_offsets_hours setting';
@@ -0,0 +1,48 @@
+-- Business Closures Migration
+-- Adds dated closures and special hours on top of the weekly business_hours template
+
+-- 1. Create business_closures table (one entry per date)
+CREATE TABLE IF NOT EXISTS business_closures (
+    id SERIAL PRIMARY KEY,
+    closure_date DATE NOT NULL UNIQUE,
+    closure_type VARCHAR(20) NOT NULL DEFAULT 'closed', -- closed, special_hours
+    open_time TIME,
+    close_time TIME,
+    reason TEXT,
+    created_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (closure_type IN ('closed', 'special_hours')),
+    CHECK (closure_type = 'closed' OR (open_time IS NOT NULL AND close_time IS NOT NULL AND close_time > open_time))
+);
+
+-- 2. Flag bookings that land on a newly closed date for staff follow-up
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS needs_followup BOOLEAN DEFAULT FALSE;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS followup_reason TEXT;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS followup_source VARCHAR(20); -- closure when set by the hours check, so it can be cleared again
+
+-- 3. Flag payments taken for a booking on a closed date
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS needs_followup BOOLEAN DEFAULT FALSE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS followup_reason TEXT;
+
+-- 4. Slot listing granularity
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('scheduling', 'slot_interval_minutes', '30', 'number', 'Spacing between bookable start times in slot listings')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 5. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_appointments_needs_followup ON appointments(needs_followup) WHERE needs_followup = TRUE;
+CREATE INDEX IF NOT EXISTS idx_payments_needs_followup ON payments(needs_followup) WHERE needs_followup = TRUE;
+
+DROP TRIGGER IF EXISTS update_business_closures_updated_at ON business_closures;
+CREATE TRIGGER update_business_closures_updated_at
+    BEFORE UPDATE ON business_closures
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+COMMENT ON TABLE business_closures IS 'Holiday closures and special hours that override the weekly business_hours settings';
+
+\echo 'Business closures migration completed successfully!';
+\echo 'Created table: business_closures';
+\echo 'Added needs_followup, followup_reason and followup_source to appointments';
+\echo 'Added needs_followup and followup_reason to payments';
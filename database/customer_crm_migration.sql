@@ -0,0 +1,44 @@
+-- Customer CRM Migration
+-- Staff notes and tags on customer profiles, plus no-show tracking
+
+-- 1. Create customer_notes table
+CREATE TABLE IF NOT EXISTS customer_notes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    body TEXT NOT NULL,
+    pinned BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_notes_user ON customer_notes(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_customer_notes_search ON customer_notes USING GIN (to_tsvector('english', body));
+
+-- 2. Create customer_tags table
+CREATE TABLE IF NOT EXISTS customer_tags (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tag VARCHAR(50) NOT NULL, -- e.g. 'VIP', 'aggressive dog', 'prefers Sarah'
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customer_tags_unique ON customer_tags(user_id, LOWER(tag));
+CREATE INDEX IF NOT EXISTS idx_customer_tags_tag ON customer_tags(LOWER(tag));
+
+-- 3. Allow marking appointments as no-shows
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS valid_appointment_status;
+ALTER TABLE appointments ADD CONSTRAINT valid_appointment_status
+    CHECK (status IN ('pending', 'confirmed', 'in_progress', 'completed', 'cancelled', 'no_show'));
+
+-- 4. Loyalty settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('loyalty', 'washes_per_reward', '5', 'integer', 'Washes needed to earn a free wash')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+COMMENT ON TABLE customer_notes IS 'Internal staff notes on customers; never shown to the customer';
+COMMENT ON TABLE customer_tags IS 'Free-form staff labels on customers, unique per customer ignoring case';
+
+\echo 'Customer CRM migration completed successfully!';
+\echo 'Created tables: customer_notes, customer_tags';
+\echo 'Updated tables: appointments';
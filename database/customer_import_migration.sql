@@ -0,0 +1,27 @@
+-- Customer Import Migration
+-- Supports importing customers from spreadsheets as password-less accounts
+-- that customers claim later through an invite link
+
+-- 1. Allow account invite tokens, keeping every purpose earlier migrations
+-- added (login_challenge is from two_factor_migration.sql)
+ALTER TABLE account_tokens DROP CONSTRAINT IF EXISTS account_tokens_purpose_check;
+ALTER TABLE account_tokens ADD CONSTRAINT account_tokens_purpose_check
+    CHECK (purpose IN ('password_reset', 'email_verification', 'login_challenge', 'account_invite'));
+
+-- 2. Track imported accounts and when they were claimed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS imported_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMP;
+
+-- 3. Phone lookups used to match re-imported rows
+CREATE INDEX IF NOT EXISTS idx_users_phone_key ON users (RIGHT(regexp_replace(COALESCE(phone, ''), '\D', '', 'g'), 10));
+
+-- 4. Settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('security', 'account_invite_days', '14', 'number', 'Days an imported customer''s claim-your-account link stays valid')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+COMMENT ON COLUMN users.imported_at IS 'Set for accounts created by a CSV import; they have no password until claimed';
+COMMENT ON COLUMN users.claimed_at IS 'When an imported customer set their password through an invite link';
+
+\echo 'Customer import migration completed successfully!';
+\echo 'Updated tables: account_tokens, users';
@@ -0,0 +1,31 @@
+-- Duplicate Customers Migration
+-- Flags likely duplicate customer accounts and records merges
+
+-- 1. Create duplicate_candidates table (user_id is always the lower ID of the pair)
+CREATE TABLE IF NOT EXISTS duplicate_candidates (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    other_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    score NUMERIC(3,2) NOT NULL,
+    reasons TEXT[] NOT NULL, -- same_phone, same_email, similar_email, same_email_name, same_name, similar_name
+    status VARCHAR(20) DEFAULT 'pending', -- pending, dismissed, merged
+    detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP,
+    UNIQUE(user_id, other_user_id),
+    CHECK (user_id < other_user_id),
+    CHECK (status IN ('pending', 'dismissed', 'merged'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_duplicate_candidates_status ON duplicate_candidates(status, score DESC);
+CREATE INDEX IF NOT EXISTS idx_duplicate_candidates_other ON duplicate_candidates(other_user_id);
+
+-- 2. Merged accounts point at the account that absorbed them (status becomes 'merged')
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into_id INTEGER REFERENCES users(id);
+
+COMMENT ON TABLE duplicate_candidates IS 'Likely duplicate customer pairs found by the hourly scan';
+COMMENT ON COLUMN users.merged_into_id IS 'Surviving account this one was merged into';
+
+\echo 'Duplicate customers migration completed successfully!';
+\echo 'Created tables: duplicate_candidates';
+\echo 'Updated tables: users';
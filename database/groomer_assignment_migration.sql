@@ -0,0 +1,60 @@
+-- Groomer Assignment Migration
+-- Links appointments to groomers and adds per-groomer hours and time off
+
+-- 1. Flag which staff members take grooming appointments
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS is_groomer BOOLEAN DEFAULT FALSE;
+
+-- 2. Assigned and customer-requested groomer on appointments
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS groomer_id INTEGER REFERENCES admin_users(id) ON DELETE SET NULL;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS requested_groomer_id INTEGER REFERENCES admin_users(id) ON DELETE SET NULL;
+ALTER TABLE appointment_series ADD COLUMN IF NOT EXISTS requested_groomer_id INTEGER REFERENCES admin_users(id) ON DELETE SET NULL;
+
+-- 3. Weekly working hours per groomer
+CREATE TABLE IF NOT EXISTS groomer_hours (
+    id SERIAL PRIMARY KEY,
+    admin_user_id INTEGER REFERENCES admin_users(id) ON DELETE CASCADE,
+    day_of_week INTEGER NOT NULL CHECK (day_of_week BETWEEN 0 AND 6), -- 0 = Sunday
+    start_time TIME NOT NULL,
+    end_time TIME NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    CHECK (end_time > start_time)
+);
+
+-- 4. Time off (whole days when start_time/end_time are NULL)
+CREATE TABLE IF NOT EXISTS groomer_time_off (
+    id SERIAL PRIMARY KEY,
+    admin_user_id INTEGER REFERENCES admin_users(id) ON DELETE CASCADE,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    start_time TIME,
+    end_time TIME,
+    reason TEXT,
+    created_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (end_date >= start_date)
+);
+
+-- 5. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_appointments_groomer_date ON appointments(groomer_id, appointment_date);
+CREATE INDEX IF NOT EXISTS idx_groomer_hours_admin_user_id ON groomer_hours(admin_user_id);
+CREATE INDEX IF NOT EXISTS idx_groomer_time_off_admin_user_id ON groomer_time_off(admin_user_id, start_date, end_date);
+
+-- 6. Seed the demo groomer with the shop's grooming schedule
+UPDATE admin_users SET is_groomer = TRUE
+WHERE user_id IN (SELECT id FROM users WHERE email = 'sarah@jakesbathhouse.com');
+
+INSERT INTO groomer_hours (admin_user_id, day_of_week, start_time, end_time)
+SELECT au.id, ss.day_of_week, ss.start_time, ss.end_time
+FROM admin_users au
+CROSS JOIN staff_schedule ss
+WHERE au.is_groomer = TRUE AND ss.service_type = 'groom' AND ss.active = TRUE
+  AND NOT EXISTS (SELECT 1 FROM groomer_hours gh WHERE gh.admin_user_id = au.id);
+
+COMMENT ON TABLE groomer_hours IS 'Weekly working hours per groomer';
+COMMENT ON TABLE groomer_time_off IS 'Groomer vacations, sick days and partial-day blocks';
+COMMENT ON COLUMN appointments.groomer_id IS 'Groomer (admin_users.id) assigned to the appointment';
+COMMENT ON COLUMN appointments.requested_groomer_id IS 'Groomer the customer asked for, if any';
+
+\echo 'Groomer assignment migration completed successfully!';
+\echo 'Created tables: groomer_hours, groomer_time_off';
+\echo 'Added groomer_id and requested_groomer_id to appointments';
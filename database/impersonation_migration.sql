@@ -0,0 +1,19 @@
+-- Impersonation Migration
+-- Lets support staff act as a customer through a flagged, time-limited session
+
+-- 1. Flag impersonation sessions with the admin behind them
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS impersonator_id INTEGER REFERENCES users(id);
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS impersonation_reason TEXT;
+
+-- 2. Settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('security', 'impersonation_minutes', '30', 'number', 'Minutes an act-as-customer session lasts')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 3. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_user_sessions_impersonator_id ON user_sessions(impersonator_id) WHERE impersonator_id IS NOT NULL;
+
+COMMENT ON COLUMN user_sessions.impersonator_id IS 'Admin acting as this customer; every request is written to audit_logs';
+
+\echo 'Impersonation migration completed successfully!';
+\echo 'Updated tables: user_sessions';
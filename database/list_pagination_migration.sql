@@ -0,0 +1,17 @@
+-- List Pagination Migration
+-- Indexes matching the default sort of each paged list, so fetching the
+-- next page is an index range scan instead of a sort of the whole table
+
+-- 1. Appointments by date and time (admin and customer lists)
+CREATE INDEX IF NOT EXISTS idx_appointments_starts_at ON appointments ((appointment_date + appointment_time), id);
+CREATE INDEX IF NOT EXISTS idx_appointments_user_starts_at ON appointments (user_id, (appointment_date + appointment_time), id);
+
+-- 2. Customers and staff, newest first
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_admin_users_created_at ON admin_users (created_at, id);
+
+-- 3. Photos, newest first
+CREATE INDEX IF NOT EXISTS idx_pet_photos_created_at ON pet_photos (created_at, id);
+
+\echo 'List pagination migration completed successfully!';
+\echo 'Created indexes on appointments, users, admin_users, pet_photos';
@@ -0,0 +1,28 @@
+-- Login Throttle Migration
+-- Failed sign-in tracking per account and per IP, with backoff and lockout
+
+-- 1. Create login_throttles table
+CREATE TABLE IF NOT EXISTS login_throttles (
+    scope VARCHAR(10) NOT NULL CHECK (scope IN ('account', 'ip')),
+    throttle_key VARCHAR(255) NOT NULL, -- lowercased email or client IP
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP,
+    locked_until TIMESTAMP,
+    PRIMARY KEY (scope, throttle_key)
+);
+
+-- 2. Settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('security', 'login_max_attempts', '5', 'number', 'Failed sign-ins before an account is locked'),
+('security', 'login_ip_max_attempts', '20', 'number', 'Failed sign-ins from one IP before it is locked'),
+('security', 'login_lockout_minutes', '15', 'number', 'Minutes an account or IP stays locked'),
+('security', 'login_backoff_seconds', '1', 'number', 'Base delay between attempts, doubling with each failure')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 3. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_login_throttles_locked_until ON login_throttles(locked_until);
+
+COMMENT ON TABLE login_throttles IS 'Failed sign-in counters and temporary lockouts';
+
+\echo 'Login throttle migration completed successfully!';
+\echo 'Created tables: login_throttles';
@@ -0,0 +1,64 @@
+-- Notifications Migration
+-- Outbox, delivery log and in-app inbox for the notification dispatcher
+
+-- 1. Create notification_outbox table (one row per event per channel)
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    channel VARCHAR(20) NOT NULL, -- email, sms, in_app
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    data JSONB,
+    status VARCHAR(20) DEFAULT 'pending', -- pending, sending, sent, failed
+    attempts INTEGER DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    claimed_at TIMESTAMP,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (channel IN ('email', 'sms', 'in_app')),
+    CHECK (status IN ('pending', 'sending', 'sent', 'failed'))
+);
+
+-- 2. Create notification_deliveries table (one row per send attempt)
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    id SERIAL PRIMARY KEY,
+    outbox_id INTEGER REFERENCES notification_outbox(id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL,
+    recipient VARCHAR(255),
+    status VARCHAR(20) NOT NULL, -- sent, failed
+    error TEXT,
+    attempted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 3. Create notifications table (in-app inbox)
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    data JSONB,
+    read_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 4. Settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('notifications', 'max_attempts', '5', 'number', 'Delivery attempts per notification before it is marked failed')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 5. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_due ON notification_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_user_id ON notification_outbox(user_id);
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_outbox_id ON notification_deliveries(outbox_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, created_at DESC);
+
+COMMENT ON TABLE notification_outbox IS 'Rendered notifications waiting for or finished with delivery';
+COMMENT ON TABLE notification_deliveries IS 'Log of every delivery attempt';
+COMMENT ON TABLE notifications IS 'In-app notification inbox';
+
+\echo 'Notifications migration completed successfully!';
+\echo 'Created tables: notification_outbox, notification_deliveries, notifications';
+\echo 'Added notifications.max_attempts setting';
@@ -0,0 +1,29 @@
+-- Password Policy Migration
+-- Settings-driven password rules and history to block reuse
+
+-- 1. Create password_history table (previous bcrypt hashes)
+CREATE TABLE IF NOT EXISTS password_history (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 2. Settings (password_min_length already exists from the admin system migration)
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('security', 'password_min_length', '6', 'number', 'Minimum password length'),
+('security', 'password_require_uppercase', 'false', 'boolean', 'Passwords must contain an uppercase letter'),
+('security', 'password_require_lowercase', 'false', 'boolean', 'Passwords must contain a lowercase letter'),
+('security', 'password_require_digit', 'true', 'boolean', 'Passwords must contain a number'),
+('security', 'password_require_symbol', 'false', 'boolean', 'Passwords must contain a symbol'),
+('security', 'password_reject_common', 'true', 'boolean', 'Reject passwords from the common password list'),
+('security', 'password_history_count', '5', 'number', 'Number of recent passwords that cannot be reused')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 3. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_password_history_user_id ON password_history(user_id, created_at);
+
+COMMENT ON TABLE password_history IS 'Previous password hashes, kept to prevent reuse';
+
+\echo 'Password policy migration completed successfully!';
+\echo 'Created tables: password_history';
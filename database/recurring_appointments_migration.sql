@@ -0,0 +1,53 @@
+-- Recurring Appointments Migration
+-- Adds recurring series that generate future appointments
+
+-- 1. Create appointment_series table
+CREATE TABLE IF NOT EXISTS appointment_series (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    pet_id INTEGER REFERENCES pets(id) ON DELETE CASCADE,
+    service_id INTEGER REFERENCES services(id),
+    interval_weeks INTEGER NOT NULL CHECK (interval_weeks BETWEEN 1 AND 52),
+    day_of_week INTEGER NOT NULL CHECK (day_of_week BETWEEN 0 AND 6), -- 0 = Sunday
+    appointment_time TIME NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE,
+    occurrence_count INTEGER, -- either end_date or occurrence_count is set
+    status VARCHAR(20) DEFAULT 'active', -- active, cancelled
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (end_date IS NOT NULL OR occurrence_count IS NOT NULL)
+);
+
+-- 2. Link generated appointments back to their series
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS series_id INTEGER REFERENCES appointment_series(id) ON DELETE SET NULL;
+
+-- 3. Track occurrences that were skipped (conflicts or customer request)
+CREATE TABLE IF NOT EXISTS appointment_series_skips (
+    id SERIAL PRIMARY KEY,
+    series_id INTEGER REFERENCES appointment_series(id) ON DELETE CASCADE,
+    occurrence_date DATE NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(series_id, occurrence_date)
+);
+
+-- 4. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_appointments_series_id ON appointments(series_id);
+CREATE INDEX IF NOT EXISTS idx_appointment_series_user_id ON appointment_series(user_id);
+CREATE INDEX IF NOT EXISTS idx_appointment_series_skips_series_id ON appointment_series_skips(series_id);
+
+-- 5. Keep updated_at current
+DROP TRIGGER IF EXISTS update_appointment_series_updated_at ON appointment_series;
+CREATE TRIGGER update_appointment_series_updated_at
+    BEFORE UPDATE ON appointment_series
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+COMMENT ON TABLE appointment_series IS 'Recurring appointment definitions (every N weeks on a weekday)';
+COMMENT ON TABLE appointment_series_skips IS 'Series occurrences that were skipped or not generated';
+
+\echo 'Recurring appointments migration completed successfully!';
+\echo 'Created tables: appointment_series, appointment_series_skips';
+\echo 'Added series_id to appointments';
@@ -0,0 +1,58 @@
+-- Appointment Reschedule Migration
+-- Adds reschedule cutoff setting and richer appointment history
+
+-- 1. Reschedule cutoff setting
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('scheduling', 'reschedule_cutoff_hours', '24', 'number', 'Hours before an appointment after which it can no longer be rescheduled')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 2. Let the history trigger pick up who made the change and why.
+-- The API sets app.current_user_id / app.change_reason with set_config(..., true)
+-- inside the transaction that updates the appointment.
+CREATE OR REPLACE FUNCTION log_appointment_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (OLD.status IS DISTINCT FROM NEW.status) OR
+       (OLD.appointment_date IS DISTINCT FROM NEW.appointment_date) OR
+       (OLD.appointment_time IS DISTINCT FROM NEW.appointment_time) THEN
+
+        INSERT INTO appointment_history (
+            appointment_id,
+            old_status,
+            new_status,
+            old_date_time,
+            new_date_time,
+            changed_by,
+            change_reason
+        ) VALUES (
+            NEW.id,
+            OLD.status,
+            NEW.status,
+            (OLD.appointment_date + OLD.appointment_time),
+            (NEW.appointment_date + NEW.appointment_time),
+            NULLIF(current_setting('app.current_user_id', true), '')::INTEGER,
+            COALESCE(
+                NULLIF(current_setting('app.change_reason', true), ''),
+                CASE
+                    WHEN OLD.status IS DISTINCT FROM NEW.status THEN 'Status changed'
+                    WHEN (OLD.appointment_date IS DISTINCT FROM NEW.appointment_date) OR
+                         (OLD.appointment_time IS DISTINCT FROM NEW.appointment_time) THEN 'Rescheduled'
+                    ELSE 'Updated'
+                END
+            )
+        );
+    END IF;
+
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS log_appointment_changes ON appointments;
+CREATE TRIGGER log_appointment_changes
+    AFTER UPDATE ON appointments
+    FOR EACH ROW
+    EXECUTE FUNCTION log_appointment_changes();
+
+\echo 'Reschedule migration completed successfully!';
+\echo 'Added scheduling.reschedule_cutoff_hours setting';
+\echo 'appointment_history now records changed_by and change_reason';
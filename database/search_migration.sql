@@ -0,0 +1,27 @@
+-- Search Migration
+-- Full-text and trigram indexes behind the admin search box. The indexed
+-- expressions must stay identical to the ones in backend/search.go.
+
+-- 1. Trigram matching for typos and fragments
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- 2. Customers: name, email and phone words, fuzzy name, phone digits
+CREATE INDEX IF NOT EXISTS idx_users_search ON users
+    USING GIN (to_tsvector('simple', COALESCE(name, '') || ' ' || COALESCE(email, '') || ' ' || COALESCE(phone, '')));
+CREATE INDEX IF NOT EXISTS idx_users_name_trgm ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_phone_digits_trgm ON users
+    USING GIN (regexp_replace(COALESCE(phone, ''), '\D', '', 'g') gin_trgm_ops);
+
+-- 3. Pets: name, breed and notes
+CREATE INDEX IF NOT EXISTS idx_pets_search ON pets
+    USING GIN (to_tsvector('simple', COALESCE(name, '') || ' ' || COALESCE(breed, '') || ' ' || COALESCE(notes, '')));
+CREATE INDEX IF NOT EXISTS idx_pets_name_trgm ON pets USING GIN (name gin_trgm_ops);
+
+-- 4. Appointment notes
+CREATE INDEX IF NOT EXISTS idx_appointments_notes_search ON appointments
+    USING GIN (to_tsvector('english', COALESCE(notes, '')));
+
+\echo 'Search migration completed successfully!';
+\echo 'Enabled extension: pg_trgm';
+\echo 'Created indexes on users, pets, appointments';
@@ -0,0 +1,23 @@
+-- Sessions Migration
+-- Bearer-token sessions so the API knows who is calling
+
+-- 1. Create user_sessions table (SHA-256 token hashes, sliding expiry)
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    ip_address INET,
+    user_agent TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 2. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id);
+
+COMMENT ON TABLE user_sessions IS 'Signed-in API sessions; expiry slides by security.session_timeout';
+
+\echo 'Sessions migration completed successfully!';
+\echo 'Created tables: user_sessions';
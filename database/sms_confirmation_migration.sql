@@ -0,0 +1,41 @@
+-- SMS Confirmation Migration
+-- Two-way texting: "Reply C to confirm, R to reschedule" plus a conversation log
+
+-- 1. Track when a confirmation text went out (one per appointment)
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS confirmation_requested_at TIMESTAMP;
+
+-- The customer's answer, kept apart from status since most bookings are
+-- already 'confirmed' on our side when the text goes out
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS confirmation_reply VARCHAR(30);
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS confirmation_replied_at TIMESTAMP;
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_confirmation_reply_check;
+ALTER TABLE appointments ADD CONSTRAINT appointments_confirmation_reply_check
+    CHECK (confirmation_reply IN ('confirmed', 'reschedule_requested'));
+
+-- 2. Create sms_messages table (both directions)
+CREATE TABLE IF NOT EXISTS sms_messages (
+    id SERIAL PRIMARY KEY,
+    direction VARCHAR(10) NOT NULL, -- inbound, outbound
+    phone VARCHAR(50) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    appointment_id INTEGER REFERENCES appointments(id) ON DELETE SET NULL,
+    body TEXT NOT NULL,
+    action VARCHAR(50), -- confirmed, reschedule_requested, help, or the notification event for outbound texts
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (direction IN ('inbound', 'outbound'))
+);
+
+-- 3. Settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('sms', 'confirmation_hours_before', '24', 'number', 'Hours before an appointment to text the customer for confirmation')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 4. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_sms_messages_user_id ON sms_messages(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_sms_messages_phone ON sms_messages(phone);
+
+COMMENT ON TABLE sms_messages IS 'Inbound and outbound SMS conversation log';
+
+\echo 'SMS confirmation migration completed successfully!';
+\echo 'Created table: sms_messages';
+\echo 'Added appointments.confirmation_requested_at, confirmation_reply, confirmation_replied_at and sms.confirmation_hours_before setting';
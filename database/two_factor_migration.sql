@@ -0,0 +1,34 @@
+-- Two-Factor Authentication Migration
+-- TOTP secrets, recovery codes and short-lived login challenges
+
+-- 1. Create user_totp table (one authenticator per user)
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL, -- base32
+    confirmed_at TIMESTAMP, -- NULL while enrollment is pending
+    last_used_step BIGINT, -- blocks replay of an accepted code
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 2. Create totp_recovery_codes table (SHA-256 hashes, single use)
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 3. Allow login challenges in account_tokens
+ALTER TABLE account_tokens DROP CONSTRAINT IF EXISTS account_tokens_purpose_check;
+ALTER TABLE account_tokens ADD CONSTRAINT account_tokens_purpose_check
+    CHECK (purpose IN ('password_reset', 'email_verification', 'login_challenge'));
+
+-- 4. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_totp_recovery_codes_user_id ON totp_recovery_codes(user_id);
+
+COMMENT ON TABLE user_totp IS 'RFC 6238 TOTP secrets for two-factor sign-in';
+COMMENT ON TABLE totp_recovery_codes IS 'Hashed one-time recovery codes for two-factor sign-in';
+
+\echo 'Two-factor migration completed successfully!';
+\echo 'Created tables: user_totp, totp_recovery_codes';
@@ -0,0 +1,56 @@
+-- Waitlist Migration
+-- Lets customers queue for a fully booked day and get offered freed slots
+
+-- 1. Create waitlist_entries table
+CREATE TABLE IF NOT EXISTS waitlist_entries (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    pet_id INTEGER REFERENCES pets(id) ON DELETE CASCADE,
+    service_id INTEGER REFERENCES services(id),
+    waitlist_date DATE NOT NULL,
+    window_start TIME NOT NULL,
+    window_end TIME NOT NULL,
+    status VARCHAR(20) DEFAULT 'waiting', -- waiting, offered, booked, cancelled, expired
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (window_end > window_start)
+);
+
+-- 2. Create waitlist_offers table (one row per slot offered to an entry)
+CREATE TABLE IF NOT EXISTS waitlist_offers (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER REFERENCES waitlist_entries(id) ON DELETE CASCADE,
+    service_id INTEGER REFERENCES services(id),
+    offer_date DATE NOT NULL,
+    offer_time TIME NOT NULL,
+    status VARCHAR(20) DEFAULT 'pending', -- pending, accepted, declined, expired
+    appointment_id INTEGER REFERENCES appointments(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    responded_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 3. Hold time setting
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('scheduling', 'waitlist_hold_minutes', '30', 'number', 'Minutes a freed slot is held for a waitlisted customer before moving to the next person')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 4. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_waitlist_entries_date_status ON waitlist_entries(waitlist_date, status);
+CREATE INDEX IF NOT EXISTS idx_waitlist_entries_user_id ON waitlist_entries(user_id);
+CREATE INDEX IF NOT EXISTS idx_waitlist_offers_entry_id ON waitlist_offers(entry_id);
+CREATE INDEX IF NOT EXISTS idx_waitlist_offers_pending ON waitlist_offers(expires_at) WHERE status = 'pending';
+
+DROP TRIGGER IF EXISTS update_waitlist_entries_updated_at ON waitlist_entries;
+CREATE TRIGGER update_waitlist_entries_updated_at
+    BEFORE UPDATE ON waitlist_entries
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+COMMENT ON TABLE waitlist_entries IS 'Customers waiting for a slot on a fully booked day';
+COMMENT ON TABLE waitlist_offers IS 'Freed slots held for waitlisted customers';
+
+\echo 'Waitlist migration completed successfully!';
+\echo 'Created tables: waitlist_entries, waitlist_offers';
+\echo 'Added scheduling.waitlist_hold_minutes setting';
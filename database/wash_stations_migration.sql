@@ -0,0 +1,49 @@
+-- DIY Wash Station Migration
+-- Models wash stations as resources and adds a walk-in check-in queue
+
+-- 1. Create wash_stations table
+CREATE TABLE IF NOT EXISTS wash_stations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    status VARCHAR(20) DEFAULT 'free', -- free, in_use, cleaning
+    active BOOLEAN DEFAULT TRUE, -- inactive stations don't count toward capacity
+    status_changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (status IN ('free', 'in_use', 'cleaning'))
+);
+
+-- 2. Create diy_queue table for walk-ins and checked-in bookings
+CREATE TABLE IF NOT EXISTS diy_queue (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    pet_id INTEGER REFERENCES pets(id) ON DELETE SET NULL,
+    appointment_id INTEGER REFERENCES appointments(id) ON DELETE SET NULL,
+    service_id INTEGER REFERENCES services(id),
+    customer_name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) DEFAULT 'waiting', -- waiting, washing, done, left
+    station_id INTEGER REFERENCES wash_stations(id) ON DELETE SET NULL,
+    checked_in_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+-- 3. Settings
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('diy', 'cleaning_minutes', '10', 'number', 'Minutes to clean a wash station between customers')
+ON CONFLICT (category, setting_key) DO NOTHING;
+
+-- 4. Seed stations
+INSERT INTO wash_stations (name)
+SELECT 'Station ' || n FROM generate_series(1, 3) AS n
+WHERE NOT EXISTS (SELECT 1 FROM wash_stations);
+
+-- 5. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_diy_queue_status ON diy_queue(status, checked_in_at);
+CREATE INDEX IF NOT EXISTS idx_diy_queue_station_id ON diy_queue(station_id);
+
+COMMENT ON TABLE wash_stations IS 'Self-service wash stations and their live status';
+COMMENT ON TABLE diy_queue IS 'Walk-in and checked-in DIY customers waiting for or using a station';
+
+\echo 'Wash station migration completed successfully!';
+\echo 'Created tables: wash_stations, diy_queue';
+\echo 'Added diy.cleaning_minutes setting';
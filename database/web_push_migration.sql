@@ -0,0 +1,35 @@
+-- Web Push Migration
+-- Stores browser push subscriptions and allows push in the notification outbox
+
+-- 1. Create push_subscriptions table (one row per browser/device)
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    endpoint TEXT NOT NULL UNIQUE,
+    p256dh VARCHAR(255) NOT NULL,
+    auth VARCHAR(255) NOT NULL,
+    user_agent TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+-- 2. Allow the push channel in the outbox
+ALTER TABLE notification_outbox DROP CONSTRAINT IF EXISTS notification_outbox_channel_check;
+ALTER TABLE notification_outbox ADD CONSTRAINT notification_outbox_channel_check
+    CHECK (channel IN ('email', 'sms', 'in_app', 'push'));
+
+-- 3. VAPID contact. The key pair lives only in VAPID_PUBLIC_KEY and
+-- VAPID_PRIVATE_KEY; settings are readable by staff, so never store it here.
+INSERT INTO business_settings (category, setting_key, setting_value, data_type, description) VALUES
+('push', 'vapid_subject', 'mailto:hello@jakesbathhouse.local', 'string', 'Contact URI sent to push services')
+ON CONFLICT (category, setting_key) DO NOTHING;
+DELETE FROM business_settings WHERE category = 'push' AND setting_key IN ('vapid_public_key', 'vapid_private_key');
+
+-- 4. Create indexes for performance
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user_id ON push_subscriptions(user_id);
+
+COMMENT ON TABLE push_subscriptions IS 'Web Push subscriptions per user device';
+
+\echo 'Web Push migration completed successfully!';
+\echo 'Created table: push_subscriptions';
+\echo 'Added push.vapid_subject setting';